@@ -0,0 +1,146 @@
+/*
+Copyright 2020 The Cert-Manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The venafi-plugin command is the reference signer plugin. It serves the
+// plugin API on a Unix socket and signs certificates using Venafi TPP or
+// Venafi Cloud, like the venafi backend of the manager.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Venafi/vcert"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/cert-manager/signer-venafi/internal/plugin"
+	"github.com/cert-manager/signer-venafi/internal/signer/venafi"
+)
+
+// The time for which the result of a health check is reused, so that frequent
+// readiness probes do not each connect and authenticate to Venafi.
+const healthCheckInterval = 30 * time.Second
+
+func main() {
+	var (
+		socket          string
+		vcertConfigPath string
+		zone            string
+		debugLogging    bool
+	)
+	flag.StringVar(&socket, "socket", "/var/run/signer-venafi/plugin.sock", "The Unix socket on which to serve the plugin API.")
+	flag.StringVar(&vcertConfigPath, "vcert-config", "/etc/signer-venafi/vcert.ini", "Vcert INI file path.")
+	flag.StringVar(&zone, "zone", "", "The Venafi zone to which certificates are requested. Defaults to the zone in --vcert-config.")
+	flag.BoolVar(&debugLogging, "debug-logging", true, "Enable debug logging.")
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseDevMode(debugLogging)))
+	log := ctrl.Log.WithName("venafi-plugin")
+
+	srv, err := newServer(vcertConfigPath, zone, log)
+	if err != nil {
+		log.Error(err, "unable to create plugin server")
+		os.Exit(1)
+	}
+	l, err := plugin.Listen(socket)
+	if err != nil {
+		log.Error(err, "unable to listen", "socket", socket)
+		os.Exit(1)
+	}
+	s := grpc.NewServer()
+	plugin.RegisterSignerServer(s, srv)
+
+	stop := ctrl.SetupSignalHandler()
+	go func() {
+		<-stop
+		log.Info("stopping plugin")
+		s.GracefulStop()
+	}()
+
+	log.Info("serving plugin", "socket", socket)
+	if err := s.Serve(l); err != nil {
+		log.Error(err, "problem serving plugin")
+		os.Exit(1)
+	}
+}
+
+// newServer returns a plugin server which signs certificates with a
+// venafi.Signer using the vcert configuration at vcertConfigPath.
+func newServer(vcertConfigPath, zone string, log logr.Logger) (*plugin.Server, error) {
+	vcertConfig := &vcert.Config{
+		ConfigFile: vcertConfigPath,
+	}
+	if err := vcertConfig.LoadFromFile(); err != nil {
+		return nil, fmt.Errorf("unable to load vcert config file %s: %v", vcertConfigPath, err)
+	}
+	clientFactory := func() (endpoint.Connector, error) {
+		vcertClient, err := vcert.NewClient(vcertConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error initialising vcert client: %v", err)
+		}
+		return vcertClient, nil
+	}
+	return &plugin.Server{
+		Signer: &venafi.Signer{
+			ClientFactory: clientFactory,
+			Log:           log.WithName("signer").WithName("venafi").WithName("Signer"),
+			Zone:          zone,
+		},
+		Log: log.WithName("Server"),
+		HealthCheck: (&healthCheck{
+			ClientFactory: clientFactory,
+			Interval:      healthCheckInterval,
+		}).Check,
+	}, nil
+}
+
+// healthCheck pings Venafi, reusing the vcert client, which is only created
+// again after a failure, and the result for Interval.
+type healthCheck struct {
+	ClientFactory func() (endpoint.Connector, error)
+	Interval      time.Duration
+
+	mu      sync.Mutex
+	client  endpoint.Connector
+	checked time.Time
+	err     error
+}
+
+func (o *healthCheck) Check() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.checked.IsZero() && time.Since(o.checked) < o.Interval {
+		return o.err
+	}
+	o.checked = time.Now()
+	if o.client == nil {
+		o.client, o.err = o.ClientFactory()
+		if o.err != nil {
+			return o.err
+		}
+	}
+	if o.err = o.client.Ping(); o.err != nil {
+		o.client = nil
+	}
+	return o.err
+}
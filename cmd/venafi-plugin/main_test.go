@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/Venafi/vcert/pkg/venafi/fake"
+	"github.com/go-logr/zapr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc"
+
+	"github.com/cert-manager/signer-venafi/internal/plugin"
+	"github.com/cert-manager/signer-venafi/internal/plugin/conformance"
+)
+
+// TestConformance runs the conformance tests against the plugin, using the
+// in-memory vcert fake client.
+func TestConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "venafi-plugin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	vcertConfigPath := filepath.Join(dir, "vcert.ini")
+	require.NoError(t, ioutil.WriteFile(vcertConfigPath, []byte("test_mode = true\n"), 0600))
+
+	srv, err := newServer(vcertConfigPath, "", zapr.NewLogger(zaptest.NewLogger(t)))
+	require.NoError(t, err)
+
+	socket := filepath.Join(dir, "plugin.sock")
+	l, err := plugin.Listen(socket)
+	require.NoError(t, err)
+	s := grpc.NewServer()
+	plugin.RegisterSignerServer(s, srv)
+	go s.Serve(l)
+	defer s.Stop()
+
+	conn, err := plugin.Dial(socket)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conformance.Run(t, plugin.NewSignerClient(conn), conformance.Options{})
+}
+
+// pingConnector is a vcert client whose Ping returns err.
+type pingConnector struct {
+	endpoint.Connector
+	err error
+}
+
+func (o *pingConnector) Ping() error {
+	return o.err
+}
+
+// TestHealthCheck verifies that the vcert client and the result of the health
+// check are reused, and that a new client is created after a failure.
+func TestHealthCheck(t *testing.T) {
+	client := &pingConnector{Connector: fake.NewConnector(false, nil)}
+	clients := 0
+	h := &healthCheck{
+		ClientFactory: func() (endpoint.Connector, error) {
+			clients++
+			return client, nil
+		},
+		Interval: time.Hour,
+	}
+
+	require.NoError(t, h.Check())
+	client.err = errors.New("connection refused")
+	require.NoError(t, h.Check(), "the result should be reused for the interval")
+	assert.Equal(t, 1, clients)
+
+	h.Interval = 0
+	assert.EqualError(t, h.Check(), "connection refused")
+	assert.Equal(t, 1, clients, "the client should be reused")
+
+	client.err = nil
+	require.NoError(t, h.Check())
+	assert.Equal(t, 2, clients, "a new client should be created after a failure")
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2020 The Cert-Manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/trust"
+)
+
+const (
+	// The ConfigMap data key which holds the CA bundle
+	dataKeyCABundle = "ca.crt"
+	// The annotation which records the signer name of a CA bundle ConfigMap
+	annotationKeyCABundleSignerName = "signer-venafi.cert-manager.io/signer-name"
+	// The time between attempts to read the CA chain from the Source
+	caBundleSourceRetryInterval = 30 * time.Second
+)
+
+// CABundleReconciler maintains a ConfigMap containing the CA chain of the
+// signer in every namespace matching the Selector, for clusters which do not
+// support ClusterTrustBundles.
+// The ConfigMaps are updated whenever the chain changes. During a CA rotation
+// they contain both the old and new CAs, until the old CAs expire.
+type CABundleReconciler struct {
+	client.Client
+	// Reader reads the ConfigMaps from the API server, because only those
+	// with the trust.LabelKeyCABundle label are watched, so they are not in
+	// the cache of the Client.
+	Reader     client.Reader
+	Log        logr.Logger
+	SignerName string
+	Chain      *trust.Chain
+	// Source, if set, is used to read the CA chain while the Chain is not
+	// yet known, so that the ConfigMaps are written before the first
+	// certificate is picked up.
+	Source signer.ChainReader
+	// Selector selects the namespaces which receive the ConfigMap.
+	Selector labels.Selector
+}
+
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;delete
+
+func (r *CABundleReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithName("Reconcile").WithValues("namespace", req.Name)
+	ctx := context.Background()
+
+	var ns corev1.Namespace
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: req.Name}, &ns); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("error getting namespace: %v", err)
+	}
+	if !ns.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	key := client.ObjectKey{Namespace: ns.Name, Name: caBundleConfigMapName(r.SignerName)}
+	var cm corev1.ConfigMap
+	err := r.Reader.Get(ctx, key, &cm)
+	if client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, fmt.Errorf("error getting ConfigMap: %v", err)
+	}
+	exists := err == nil
+	// The ConfigMaps written before the trust.LabelKeyCABundle label was
+	// added only have the managed-by label.
+	if exists && cm.Labels[trust.LabelKeyCABundle] != trust.LabelValueCABundle &&
+		cm.Labels[capihelper.LabelKeyManagedBy] != capihelper.LabelValueManagedBy {
+		log.Info("Not updating ConfigMap which is not managed by signer-venafi", "configmap", key)
+		return ctrl.Result{}, nil
+	}
+
+	if !r.Selector.Matches(labels.Set(ns.Labels)) {
+		if exists {
+			log.V(1).Info("Deleting ConfigMap from namespace which is not selected", "configmap", key)
+			if err := r.Client.Delete(ctx, &cm); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, fmt.Errorf("error deleting ConfigMap: %v", err)
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if exists && cm.Annotations[annotationKeyCABundleSignerName] == r.SignerName {
+		// The chains published before the signer restarted are kept until
+		// they expire.
+		r.Chain.Merge([]byte(cm.Data[dataKeyCABundle]))
+	}
+	bundle := r.Chain.PEM()
+	if len(bundle) == 0 {
+		log.V(1).Info("Not distributing", "reason", "CA chain is not yet known")
+		return ctrl.Result{}, nil
+	}
+	if exists && bytes.Equal([]byte(cm.Data[dataKeyCABundle]), bundle) &&
+		cm.Labels[trust.LabelKeyCABundle] == trust.LabelValueCABundle {
+		return ctrl.Result{}, nil
+	}
+
+	cm.Namespace = key.Namespace
+	cm.Name = key.Name
+	if cm.Labels == nil {
+		cm.Labels = map[string]string{}
+	}
+	cm.Labels[capihelper.LabelKeyManagedBy] = capihelper.LabelValueManagedBy
+	cm.Labels[trust.LabelKeyCABundle] = trust.LabelValueCABundle
+	metav1.SetMetaDataAnnotation(&cm.ObjectMeta, annotationKeyCABundleSignerName, r.SignerName)
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[dataKeyCABundle] = string(bundle)
+
+	if exists {
+		log.V(1).Info("Updating ConfigMap", "configmap", key)
+		err = r.Client.Update(ctx, &cm)
+	} else {
+		log.V(1).Info("Creating ConfigMap", "configmap", key)
+		err = r.Client.Create(ctx, &cm)
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error writing ConfigMap: %v", err)
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *CABundleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Selector == nil {
+		r.Selector = labels.Everything()
+	}
+
+	// Every namespace is reconciled when the chain changes, including when
+	// it is first read from the Source.
+	chainUpdates := make(chan event.GenericEvent)
+	updated := r.Chain.Subscribe()
+	err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		for {
+			select {
+			case <-stop:
+				return nil
+			case <-updated:
+				select {
+				case chainUpdates <- event.GenericEvent{Meta: &metav1.ObjectMeta{}, Object: &corev1.Namespace{}}:
+				case <-stop:
+					return nil
+				}
+			}
+		}
+	}))
+	if err != nil {
+		return err
+	}
+	if r.Source != nil {
+		err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+			r.readSource(stop, caBundleSourceRetryInterval)
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only the ConfigMaps with the CA bundle label are watched, rather than
+	// caching every ConfigMap in the cluster.
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labels.Set{trust.LabelKeyCABundle: trust.LabelValueCABundle}.String()
+		}))
+	configMaps := factory.Core().V1().ConfigMaps().Informer()
+	err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		factory.Start(stop)
+		<-stop
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+	if r.Reader == nil {
+		r.Reader = mgr.GetAPIReader()
+	}
+
+	configMapName := caBundleConfigMapName(r.SignerName)
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("cabundle").
+		For(&corev1.Namespace{}).
+		Watches(
+			&source.Informer{Informer: configMaps},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+				if o.Meta.GetName() != configMapName {
+					return nil
+				}
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: o.Meta.GetNamespace()}}}
+			})},
+		).
+		Watches(
+			&source.Channel{Source: chainUpdates},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+				return r.allNamespaces()
+			})},
+		).
+		Complete(r)
+}
+
+// readSource reads the CA chain from the Source into the Chain, retrying every
+// interval until it succeeds, unless the Chain becomes known first.
+func (r *CABundleReconciler) readSource(stop <-chan struct{}, interval time.Duration) {
+	log := r.Log.WithName("readSource")
+	_ = wait.PollImmediateUntil(interval, func() (bool, error) {
+		if len(r.Chain.PEM()) > 0 {
+			return true, nil
+		}
+		log.V(1).Info("Reading CA chain")
+		caPEM, err := r.Source.CAChain(context.Background())
+		if err != nil {
+			log.Error(err, "error reading CA chain")
+			return false, nil
+		}
+		r.Chain.Set(caPEM)
+		return true, nil
+	}, stop)
+}
+
+// allNamespaces returns a request for every namespace.
+func (r *CABundleReconciler) allNamespaces() []reconcile.Request {
+	var namespaces corev1.NamespaceList
+	if err := r.Client.List(context.Background(), &namespaces); err != nil {
+		r.Log.Error(err, "error listing namespaces")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, ns := range namespaces.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: ns.Name}})
+	}
+	return requests
+}
+
+// caBundleConfigMapName returns the name of the CA bundle ConfigMap of the
+// signer name.
+func caBundleConfigMapName(signerName string) string {
+	return strings.ReplaceAll(signerName, "/", "-") + "-ca-bundle"
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/trust"
+)
+
+// These tests call the CABundleReconciler directly, with a fake client, so
+// that the ConfigMaps written before a restart can be set up.
+var _ = Describe("CA Bundle Reconciler", func() {
+	var (
+		ctx        context.Context
+		cl         client.Client
+		reconciler *CABundleReconciler
+	)
+	key := client.ObjectKey{Namespace: "ns1", Name: caBundleConfigMapName(sampleSignerName)}
+
+	setup := func(objects ...runtime.Object) {
+		ctx = context.Background()
+		objects = append(objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}})
+		cl = fake.NewFakeClientWithScheme(clientgoscheme.Scheme, objects...)
+		reconciler = &CABundleReconciler{
+			Client:     cl,
+			Reader:     cl,
+			Log:        ctrl.Log.WithName("CABundleReconciler"),
+			SignerName: sampleSignerName,
+			Chain:      &trust.Chain{},
+			Selector:   labels.Everything(),
+		}
+	}
+	newConfigMap := func(labels map[string]string, bundle []byte) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   key.Namespace,
+				Name:        key.Name,
+				Labels:      labels,
+				Annotations: map[string]string{annotationKeyCABundleSignerName: sampleSignerName},
+			},
+			Data: map[string]string{dataKeyCABundle: string(bundle)},
+		}
+	}
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: "ns1"}})
+		Expect(err).ToNot(HaveOccurred())
+	}
+	getConfigMap := func() *corev1.ConfigMap {
+		var cm corev1.ConfigMap
+		Expect(cl.Get(ctx, key, &cm)).To(Succeed())
+		return &cm
+	}
+
+	It("Creates the labelled ConfigMap", func() {
+		setup()
+		ca := newCAPEM("ca")
+		reconciler.Chain.Set(ca)
+		reconcile()
+		cm := getConfigMap()
+		Expect(cm.Data[dataKeyCABundle]).To(Equal(string(ca)))
+		Expect(cm.Labels).To(HaveKeyWithValue(trust.LabelKeyCABundle, trust.LabelValueCABundle))
+	})
+
+	It("Keeps the previous chains of an existing ConfigMap after a restart", func() {
+		oldCA := newCAPEM("old-ca")
+		newCA := newCAPEM("new-ca")
+		setup(newConfigMap(map[string]string{trust.LabelKeyCABundle: trust.LabelValueCABundle}, oldCA))
+		reconciler.Chain.Set(newCA)
+		reconcile()
+		Expect(getConfigMap().Data[dataKeyCABundle]).To(Equal(string(newCA) + string(oldCA)))
+		Expect(reconciler.Chain.PEM()).To(Equal(append(newCA, oldCA...)))
+	})
+
+	It("Labels the ConfigMaps written with only the managed-by label", func() {
+		ca := newCAPEM("ca")
+		setup(newConfigMap(map[string]string{capihelper.LabelKeyManagedBy: capihelper.LabelValueManagedBy}, ca))
+		reconciler.Chain.Set(ca)
+		reconcile()
+		cm := getConfigMap()
+		Expect(cm.Labels).To(HaveKeyWithValue(trust.LabelKeyCABundle, trust.LabelValueCABundle))
+		Expect(cm.Data[dataKeyCABundle]).To(Equal(string(ca)))
+	})
+
+	It("Does not update or merge a ConfigMap which it does not manage", func() {
+		otherCA := newCAPEM("other-ca")
+		setup(newConfigMap(nil, otherCA))
+		reconciler.Chain.Set(newCAPEM("ca"))
+		reconcile()
+		Expect(getConfigMap().Data[dataKeyCABundle]).To(Equal(string(otherCA)))
+		Expect(reconciler.Chain.PEM()).ToNot(ContainSubstring(string(otherCA)))
+	})
+
+	It("Reads the CA chain from the Source before any certificate is picked up", func() {
+		setup()
+		ca := newCAPEM("ca")
+		reconciler.Source = chainReader(ca)
+		updated := reconciler.Chain.Subscribe()
+		stop := make(chan struct{})
+		defer close(stop)
+		reconciler.readSource(stop, time.Millisecond)
+		Expect(updated).To(Receive())
+		reconcile()
+		Expect(getConfigMap().Data[dataKeyCABundle]).To(Equal(string(ca)))
+	})
+})
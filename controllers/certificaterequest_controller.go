@@ -0,0 +1,358 @@
+/*
+Copyright 2020 The Cert-Manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	apiutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/filter"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+const (
+	// The reason of the synthetic Approved condition of the CSRs translated
+	// from CertificateRequests which have no approval of their own, if
+	// AutoApprove is set
+	reasonCertificateRequest = "CertificateRequest"
+	// The condition types of the approval of CertificateRequests, which are
+	// set by cert-manager v1.3 and later
+	certificateRequestConditionApproved cmapi.CertificateRequestConditionType = "Approved"
+	certificateRequestConditionDenied   cmapi.CertificateRequestConditionType = "Denied"
+)
+
+// certificateRequestRequester holds the fields of the spec of a
+// CertificateRequest which record its requester. They are set by
+// cert-manager v1.3 and later, but are not in the API types of the version of
+// cert-manager used by this signer, so they are read separately.
+type certificateRequestRequester struct {
+	Username string              `json:"username,omitempty"`
+	UID      string              `json:"uid,omitempty"`
+	Groups   []string            `json:"groups,omitempty"`
+	Extra    map[string][]string `json:"extra,omitempty"`
+}
+
+// CertificateRequestReconciler signs cert-manager CertificateRequests whose
+// issuer reference has the IssuerGroup, using the same Signer and Filter as
+// the CertificateSigningRequestReconciler.
+// Each CertificateRequest is translated to a CSR with the SignerName, so that
+// it is subject to the same checks and policies.
+type CertificateRequestReconciler struct {
+	client.Client
+	Log         logr.Logger
+	Signer      signer.Signer
+	SignerName  string
+	IssuerGroup string
+	Filter      filter.Filter
+	Recorder    record.EventRecorder
+	// AutoApprove treats CertificateRequests without an Approved or Denied
+	// condition as approved, for versions of cert-manager which do not
+	// approve CertificateRequests.
+	AutoApprove bool
+	// Revoker, if set, is used to revoke certificates which fail
+	// verification, with the RevocationReason.
+	Revoker          signer.Revoker
+	RevocationReason string
+	// IgnoreDuration drops the requested duration from the CSRs before they
+	// are signed, for backends which can not honour it.
+	IgnoreDuration bool
+}
+
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests/status,verbs=get;update;patch
+
+func (r *CertificateRequestReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithName("Reconcile").WithValues("certificaterequest", req.NamespacedName)
+	ctx := context.Background()
+
+	var cr cmapi.CertificateRequest
+	if err := r.Client.Get(ctx, req.NamespacedName, &cr); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			log.V(1).Info("Ignoring", "reason", "CertificateRequest not found")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("error getting CertificateRequest: %v", err)
+	}
+	if cr.Spec.IssuerRef.Group != r.IssuerGroup {
+		log.V(1).Info("Ignoring", "reason", "CertificateRequest issuer group does not match")
+		return ctrl.Result{}, nil
+	}
+
+	requester, err := r.requester(ctx, req.NamespacedName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	csr := csrFromCertificateRequest(cr, requester, r.SignerName, r.AutoApprove)
+	if err := r.Filter.Check(csr); err != nil {
+		if errors.Is(err, filter.ErrApprovalIgnored) {
+			r.Recorder.Event(&cr, corev1.EventTypeWarning, reasonApprovalIgnored, err.Error())
+		}
+		log.V(1).Info("Ignoring", "reason", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	pickupID := cr.Annotations[annotationKeyPickupID]
+	if pickupID == "" {
+		log.V(1).Info("Signing")
+		signed := csr
+		if r.IgnoreDuration {
+			signed = dropDuration(r.Recorder, &cr, csr)
+		}
+		pickupID, err := r.Signer.Sign(signed)
+		if err != nil {
+			if errors.Is(err, signer.ErrPermanent) {
+				return ctrl.Result{}, r.fail(ctx, &cr, reasonSignFailed, err)
+			}
+			return ctrl.Result{}, fmt.Errorf("error signing: %v", err)
+		}
+		r.Recorder.Event(&cr, corev1.EventTypeNormal, reasonSignRequested, "Certificate requested from signer")
+
+		original := cr.DeepCopy()
+		metav1.SetMetaDataAnnotation(&cr.ObjectMeta, annotationKeyPickupID, pickupID)
+		if err := r.Client.Patch(ctx, &cr, client.MergeFrom(original)); err != nil {
+			return ctrl.Result{}, fmt.Errorf("error patching CertificateRequest: %v", err)
+		}
+
+		original = cr.DeepCopy()
+		apiutil.SetCertificateRequestCondition(&cr, cmapi.CertificateRequestConditionReady, cmmeta.ConditionFalse,
+			cmapi.CertificateRequestReasonPending, "Waiting for the certificate to be issued")
+		if err := r.Client.Status().Patch(ctx, &cr, client.MergeFrom(original)); err != nil {
+			return ctrl.Result{}, fmt.Errorf("error patching CertificateRequest: %v", err)
+		}
+		return ctrl.Result{RequeueAfter: time.Second * pickupRetrySeconds}, nil
+	}
+
+	log.V(1).Info("Picking up")
+	certificate, err := r.Signer.Pickup(pickupID)
+	if err != nil {
+		if errors.Is(err, signer.ErrTemporary) {
+			log.V(1).Info("Temporary error picking up certificate", "err", err)
+			return ctrl.Result{RequeueAfter: time.Second * pickupRetrySeconds}, nil
+		}
+		if errors.Is(err, signer.ErrPermanent) {
+			return ctrl.Result{}, r.fail(ctx, &cr, reasonPickupFailed, err)
+		}
+		return ctrl.Result{}, fmt.Errorf("error signing: %v", err)
+	}
+	if v, ok := r.Signer.(signer.Verifier); ok {
+		if err := v.Verify(csr, certificate); err != nil {
+			if err := revokeUnverified(log, r.Recorder, r.Revoker, r.RevocationReason, &cr, pickupID); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, r.fail(ctx, &cr, reasonVerifyFailed, err)
+		}
+	}
+
+	original := cr.DeepCopy()
+	cr.Status.Certificate = certificate
+	apiutil.SetCertificateRequestCondition(&cr, cmapi.CertificateRequestConditionReady, cmmeta.ConditionTrue,
+		cmapi.CertificateRequestReasonIssued, "Certificate issued")
+	if err := r.Client.Status().Patch(ctx, &cr, client.MergeFrom(original)); err != nil {
+		return ctrl.Result{}, fmt.Errorf("error patching CertificateRequest: %v", err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// fail marks the CertificateRequest as failed, so that it will not be
+// processed again, and records the reason as an event.
+func (r *CertificateRequestReconciler) fail(ctx context.Context, cr *cmapi.CertificateRequest, reason string, err error) error {
+	r.Recorder.Event(cr, corev1.EventTypeWarning, reason, err.Error())
+
+	original := cr.DeepCopy()
+	now := metav1.Now()
+	cr.Status.FailureTime = &now
+	apiutil.SetCertificateRequestCondition(cr, cmapi.CertificateRequestConditionReady, cmmeta.ConditionFalse,
+		cmapi.CertificateRequestReasonFailed, err.Error())
+	if err := r.Client.Status().Patch(ctx, cr, client.MergeFrom(original)); err != nil {
+		return fmt.Errorf("error patching CertificateRequest: %v", err)
+	}
+	return nil
+}
+
+// requester returns the requester of the CertificateRequest, which is empty
+// for CertificateRequests created by versions of cert-manager which do not
+// record it.
+func (r *CertificateRequestReconciler) requester(ctx context.Context, key types.NamespacedName) (certificateRequestRequester, error) {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(cmapi.SchemeGroupVersion.WithKind(cmapi.CertificateRequestKind))
+	if err := r.Client.Get(ctx, key, u); err != nil {
+		return certificateRequestRequester{}, fmt.Errorf("error getting CertificateRequest: %v", err)
+	}
+	return requesterFromUnstructured(u)
+}
+
+// requesterFromUnstructured decodes the requester fields of a
+// CertificateRequest.
+func requesterFromUnstructured(u *unstructured.Unstructured) (certificateRequestRequester, error) {
+	var requester certificateRequestRequester
+	spec, ok := u.Object["spec"].(map[string]interface{})
+	if !ok {
+		return requester, nil
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &requester); err != nil {
+		return requester, fmt.Errorf("error decoding CertificateRequest requester: %v", err)
+	}
+	return requester, nil
+}
+
+// certificateRequestAnnotations are the CertificateRequest annotations which
+// are copied to the translated CSR. The other annotations are set by the
+// requester, so they must not be copied, or they could satisfy filters and
+// policies which read CSR annotations, such as the approver annotations of
+// the filter.Quorum.
+var certificateRequestAnnotations = []string{
+	cmapi.CertificateNameKey,
+	cmapi.CertificateRequestRevisionAnnotationKey,
+	cmapi.CRPrivateKeyAnnotationKey,
+}
+
+// csrFromCertificateRequest translates a CertificateRequest to a CSR with the
+// signer name, which can be checked by the Filter and signed by the Signer.
+// Only the certificateRequestAnnotations are copied. The duration and isCA
+// fields are translated to the cert-manager CSR annotations, and the
+// requester to the CSR requester.
+// The Approved and Denied conditions of the CertificateRequest are translated
+// to CSR conditions. If autoApprove is set, a CertificateRequest with neither
+// is approved with reason CertificateRequest, unless it has failed.
+func csrFromCertificateRequest(cr cmapi.CertificateRequest, requester certificateRequestRequester, signerName string, autoApprove bool) capi.CertificateSigningRequest {
+	annotations := map[string]string{}
+	for _, k := range certificateRequestAnnotations {
+		if v, ok := cr.Annotations[k]; ok {
+			annotations[k] = v
+		}
+	}
+	if cr.Spec.Duration != nil {
+		annotations[capihelper.AnnotationKeyRequestDuration] = cr.Spec.Duration.Duration.String()
+	}
+	if cr.Spec.IsCA {
+		annotations[capihelper.AnnotationKeyRequestIsCA] = strconv.FormatBool(true)
+	}
+
+	usages := []capi.KeyUsage{capi.UsageDigitalSignature, capi.UsageKeyEncipherment}
+	if len(cr.Spec.Usages) > 0 {
+		usages = nil
+		for _, u := range cr.Spec.Usages {
+			usages = append(usages, capi.KeyUsage(u))
+		}
+	}
+
+	csr := capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              cr.Namespace + "/" + cr.Name,
+			Annotations:       annotations,
+			CreationTimestamp: cr.CreationTimestamp,
+			DeletionTimestamp: cr.DeletionTimestamp,
+		},
+		Spec: capi.CertificateSigningRequestSpec{
+			SignerName: &signerName,
+			Request:    cr.Spec.CSRPEM,
+			Usages:     usages,
+			Username:   requester.Username,
+			UID:        requester.UID,
+			Groups:     requester.Groups,
+		},
+		Status: capi.CertificateSigningRequestStatus{
+			Certificate: cr.Status.Certificate,
+		},
+	}
+	if len(requester.Extra) > 0 {
+		csr.Spec.Extra = map[string]capi.ExtraValue{}
+		for k, v := range requester.Extra {
+			csr.Spec.Extra[k] = capi.ExtraValue(v)
+		}
+	}
+
+	for _, c := range cr.Status.Conditions {
+		if c.Status != cmmeta.ConditionTrue {
+			continue
+		}
+		condition := capi.CertificateSigningRequestCondition{
+			Reason:  c.Reason,
+			Message: c.Message,
+		}
+		if c.LastTransitionTime != nil {
+			condition.LastUpdateTime = *c.LastTransitionTime
+		}
+		switch c.Type {
+		case certificateRequestConditionApproved:
+			condition.Type = capi.CertificateApproved
+		case certificateRequestConditionDenied:
+			condition.Type = capi.CertificateDenied
+		default:
+			continue
+		}
+		csr.Status.Conditions = append(csr.Status.Conditions, condition)
+	}
+	if len(csr.Status.Conditions) == 0 && autoApprove {
+		csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+			Type:           capi.CertificateApproved,
+			Reason:         reasonCertificateRequest,
+			LastUpdateTime: cr.CreationTimestamp,
+		})
+	}
+	if apiutil.CertificateRequestReadyReason(&cr) == cmapi.CertificateRequestReasonFailed {
+		csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+			Type:   capihelper.CertificateFailed,
+			Reason: reasonSignFailed,
+		})
+	}
+	return csr
+}
+
+func (r *CertificateRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Filter == nil {
+		r.Filter = &filter.CSRFilter{
+			SignerName: r.SignerName,
+		}
+	}
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor(eventSourceName)
+	}
+	matchesIssuerGroup := func(o interface{}) bool {
+		cr, ok := o.(*cmapi.CertificateRequest)
+		return ok && cr.Spec.IssuerRef.Group == r.IssuerGroup
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cmapi.CertificateRequest{}).
+		WithEventFilter(predicate.Funcs{
+			CreateFunc:  func(e event.CreateEvent) bool { return matchesIssuerGroup(e.Object) },
+			UpdateFunc:  func(e event.UpdateEvent) bool { return matchesIssuerGroup(e.ObjectNew) },
+			DeleteFunc:  func(e event.DeleteEvent) bool { return false },
+			GenericFunc: func(e event.GenericEvent) bool { return matchesIssuerGroup(e.Object) },
+		}).
+		Complete(r)
+}
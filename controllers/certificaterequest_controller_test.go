@@ -0,0 +1,160 @@
+/*
+Copyright 2020 The Cert-Manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/filter"
+)
+
+var _ = Describe("csrFromCertificateRequest", func() {
+	csrFilter := &filter.CSRFilter{SignerName: sampleSignerName}
+	approved := cmapi.CertificateRequestStatus{
+		Conditions: []cmapi.CertificateRequestCondition{
+			{
+				Type:   certificateRequestConditionApproved,
+				Status: cmmeta.ConditionTrue,
+				Reason: "policy.cert-manager.io",
+			},
+		},
+	}
+
+	table.DescribeTable("Translates CertificateRequests which are signed",
+		func(cr cmapi.CertificateRequest, autoApprove bool, wantAnnotations map[string]string, wantUsages []capi.KeyUsage, wantReason string) {
+			csr := csrFromCertificateRequest(cr, certificateRequestRequester{}, sampleSignerName, autoApprove)
+			Expect(csrFilter.Check(csr)).To(Succeed())
+			Expect(csr.Annotations).To(Equal(wantAnnotations))
+			Expect(csr.Spec.Usages).To(Equal(wantUsages))
+			Expect(csr.Status.Conditions).To(ConsistOf(
+				WithTransform(func(c capi.CertificateSigningRequestCondition) string { return string(c.Type) + "/" + c.Reason },
+					Equal(string(capi.CertificateApproved)+"/"+wantReason)),
+			))
+		},
+		table.Entry("Approved",
+			cmapi.CertificateRequest{Status: approved}, false,
+			map[string]string{},
+			[]capi.KeyUsage{capi.UsageDigitalSignature, capi.UsageKeyEncipherment},
+			"policy.cert-manager.io",
+		),
+		table.Entry("AutoApproved",
+			cmapi.CertificateRequest{}, true,
+			map[string]string{},
+			[]capi.KeyUsage{capi.UsageDigitalSignature, capi.UsageKeyEncipherment},
+			reasonCertificateRequest,
+		),
+		table.Entry("AnnotationsDurationIsCAAndUsages",
+			cmapi.CertificateRequest{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					"foo":                    "bar",
+					cmapi.CertificateNameKey: "cert1",
+					filter.AnnotationKeyPrefixApprover + "alice": "approved",
+				}},
+				Spec: cmapi.CertificateRequestSpec{
+					Duration: &metav1.Duration{Duration: 90 * time.Minute},
+					IsCA:     true,
+					Usages:   []cmapi.KeyUsage{cmapi.UsageCertSign, cmapi.UsageServerAuth},
+				},
+				Status: approved,
+			}, false,
+			map[string]string{
+				cmapi.CertificateNameKey:                "cert1",
+				capihelper.AnnotationKeyRequestDuration: "1h30m0s",
+				capihelper.AnnotationKeyRequestIsCA:     "true",
+			},
+			[]capi.KeyUsage{capi.UsageCertSign, capi.UsageServerAuth},
+			"policy.cert-manager.io",
+		),
+	)
+
+	table.DescribeTable("Translates CertificateRequests which are not signed",
+		func(cr cmapi.CertificateRequest, autoApprove bool) {
+			csr := csrFromCertificateRequest(cr, certificateRequestRequester{}, sampleSignerName, autoApprove)
+			Expect(csrFilter.Check(csr)).ToNot(Succeed())
+		},
+		table.Entry("NotApproved", cmapi.CertificateRequest{}, false),
+		table.Entry("ApprovalNotTrue", cmapi.CertificateRequest{
+			Status: cmapi.CertificateRequestStatus{
+				Conditions: []cmapi.CertificateRequestCondition{
+					{Type: certificateRequestConditionApproved, Status: cmmeta.ConditionFalse},
+				},
+			},
+		}, false),
+		table.Entry("DeniedNotAutoApproved", cmapi.CertificateRequest{
+			Status: cmapi.CertificateRequestStatus{
+				Conditions: []cmapi.CertificateRequestCondition{
+					{Type: certificateRequestConditionDenied, Status: cmmeta.ConditionTrue, Reason: "policy.cert-manager.io"},
+				},
+			},
+		}, true),
+		table.Entry("Issued", cmapi.CertificateRequest{
+			Status: cmapi.CertificateRequestStatus{
+				Conditions:  approved.Conditions,
+				Certificate: []byte("cert"),
+			},
+		}, false),
+		table.Entry("Failed", cmapi.CertificateRequest{
+			Status: cmapi.CertificateRequestStatus{
+				Conditions: append([]cmapi.CertificateRequestCondition{
+					{
+						Type:   cmapi.CertificateRequestConditionReady,
+						Status: cmmeta.ConditionFalse,
+						Reason: cmapi.CertificateRequestReasonFailed,
+					},
+				}, approved.Conditions...),
+			},
+		}, false),
+	)
+
+	It("Translates the requester", func() {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"username": "system:serviceaccount:ns1:sa1",
+				"uid":      "uid1",
+				"groups":   []interface{}{"system:serviceaccounts", "system:serviceaccounts:ns1"},
+				"extra":    map[string]interface{}{"key": []interface{}{"value"}},
+				"csr":      "ignored",
+			},
+		}}
+		requester, err := requesterFromUnstructured(u)
+		Expect(err).ToNot(HaveOccurred())
+
+		csr := csrFromCertificateRequest(cmapi.CertificateRequest{Status: approved}, requester, sampleSignerName, false)
+		Expect(csr.Spec.Username).To(Equal("system:serviceaccount:ns1:sa1"))
+		Expect(csr.Spec.UID).To(Equal("uid1"))
+		Expect(csr.Spec.Groups).To(Equal([]string{"system:serviceaccounts", "system:serviceaccounts:ns1"}))
+		Expect(csr.Spec.Extra).To(Equal(map[string]capi.ExtraValue{"key": {"value"}}))
+	})
+
+	It("Leaves the requester empty for older versions of cert-manager", func() {
+		requester, err := requesterFromUnstructured(&unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"csr": "ignored"},
+		}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requester).To(Equal(certificateRequestRequester{}))
+	})
+})
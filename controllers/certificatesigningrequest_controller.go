@@ -23,13 +23,20 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
 	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
 	"github.com/cert-manager/signer-venafi/internal/filter"
+	"github.com/cert-manager/signer-venafi/internal/metrics"
+	"github.com/cert-manager/signer-venafi/internal/records"
+	"github.com/cert-manager/signer-venafi/internal/secrets"
 	"github.com/cert-manager/signer-venafi/internal/signer"
 )
 
@@ -38,6 +45,31 @@ const (
 	annotationKeyPickupID = "signer-venafi.cert-manager.io/pickup-id"
 	// The number of seconds to wait between pickup attempts
 	pickupRetrySeconds = 5
+	// The name used for events recorded by this controller
+	eventSourceName = "signer-venafi"
+	// The reasons used in the Failed condition and events
+	reasonSignFailed   = "SignFailed"
+	reasonPickupFailed = "PickupFailed"
+	reasonVerifyFailed = "VerifyFailed"
+	// The reason used in events about approvals which do not satisfy the
+	// filter.ApprovalRules
+	reasonApprovalIgnored = "ApprovalIgnored"
+	// The reasons used for CSRs which are too old to be signed, and for
+	// CSRs which are sent to the signer
+	reasonExpiredBeforeSigning = "ExpiredBeforeSigning"
+	reasonSignRequested        = "SignRequested"
+	// The reason used in events about requested durations which are not sent
+	// to the backend
+	reasonDurationIgnored = "DurationIgnored"
+	// The reasons used in events about writing certificates to Secrets
+	reasonSecretWritten    = "SecretWritten"
+	reasonSecretNotWritten = "SecretNotWritten"
+)
+
+// The values of the result label of metrics.CSRAgeSeconds
+const (
+	resultSigned  = "signed"
+	resultExpired = "expired"
 )
 
 // CertificateSigningRequestReconciler reconciles a CertificateSigningRequest object
@@ -48,10 +80,41 @@ type CertificateSigningRequestReconciler struct {
 	Signer     signer.Signer
 	SignerName string
 	Filter     filter.Filter
+	Recorder   record.EventRecorder
+	// ControllerName, if set, is the name of the controller, so that a
+	// controller can be run for each of several signer names.
+	ControllerName string
+	// MaxAge is the maximum age of a CSR, since it was created, at which it
+	// will be signed. Older CSRs are failed. Zero means no limit.
+	MaxAge time.Duration
+	// MaxApprovalAge is the maximum time since a CSR was approved at which it
+	// will be signed. Older CSRs are failed. Zero means no limit.
+	MaxApprovalAge time.Duration
+	// Revoker, if set, is used to revoke certificates of CSRs with the revoke
+	// annotation, of deleted CSRs with the revoke finalizer, and which fail
+	// verification.
+	Revoker signer.Revoker
+	// RevocationReason is the reason used when revoking certificates of
+	// deleted CSRs, which fail verification, and when the revoke annotation
+	// has no value.
+	RevocationReason string
+	// RevokeOnDelete causes the revoke finalizer to be added to every CSR
+	// before it is signed.
+	RevokeOnDelete bool
+	// Records, if set, is used to record each issued certificate against the
+	// identity of its subject, so that superseded certificates can be revoked.
+	Records *records.Store
+	// Secrets, if set, is used to write the certificate into the Secret named
+	// by the CSR secret annotation.
+	Secrets *secrets.Writer
+	// IgnoreDuration drops the requested duration from the CSRs before they
+	// are signed, for backends which can not honour it.
+	IgnoreDuration bool
 }
 
-// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=get;list;watch
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *CertificateSigningRequestReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithName("Reconcile").WithValues("certificatesigningrequest", req.NamespacedName)
@@ -66,19 +129,52 @@ func (r *CertificateSigningRequestReconciler) Reconcile(req ctrl.Request) (ctrl.
 		return ctrl.Result{}, fmt.Errorf("error getting CSR: %v", err)
 	}
 
+	if done, err := r.reconcileRevocation(ctx, log, &csr); done || err != nil {
+		return ctrl.Result{}, err
+	}
+
 	if err := r.Filter.Check(csr); err != nil {
+		if errors.Is(err, filter.ErrApprovalIgnored) {
+			r.Recorder.Event(&csr, corev1.EventTypeWarning, reasonApprovalIgnored, err.Error())
+		}
 		log.V(1).Info("Ignoring", "reason", err.Error())
 		return ctrl.Result{}, nil
 	}
 
 	switch {
 	case csr.Annotations[annotationKeyPickupID] == "":
+		age := time.Since(csr.CreationTimestamp.Time)
+		if err := r.checkAge(csr); err != nil {
+			metrics.CSRAgeSeconds.WithLabelValues(r.SignerName, resultExpired).Observe(age.Seconds())
+			metrics.CSRExpiredTotal.WithLabelValues(r.SignerName).Inc()
+			return ctrl.Result{}, r.fail(ctx, &csr, reasonExpiredBeforeSigning, err)
+		}
+
+		if r.RevokeOnDelete && r.Revoker != nil && !hasFinalizer(&csr, finalizerRevoke) {
+			log.V(1).Info("Adding finalizer")
+			original := csr.DeepCopy()
+			csr.SetFinalizers(append(csr.GetFinalizers(), finalizerRevoke))
+			if err := r.Client.Patch(ctx, &csr, client.MergeFrom(original)); err != nil {
+				return ctrl.Result{}, fmt.Errorf("error adding finalizer: %v", err)
+			}
+		}
+
 		log.V(1).Info("Signing")
 
-		pickupID, err := r.Signer.Sign(csr)
+		signed := csr
+		if r.IgnoreDuration {
+			signed = dropDuration(r.Recorder, &csr, csr)
+		}
+		pickupID, err := r.Signer.Sign(signed)
 		if err != nil {
+			if errors.Is(err, signer.ErrPermanent) {
+				return ctrl.Result{}, r.fail(ctx, &csr, reasonSignFailed, err)
+			}
 			return ctrl.Result{}, fmt.Errorf("error signing: %v", err)
 		}
+		metrics.CSRAgeSeconds.WithLabelValues(r.SignerName, resultSigned).Observe(age.Seconds())
+		r.Recorder.Eventf(&csr, corev1.EventTypeNormal, reasonSignRequested,
+			"Certificate requested from signer, %s after the CSR was created", age.Round(time.Second))
 
 		original := csr.DeepCopy()
 		metav1.SetMetaDataAnnotation(&csr.ObjectMeta, annotationKeyPickupID, pickupID)
@@ -98,11 +194,45 @@ func (r *CertificateSigningRequestReconciler) Reconcile(req ctrl.Request) (ctrl.
 				log.V(1).Info("Temporary error picking up certificate", "err", err)
 				return ctrl.Result{RequeueAfter: time.Second * pickupRetrySeconds}, nil
 			}
+			if errors.Is(err, signer.ErrPermanent) {
+				return ctrl.Result{}, r.fail(ctx, &csr, reasonPickupFailed, err)
+			}
 			return ctrl.Result{}, fmt.Errorf("error signing: %v", err)
 		}
 
+		if v, ok := r.Signer.(signer.Verifier); ok {
+			if err := v.Verify(csr, certificate); err != nil {
+				if err := revokeUnverified(log, r.Recorder, r.Revoker, r.RevocationReason, &csr, pickupID); err != nil {
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{}, r.fail(ctx, &csr, reasonVerifyFailed, err)
+			}
+		}
+
+		if r.Records != nil {
+			if err := r.addRecord(ctx, csr, pickupID, certificate); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		if r.Secrets != nil && csr.Annotations[secrets.AnnotationKeySecret] != "" {
+			// The certificate is written to the CSR status even if the Secret
+			// can not be written, so that the requester can still use it.
+			err := r.Secrets.Write(ctx, csr, certificate)
+			switch {
+			case errors.Is(err, secrets.ErrNotPermitted):
+				r.Recorder.Event(&csr, corev1.EventTypeWarning, reasonSecretNotWritten, err.Error())
+			case err != nil:
+				return ctrl.Result{}, err
+			default:
+				r.Recorder.Eventf(&csr, corev1.EventTypeNormal, reasonSecretWritten,
+					"Certificate written to secret %s", csr.Annotations[secrets.AnnotationKeySecret])
+			}
+		}
+
+		// The pickup ID annotation is retained so that the certificate can
+		// later be revoked.
 		original := csr.DeepCopy()
-		delete(csr.Annotations, "pickup-id")
 		csr.Status.Certificate = certificate
 
 		patch := client.MergeFrom(original)
@@ -114,13 +244,93 @@ func (r *CertificateSigningRequestReconciler) Reconcile(req ctrl.Request) (ctrl.
 	return ctrl.Result{}, nil
 }
 
+// checkAge returns an error if the CSR is older than MaxAge or was approved
+// longer ago than MaxApprovalAge.
+func (r *CertificateSigningRequestReconciler) checkAge(csr capi.CertificateSigningRequest) error {
+	if r.MaxAge > 0 {
+		if age := time.Since(csr.CreationTimestamp.Time); age > r.MaxAge {
+			return fmt.Errorf("CSR expired before signing: created %s ago, maximum age is %s",
+				age.Round(time.Second), r.MaxAge)
+		}
+	}
+	if r.MaxApprovalAge > 0 {
+		approved := capihelper.GetApprovalTime(&csr)
+		if age := time.Since(approved.Time); !approved.IsZero() && age > r.MaxApprovalAge {
+			return fmt.Errorf("CSR expired before signing: approved %s ago, maximum age is %s",
+				age.Round(time.Second), r.MaxApprovalAge)
+		}
+	}
+	return nil
+}
+
+// dropDuration returns the CSR without the requested duration, for backends
+// which can not honour it. If a duration was requested, a warning event is
+// recorded on the object, which is the CSR or the resource it was translated
+// from.
+func dropDuration(recorder record.EventRecorder, object runtime.Object, csr capi.CertificateSigningRequest) capi.CertificateSigningRequest {
+	duration, ok := csr.Annotations[capihelper.AnnotationKeyRequestDuration]
+	if !ok {
+		return csr
+	}
+	recorder.Eventf(object, corev1.EventTypeWarning, reasonDurationIgnored,
+		"The requested duration %s is ignored, the validity of the certificate is decided by the backend", duration)
+	csr = *csr.DeepCopy()
+	delete(csr.Annotations, capihelper.AnnotationKeyRequestDuration)
+	return csr
+}
+
+// addRecord records the issued certificate against the identity of its
+// subject.
+func (r *CertificateSigningRequestReconciler) addRecord(ctx context.Context, csr capi.CertificateSigningRequest, pickupID string, certificate []byte) error {
+	cert, err := pki.DecodeX509CertificateBytes(certificate)
+	if err != nil {
+		return fmt.Errorf("error decoding certificate: %v", err)
+	}
+	err = r.Records.Add(ctx, r.SignerName, cert.Subject.String(), records.Record{
+		PickupID: pickupID,
+		CSRName:  csr.Name,
+		Issued:   time.Now(),
+		NotAfter: cert.NotAfter,
+	})
+	if err != nil {
+		return fmt.Errorf("error recording issued certificate: %v", err)
+	}
+	return nil
+}
+
+// fail adds a Failed condition to the CSR, so that it will not be processed
+// again, and records the reason as an event.
+func (r *CertificateSigningRequestReconciler) fail(ctx context.Context, csr *capi.CertificateSigningRequest, reason string, err error) error {
+	r.Recorder.Event(csr, corev1.EventTypeWarning, reason, err.Error())
+
+	original := csr.DeepCopy()
+	csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+		Type:           capihelper.CertificateFailed,
+		Reason:         reason,
+		Message:        err.Error(),
+		LastUpdateTime: metav1.Now(),
+	})
+
+	patch := client.MergeFrom(original)
+	if err := r.Client.Status().Patch(ctx, csr, patch); err != nil {
+		return fmt.Errorf("error patching CSR: %v", err)
+	}
+	return nil
+}
+
 func (r *CertificateSigningRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if r.Filter == nil {
 		r.Filter = &filter.CSRFilter{
 			SignerName: r.SignerName,
 		}
 	}
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&capi.CertificateSigningRequest{}).
-		Complete(r)
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor(eventSourceName)
+	}
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&capi.CertificateSigningRequest{})
+	if r.ControllerName != "" {
+		b = b.Named(r.ControllerName)
+	}
+	return b.Complete(r)
 }
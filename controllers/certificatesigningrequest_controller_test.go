@@ -3,13 +3,23 @@ package controllers
 import (
 	"context"
 	"encoding/pem"
+	"fmt"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	capi "k8s.io/api/certificates/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/filter"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/fake"
 )
 
 // Sample Certificate request and certificate are generate according to instructions at:
@@ -118,4 +128,170 @@ var _ = Describe("CertificateSigningRequest Reconciler", func() {
 		Expect(block.Type).To(Equal("CERTIFICATE"))
 		Expect(rest).To(BeEmpty())
 	})
+
+	It("Revokes the certificate of a signed CSR with the revoke annotation", func() {
+		By("Creating a sample CSR")
+		ctx := context.Background()
+		csr := &capi.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "test-revoke",
+			},
+			Spec: capi.CertificateSigningRequestSpec{
+				SignerName: pointer.StringPtr(sampleSignerName),
+				Request:    []byte(sampleCSR),
+				Usages: []capi.KeyUsage{
+					"digital signature",
+					"key encipherment",
+					"client auth",
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, csr)).To(Succeed())
+		defer func() {
+			By("Deleting the sample CSR")
+			Expect(k8sClient.Delete(ctx, csr)).To(Succeed())
+		}()
+
+		By("Approving the sample CSR")
+		key := client.ObjectKey{Name: csr.Name}
+		var actualCSR capi.CertificateSigningRequest
+		Expect(k8sClient.Get(ctx, key, &actualCSR)).To(Succeed())
+		actualCSR.Status.Conditions = append(
+			actualCSR.Status.Conditions,
+			capi.CertificateSigningRequestCondition{
+				Type:    capi.CertificateApproved,
+				Reason:  "TestApprove",
+				Message: "Approved for use in test",
+			},
+		)
+		_, err := clientset.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(ctx, &actualCSR, metav1.UpdateOptions{})
+		Expect(err).To(Succeed())
+
+		By("Waiting for the CSR to be signed")
+		Eventually(func() ([]byte, error) {
+			err := k8sClient.Get(ctx, key, &actualCSR)
+			return actualCSR.Status.Certificate, err
+		}, 5).ShouldNot(BeNil())
+
+		By("Requesting revocation")
+		original := actualCSR.DeepCopy()
+		metav1.SetMetaDataAnnotation(&actualCSR.ObjectMeta, annotationKeyRevoke, "superseded")
+		Expect(k8sClient.Patch(ctx, &actualCSR, client.MergeFrom(original))).To(Succeed())
+
+		By("Waiting for the revocation to be recorded")
+		Eventually(func() (string, error) {
+			err := k8sClient.Get(ctx, key, &actualCSR)
+			return actualCSR.Annotations[annotationKeyRevoked], err
+		}, 5).ShouldNot(BeEmpty())
+	})
+})
+
+// unverifiedSigner is a Signer whose certificates always fail verification.
+type unverifiedSigner struct {
+	*fake.Signer
+}
+
+func (o *unverifiedSigner) Verify(csr capi.CertificateSigningRequest, certificate []byte) error {
+	return fmt.Errorf("%w: certificate does not match the CSR", signer.ErrPermanent)
+}
+
+// These tests call the CertificateSigningRequestReconciler directly, with a
+// fake client, so that the calls to the Revoker can be checked.
+var _ = Describe("CertificateSigningRequest Reconciler verification", func() {
+	It("Revokes a certificate which fails verification and fails the CSR", func() {
+		ctx := context.Background()
+		csr := &capi.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "unverified",
+				Annotations: map[string]string{annotationKeyPickupID: "foo-bar"},
+			},
+			Spec: capi.CertificateSigningRequestSpec{
+				SignerName: pointer.StringPtr(sampleSignerName),
+				Request:    []byte(sampleCSR),
+			},
+			Status: capi.CertificateSigningRequestStatus{
+				Conditions: []capi.CertificateSigningRequestCondition{
+					{Type: capi.CertificateApproved},
+				},
+			},
+		}
+		cl := fakeclient.NewFakeClientWithScheme(clientgoscheme.Scheme, csr)
+		backend := &fake.Signer{Certificate: []byte(sampleCertificate)}
+		recorder := record.NewFakeRecorder(10)
+		reconciler := &CertificateSigningRequestReconciler{
+			Client:           cl,
+			Log:              ctrl.Log.WithName("CertificateSigningRequestReconciler"),
+			Signer:           &unverifiedSigner{Signer: backend},
+			SignerName:       sampleSignerName,
+			Filter:           &filter.CSRFilter{SignerName: sampleSignerName},
+			Recorder:         recorder,
+			Revoker:          backend,
+			RevocationReason: "cessation-of-operation",
+		}
+
+		key := client.ObjectKey{Name: "unverified"}
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: key})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(backend.Revoked).To(Equal([]string{"foo-bar"}))
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasonRevoked)))
+		Expect(cl.Get(ctx, key, csr)).To(Succeed())
+		Expect(csr.Status.Certificate).To(BeEmpty())
+		Expect(capihelper.IsCertificateRequestFailed(csr)).To(BeTrue())
+	})
+})
+
+// durationSigner records the requested duration of the CSRs it signs.
+type durationSigner struct {
+	fake.Signer
+	durations []string
+}
+
+func (o *durationSigner) Sign(csr capi.CertificateSigningRequest) (string, error) {
+	o.durations = append(o.durations, csr.Annotations[capihelper.AnnotationKeyRequestDuration])
+	return o.Signer.Sign(csr)
+}
+
+var _ = Describe("CertificateSigningRequest Reconciler duration", func() {
+	It("Drops the requested duration for backends which ignore it", func() {
+		ctx := context.Background()
+		csr := &capi.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "duration",
+				Annotations: map[string]string{capihelper.AnnotationKeyRequestDuration: "1h"},
+			},
+			Spec: capi.CertificateSigningRequestSpec{
+				SignerName: pointer.StringPtr(sampleSignerName),
+				Request:    []byte(sampleCSR),
+			},
+			Status: capi.CertificateSigningRequestStatus{
+				Conditions: []capi.CertificateSigningRequestCondition{
+					{Type: capi.CertificateApproved},
+				},
+			},
+		}
+		cl := fakeclient.NewFakeClientWithScheme(clientgoscheme.Scheme, csr)
+		backend := &durationSigner{}
+		recorder := record.NewFakeRecorder(10)
+		reconciler := &CertificateSigningRequestReconciler{
+			Client:         cl,
+			Log:            ctrl.Log.WithName("CertificateSigningRequestReconciler"),
+			Signer:         backend,
+			SignerName:     sampleSignerName,
+			Filter:         &filter.CSRFilter{SignerName: sampleSignerName},
+			Recorder:       recorder,
+			IgnoreDuration: true,
+		}
+
+		key := client.ObjectKey{Name: "duration"}
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: key})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(backend.durations).To(Equal([]string{""}))
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasonDurationIgnored)))
+		Expect(cl.Get(ctx, key, csr)).To(Succeed())
+		Expect(csr.Annotations[capihelper.AnnotationKeyRequestDuration]).To(Equal("1h"))
+		Expect(csr.Annotations[annotationKeyPickupID]).ToNot(BeEmpty())
+		Expect(capihelper.IsCertificateRequestFailed(csr)).To(BeFalse())
+	})
 })
@@ -0,0 +1,157 @@
+/*
+Copyright 2020 The Cert-Manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/trust"
+)
+
+const (
+	// The kind of the ClusterTrustBundle resource, which is not in the
+	// client-go version used by this project, so it is handled as
+	// unstructured data.
+	kindClusterTrustBundle = "ClusterTrustBundle"
+	// The suffix of the name of the ClusterTrustBundle of each signer name
+	clusterTrustBundleNameSuffix = "venafi"
+)
+
+// ClusterTrustBundlePublisher publishes the CA chain of the signer as a
+// ClusterTrustBundle linked to the signer name, so that pods can mount it
+// using projected volumes.
+// The bundle is updated whenever the chain changes, and every Interval to
+// repair any changes made by others.
+// It runs only on the leader.
+type ClusterTrustBundlePublisher struct {
+	Client     client.Client
+	Log        logr.Logger
+	SignerName string
+	// APIVersion is the API version of the ClusterTrustBundle resource.
+	// E.g. certificates.k8s.io/v1alpha1
+	APIVersion string
+	Chain      *trust.Chain
+	// Source, if set, is used to read the CA chain while the Chain is not
+	// yet known, so that the bundle is published before the first
+	// certificate is picked up.
+	Source   signer.ChainReader
+	Interval time.Duration
+}
+
+var _ manager.Runnable = &ClusterTrustBundlePublisher{}
+
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=clustertrustbundles,verbs=get;create;update
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=signers,verbs=attest
+
+func (o *ClusterTrustBundlePublisher) Start(stop <-chan struct{}) error {
+	updated := o.Chain.Subscribe()
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+	for {
+		if err := o.publish(context.Background()); err != nil {
+			o.Log.Error(err, "error publishing ClusterTrustBundle")
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-updated:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *ClusterTrustBundlePublisher) publish(ctx context.Context) error {
+	log := o.Log.WithName("publish")
+
+	chain := o.Chain.PEM()
+	if len(chain) == 0 && o.Source != nil {
+		log.V(1).Info("Reading CA chain")
+		caPEM, err := o.Source.CAChain(ctx)
+		if err != nil {
+			return fmt.Errorf("error reading CA chain: %v", err)
+		}
+		o.Chain.Set(caPEM)
+		chain = o.Chain.PEM()
+	}
+	if len(chain) == 0 {
+		log.V(1).Info("Not publishing", "reason", "CA chain is not yet known")
+		return nil
+	}
+
+	name := clusterTrustBundleName(o.SignerName)
+	bundle := &unstructured.Unstructured{}
+	bundle.SetAPIVersion(o.APIVersion)
+	bundle.SetKind(kindClusterTrustBundle)
+	err := o.Client.Get(ctx, client.ObjectKey{Name: name}, bundle)
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("error getting ClusterTrustBundle: %v", err)
+	}
+	if err != nil {
+		bundle.SetName(name)
+		if err := setTrustBundle(bundle, o.SignerName, chain); err != nil {
+			return err
+		}
+		log.V(1).Info("Creating ClusterTrustBundle", "name", name)
+		if err := o.Client.Create(ctx, bundle); err != nil {
+			return fmt.Errorf("error creating ClusterTrustBundle: %v", err)
+		}
+		return nil
+	}
+
+	current, _, _ := unstructured.NestedString(bundle.Object, "spec", "trustBundle")
+	signerName, _, _ := unstructured.NestedString(bundle.Object, "spec", "signerName")
+	if signerName == o.SignerName {
+		// The chains published before the signer restarted are kept until
+		// they expire.
+		o.Chain.Merge([]byte(current))
+		chain = o.Chain.PEM()
+	}
+	if bytes.Equal([]byte(current), chain) && signerName == o.SignerName {
+		return nil
+	}
+	if err := setTrustBundle(bundle, o.SignerName, chain); err != nil {
+		return err
+	}
+	log.V(1).Info("Updating ClusterTrustBundle", "name", name)
+	if err := o.Client.Update(ctx, bundle); err != nil {
+		return fmt.Errorf("error updating ClusterTrustBundle: %v", err)
+	}
+	return nil
+}
+
+func setTrustBundle(bundle *unstructured.Unstructured, signerName string, chain []byte) error {
+	if err := unstructured.SetNestedField(bundle.Object, signerName, "spec", "signerName"); err != nil {
+		return err
+	}
+	return unstructured.SetNestedField(bundle.Object, string(chain), "spec", "trustBundle")
+}
+
+// clusterTrustBundleName returns the name of the ClusterTrustBundle of the
+// signer name, which must be prefixed by the signer name with / replaced by :.
+func clusterTrustBundleName(signerName string) string {
+	return strings.ReplaceAll(signerName, "/", ":") + ":" + clusterTrustBundleNameSuffix
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/cert-manager/signer-venafi/internal/trust"
+)
+
+// chainReader is a signer.ChainReader which returns a fixed CA chain.
+type chainReader []byte
+
+func (o chainReader) CAChain(ctx context.Context) ([]byte, error) {
+	return o, nil
+}
+
+// newCAPEM returns a PEM encoded self signed CA certificate.
+func newCAPEM(commonName string) []byte {
+	key, err := pki.GenerateECPrivateKey(pki.ECCurve256)
+	Expect(err).ToNot(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	_, cert, err := pki.SignCertificate(tmpl, tmpl, key.Public(), key)
+	Expect(err).ToNot(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// These tests call the ClusterTrustBundlePublisher directly, with a fake
+// client, because the ClusterTrustBundle resource is not served by the test
+// API server.
+var _ = Describe("ClusterTrustBundle Publisher", func() {
+	const apiVersion = "certificates.k8s.io/v1alpha1"
+
+	var (
+		ctx       context.Context
+		cl        client.Client
+		publisher *ClusterTrustBundlePublisher
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		cl = fake.NewFakeClientWithScheme(clientgoscheme.Scheme)
+		publisher = &ClusterTrustBundlePublisher{
+			Client:     cl,
+			Log:        ctrl.Log.WithName("ClusterTrustBundlePublisher"),
+			SignerName: sampleSignerName,
+			APIVersion: apiVersion,
+			Chain:      &trust.Chain{},
+			Interval:   time.Hour,
+		}
+	})
+	getBundle := func() (*unstructured.Unstructured, error) {
+		bundle := &unstructured.Unstructured{}
+		bundle.SetAPIVersion(apiVersion)
+		bundle.SetKind(kindClusterTrustBundle)
+		err := cl.Get(ctx, client.ObjectKey{Name: clusterTrustBundleName(sampleSignerName)}, bundle)
+		return bundle, err
+	}
+	trustBundle := func() string {
+		bundle, err := getBundle()
+		Expect(err).ToNot(HaveOccurred())
+		value, _, _ := unstructured.NestedString(bundle.Object, "spec", "trustBundle")
+		return value
+	}
+
+	It("Does not publish until the CA chain is known", func() {
+		Expect(publisher.publish(ctx)).To(Succeed())
+		_, err := getBundle()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Publishes the CA chain read from the Source before any pickup", func() {
+		ca := newCAPEM("zone-ca")
+		publisher.Source = chainReader(ca)
+		Expect(publisher.publish(ctx)).To(Succeed())
+		Expect(trustBundle()).To(Equal(string(ca)))
+		Expect(publisher.Chain.PEM()).To(Equal(ca))
+	})
+
+	It("Publishes the CA chain learnt from pickups instead of the Source", func() {
+		picked := newCAPEM("picked-ca")
+		publisher.Chain.Set(picked)
+		publisher.Source = chainReader(newCAPEM("zone-ca"))
+		Expect(publisher.publish(ctx)).To(Succeed())
+		Expect(trustBundle()).To(Equal(string(picked)))
+	})
+
+	It("Updates the bundle when the chain rotates", func() {
+		oldCA := newCAPEM("old-ca")
+		newCA := newCAPEM("new-ca")
+		publisher.Chain.Set(oldCA)
+		Expect(publisher.publish(ctx)).To(Succeed())
+
+		publisher.Chain.Set(newCA)
+		Expect(publisher.publish(ctx)).To(Succeed())
+		Expect(trustBundle()).To(Equal(string(newCA) + string(oldCA)))
+	})
+
+	It("Keeps the previous chains of the published bundle after a restart", func() {
+		oldCA := newCAPEM("old-ca")
+		newCA := newCAPEM("new-ca")
+		publisher.Chain.Set(oldCA)
+		Expect(publisher.publish(ctx)).To(Succeed())
+
+		By("Learning only the new chain after a restart")
+		publisher.Chain = &trust.Chain{}
+		publisher.Chain.Set(newCA)
+		Expect(publisher.publish(ctx)).To(Succeed())
+		Expect(trustBundle()).To(Equal(string(newCA) + string(oldCA)))
+	})
+
+	It("Repairs a bundle changed by others", func() {
+		ca := newCAPEM("ca")
+		publisher.Chain.Set(ca)
+		Expect(publisher.publish(ctx)).To(Succeed())
+
+		bundle, err := getBundle()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unstructured.SetNestedField(bundle.Object, "example.com/other", "spec", "signerName")).To(Succeed())
+		Expect(unstructured.SetNestedField(bundle.Object, "", "spec", "trustBundle")).To(Succeed())
+		Expect(cl.Update(ctx, bundle)).To(Succeed())
+
+		Expect(publisher.publish(ctx)).To(Succeed())
+		bundle, err = getBundle()
+		Expect(err).ToNot(HaveOccurred())
+		signerName, _, _ := unstructured.NestedString(bundle.Object, "spec", "signerName")
+		Expect(signerName).To(Equal(sampleSignerName))
+		Expect(trustBundle()).To(Equal(string(ca)))
+	})
+})
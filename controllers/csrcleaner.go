@@ -0,0 +1,97 @@
+/*
+Copyright 2020 The Cert-Manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	capi "k8s.io/api/certificates/v1beta1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/cleanup"
+	"github.com/cert-manager/signer-venafi/internal/metrics"
+)
+
+// CSRCleaner periodically deletes completed CSRs with one of the signer names,
+// once they are older than the retention period for their state.
+// It runs only on the leader.
+type CSRCleaner struct {
+	Client      client.Client
+	Log         logr.Logger
+	SignerNames []string
+	// Interval is the time between cleanups.
+	Interval time.Duration
+	// The retention periods of issued, failed and denied CSRs.
+	// Zero means that CSRs in that state are never deleted.
+	IssuedRetention time.Duration
+	FailedRetention time.Duration
+	DeniedRetention time.Duration
+}
+
+var _ manager.Runnable = &CSRCleaner{}
+
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=delete
+
+func (o *CSRCleaner) Start(stop <-chan struct{}) error {
+	wait.Until(o.clean, o.Interval, stop)
+	return nil
+}
+
+func (o *CSRCleaner) clean() {
+	log := o.Log.WithName("clean")
+	ctx := context.Background()
+
+	var csrs capi.CertificateSigningRequestList
+	if err := o.Client.List(ctx, &csrs); err != nil {
+		log.Error(err, "error listing CSRs")
+		return
+	}
+	for i := range csrs.Items {
+		csr := &csrs.Items[i]
+		if csr.Spec.SignerName == nil || !capihelper.Contains(o.SignerNames, *csr.Spec.SignerName) || !csr.DeletionTimestamp.IsZero() {
+			continue
+		}
+		state, expired := o.expired(csr)
+		if !expired {
+			continue
+		}
+		log.V(1).Info("Deleting CSR", "certificatesigningrequest", csr.Name, "state", state)
+		if err := o.Client.Delete(ctx, csr); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "error deleting CSR", "certificatesigningrequest", csr.Name)
+			continue
+		}
+		metrics.CSRDeletedTotal.WithLabelValues(*csr.Spec.SignerName, state).Inc()
+	}
+}
+
+// expired returns the state of a completed CSR and whether it is older than
+// the retention period for that state.
+// Deleting a CSR with the revoke finalizer would revoke its certificate, so
+// such CSRs are kept until their certificate has expired.
+func (o *CSRCleaner) expired(csr *capi.CertificateSigningRequest) (string, bool) {
+	retention := cleanup.Retention{
+		Issued: o.IssuedRetention,
+		Failed: o.FailedRetention,
+		Denied: o.DeniedRetention,
+	}
+	return retention.Expired(csr, time.Now(), hasFinalizer(csr, finalizerRevoke))
+}
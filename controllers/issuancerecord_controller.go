@@ -0,0 +1,189 @@
+/*
+Copyright 2020 The Cert-Manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/cert-manager/signer-venafi/internal/metrics"
+	"github.com/cert-manager/signer-venafi/internal/records"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// The revocation reason used for superseded certificates
+const revocationReasonSuperseded = "superseded"
+
+// IssuanceRecordReconciler revokes certificates which have been superseded by
+// a newer certificate for the same identity, once the newer certificate has
+// been issued for longer than the overlap window.
+// It also removes records of certificates which have expired.
+type IssuanceRecordReconciler struct {
+	// Client must read from Cache.
+	client.Client
+	// Cache is the cache of the objects in Namespace, from records.NewCache.
+	Cache      cache.Cache
+	Log        logr.Logger
+	Recorder   record.EventRecorder
+	Revoker    signer.Revoker
+	SignerName string
+	// Namespace is the namespace of the issuance record ConfigMaps.
+	Namespace string
+	// Overlap is the time for which a superseded certificate remains valid
+	// after its successor is issued.
+	Overlap time.Duration
+}
+
+// +kubebuilder:rbac:groups="",namespace=system,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+
+func (r *IssuanceRecordReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithName("Reconcile").WithValues("configmap", req.NamespacedName)
+	ctx := context.Background()
+
+	var cm corev1.ConfigMap
+	if err := r.Client.Get(ctx, req.NamespacedName, &cm); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			log.V(1).Info("Ignoring", "reason", "ConfigMap not found")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("error getting ConfigMap: %v", err)
+	}
+
+	existing, err := records.Decode(&cm)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	var (
+		kept    []records.Record
+		changed bool
+		requeue time.Duration
+	)
+	for i, record := range existing {
+		if record.NotAfter.Before(now) {
+			log.V(1).Info("Removing expired record", "pickup-id", record.PickupID)
+			changed = true
+			continue
+		}
+		if record.Revoked != nil || record.RevocationFailed != "" || i == len(existing)-1 {
+			kept = append(kept, record)
+			continue
+		}
+
+		due := existing[i+1].Issued.Add(r.Overlap)
+		if now.Before(due) {
+			if remaining := due.Sub(now); requeue == 0 || remaining < requeue {
+				requeue = remaining
+			}
+			kept = append(kept, record)
+			continue
+		}
+
+		log.V(1).Info("Revoking superseded certificate", "pickup-id", record.PickupID, "csr", record.CSRName)
+		err := r.Revoker.Revoke(record.PickupID, revocationReasonSuperseded)
+		switch {
+		case errors.Is(err, signer.ErrPermanent):
+			// Retrying would block the revocation of the later
+			// certificates of the identity forever.
+			log.Error(err, "Not revoking superseded certificate", "pickup-id", record.PickupID, "csr", record.CSRName)
+			r.Recorder.Eventf(&cm, corev1.EventTypeWarning, reasonRevokeFailed,
+				"Superseded certificate %q of CSR %q can not be revoked: %v", record.PickupID, record.CSRName, err)
+			existing[i].RevocationFailed = err.Error()
+		case err != nil:
+			return ctrl.Result{}, fmt.Errorf("error revoking superseded certificate: %v", err)
+		default:
+			metrics.SupersededRevokedTotal.WithLabelValues(r.SignerName).Inc()
+			revoked := now
+			existing[i].Revoked = &revoked
+		}
+		kept = append(kept, existing[i])
+
+		// The result of the revocation is saved before the next, so that
+		// it is not repeated if a later revocation fails.
+		if err := r.update(ctx, &cm, append(append([]records.Record(nil), kept...), existing[i+1:]...)); err != nil {
+			return ctrl.Result{}, err
+		}
+		changed = false
+	}
+
+	switch {
+	case len(kept) == 0:
+		if err := r.Client.Delete(ctx, &cm); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("error deleting ConfigMap: %v", err)
+		}
+	case changed:
+		if err := r.update(ctx, &cm, kept); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// update stores the records in the ConfigMap.
+func (r *IssuanceRecordReconciler) update(ctx context.Context, cm *corev1.ConfigMap, kept []records.Record) error {
+	if err := records.Encode(cm, kept); err != nil {
+		return err
+	}
+	if err := r.Client.Update(ctx, cm); err != nil {
+		return fmt.Errorf("error updating ConfigMap: %v", err)
+	}
+	return nil
+}
+
+func (r *IssuanceRecordReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor(eventSourceName)
+	}
+	// The ConfigMaps are watched through the Cache, rather than the cache of
+	// the manager, so that only the records namespace is watched.
+	c, err := controller.New("issuancerecord", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+	return c.Watch(
+		source.NewKindWithCache(&corev1.ConfigMap{}, r.Cache),
+		&handler.EnqueueRequestForObject{},
+		predicate.Funcs{
+			CreateFunc:  func(e event.CreateEvent) bool { return r.isIssuanceRecord(e.Meta) },
+			UpdateFunc:  func(e event.UpdateEvent) bool { return r.isIssuanceRecord(e.MetaNew) },
+			DeleteFunc:  func(e event.DeleteEvent) bool { return false },
+			GenericFunc: func(e event.GenericEvent) bool { return r.isIssuanceRecord(e.Meta) },
+		},
+	)
+}
+
+func (r *IssuanceRecordReconciler) isIssuanceRecord(obj metav1.Object) bool {
+	return obj.GetNamespace() == r.Namespace &&
+		obj.GetLabels()[records.LabelKeyIssuanceRecord] == "true" &&
+		obj.GetAnnotations()[records.AnnotationKeySignerName] == r.SignerName
+}
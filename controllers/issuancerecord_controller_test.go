@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/cert-manager/signer-venafi/internal/records"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	signerfake "github.com/cert-manager/signer-venafi/internal/signer/fake"
+)
+
+// unknownRevoker refuses to revoke the certificates with the unknown pickup
+// ID, as a CA does for certificates which it does not know.
+type unknownRevoker struct {
+	signerfake.Signer
+	unknown string
+}
+
+func (o *unknownRevoker) Revoke(pickupID string, reason string) error {
+	if pickupID == o.unknown {
+		return fmt.Errorf("%w: unknown certificate", signer.ErrPermanent)
+	}
+	return o.Signer.Revoke(pickupID, reason)
+}
+
+// These tests call the IssuanceRecordReconciler directly, with a fake client,
+// so that the calls to the Revoker can be checked.
+var _ = Describe("Issuance Record Reconciler", func() {
+	It("Records permanent revocation failures and revokes the later superseded certificates", func() {
+		ctx := context.Background()
+		now := time.Now()
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "records",
+				Name:      "issued-1",
+				Labels:    map[string]string{records.LabelKeyIssuanceRecord: "true"},
+			},
+		}
+		Expect(records.Encode(cm, []records.Record{
+			{PickupID: "unknown", CSRName: "csr1", Issued: now.Add(-time.Hour * 3), NotAfter: now.Add(time.Hour)},
+			{PickupID: "known", CSRName: "csr2", Issued: now.Add(-time.Hour * 2), NotAfter: now.Add(time.Hour)},
+			{PickupID: "latest", CSRName: "csr3", Issued: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)},
+		})).To(Succeed())
+		cl := fake.NewFakeClientWithScheme(clientgoscheme.Scheme, cm)
+		revoker := &unknownRevoker{unknown: "unknown"}
+		recorder := record.NewFakeRecorder(10)
+		reconciler := &IssuanceRecordReconciler{
+			Client:     cl,
+			Log:        ctrl.Log.WithName("IssuanceRecordReconciler"),
+			Recorder:   recorder,
+			Revoker:    revoker,
+			SignerName: sampleSignerName,
+			Namespace:  "records",
+		}
+		key := client.ObjectKey{Namespace: "records", Name: "issued-1"}
+
+		result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: key})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(revoker.Revoked).To(Equal([]string{"known"}))
+		Expect(<-recorder.Events).To(ContainSubstring("RevokeFailed"))
+
+		Expect(cl.Get(ctx, key, cm)).To(Succeed())
+		saved, err := records.Decode(cm)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(saved).To(HaveLen(3))
+		Expect(saved[0].Revoked).To(BeNil())
+		Expect(saved[0].RevocationFailed).To(ContainSubstring("unknown certificate"))
+		Expect(saved[1].Revoked).ToNot(BeNil())
+		Expect(saved[2].Revoked).To(BeNil())
+
+		By("Not retrying the failed revocation")
+		_, err = reconciler.Reconcile(ctrl.Request{NamespacedName: key})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(revoker.Revoked).To(Equal([]string{"known"}))
+	})
+})
@@ -0,0 +1,185 @@
+/*
+Copyright 2020 The Cert-Manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+const (
+	// The finalizer which causes the certificate to be revoked, or the pending
+	// request to be cancelled, when the CSR is deleted
+	finalizerRevoke = "signer-venafi.cert-manager.io/revoke"
+	// The annotation which requests revocation of the issued certificate.
+	// The value is the revocation reason, or empty for the default reason.
+	annotationKeyRevoke = "signer-venafi.cert-manager.io/revoke"
+	// The annotation which records the time at which the certificate was
+	// revoked
+	annotationKeyRevoked = "signer-venafi.cert-manager.io/revoked"
+	// The reasons used in revocation events
+	reasonRevoked      = "Revoked"
+	reasonRevokeFailed = "RevokeFailed"
+	reasonCancelled    = "Cancelled"
+	reasonCancelFailed = "CancelFailed"
+)
+
+// reconcileRevocation revokes the certificate of a CSR which has the revoke
+// annotation, and revokes the certificate or cancels the pending request of a
+// CSR with the revoke finalizer which has been deleted.
+// It returns true if the CSR needs no further processing.
+func (r *CertificateSigningRequestReconciler) reconcileRevocation(ctx context.Context, log logr.Logger, csr *capi.CertificateSigningRequest) (bool, error) {
+	if r.Revoker == nil || csr.Spec.SignerName == nil || *csr.Spec.SignerName != r.SignerName {
+		return false, nil
+	}
+	pickupID := csr.Annotations[annotationKeyPickupID]
+
+	if !csr.DeletionTimestamp.IsZero() {
+		if !hasFinalizer(csr, finalizerRevoke) {
+			return true, nil
+		}
+		if pickupID != "" && csr.Annotations[annotationKeyRevoked] == "" {
+			err := r.revokeOrCancel(log, csr, pickupID)
+			switch {
+			case errors.Is(err, signer.ErrPermanent):
+				// Retrying would keep the CSR from being deleted forever.
+				log.Error(err, "Removing finalizer without revoking")
+				r.Recorder.Event(csr, corev1.EventTypeWarning, reasonRevokeFailed,
+					"Removing the revoke finalizer because the certificate can not be revoked or its request cancelled")
+			case err != nil:
+				return true, err
+			}
+		}
+		original := csr.DeepCopy()
+		removeFinalizer(csr, finalizerRevoke)
+		if err := r.Client.Patch(ctx, csr, client.MergeFrom(original)); err != nil {
+			return true, fmt.Errorf("error removing finalizer: %v", err)
+		}
+		return true, nil
+	}
+
+	reason, requested := csr.Annotations[annotationKeyRevoke]
+	if !requested || csr.Annotations[annotationKeyRevoked] != "" {
+		return false, nil
+	}
+	if pickupID == "" || csr.Status.Certificate == nil {
+		log.V(1).Info("Ignoring revocation request", "reason", "CSR has not been signed")
+		return false, nil
+	}
+	if reason == "" {
+		reason = r.RevocationReason
+	}
+
+	log.V(1).Info("Revoking", "reason", reason)
+	err := signer.ValidateRevocationReason(reason)
+	if err == nil {
+		err = r.Revoker.Revoke(pickupID, reason)
+	}
+	if err != nil {
+		r.Recorder.Event(csr, corev1.EventTypeWarning, reasonRevokeFailed, err.Error())
+		if errors.Is(err, signer.ErrPermanent) {
+			// Retrying will not succeed until the annotation is changed,
+			// which triggers another reconcile.
+			log.Error(err, "Not revoking")
+			return true, nil
+		}
+		return true, fmt.Errorf("error revoking: %v", err)
+	}
+	r.Recorder.Eventf(csr, corev1.EventTypeNormal, reasonRevoked, "Certificate revoked with reason %q", reason)
+
+	original := csr.DeepCopy()
+	metav1.SetMetaDataAnnotation(&csr.ObjectMeta, annotationKeyRevoked, time.Now().UTC().Format(time.RFC3339))
+	if err := r.Client.Patch(ctx, csr, client.MergeFrom(original)); err != nil {
+		return true, fmt.Errorf("error patching CSR: %v", err)
+	}
+	return true, nil
+}
+
+// revokeOrCancel revokes the certificate of a deleted CSR if it was issued,
+// or otherwise cancels the pending request.
+func (r *CertificateSigningRequestReconciler) revokeOrCancel(log logr.Logger, csr *capi.CertificateSigningRequest, pickupID string) error {
+	if csr.Status.Certificate == nil {
+		log.V(1).Info("Cancelling pending request")
+		if err := r.Revoker.Cancel(pickupID); err != nil {
+			r.Recorder.Event(csr, corev1.EventTypeWarning, reasonCancelFailed, err.Error())
+			return fmt.Errorf("error cancelling: %w", err)
+		}
+		r.Recorder.Event(csr, corev1.EventTypeNormal, reasonCancelled, "Pending certificate request cancelled because the CSR was deleted")
+		return nil
+	}
+	log.V(1).Info("Revoking deleted CSR", "reason", r.RevocationReason)
+	if err := r.Revoker.Revoke(pickupID, r.RevocationReason); err != nil {
+		r.Recorder.Event(csr, corev1.EventTypeWarning, reasonRevokeFailed, err.Error())
+		return fmt.Errorf("error revoking: %w", err)
+	}
+	r.Recorder.Eventf(csr, corev1.EventTypeNormal, reasonRevoked, "Certificate revoked with reason %q because the CSR was deleted", r.RevocationReason)
+	return nil
+}
+
+// revokeUnverified revokes a certificate which failed verification, so that it
+// can not be used even though it is never delivered, e.g. an intermediate CA
+// certificate with the wrong constraints. It does nothing if revoker is nil.
+// Permanent revocation errors are only recorded, because retrying will not
+// succeed, and the request is failed anyway.
+func revokeUnverified(log logr.Logger, recorder record.EventRecorder, revoker signer.Revoker, reason string, obj runtime.Object, pickupID string) error {
+	if revoker == nil {
+		return nil
+	}
+	log.V(1).Info("Revoking unverified certificate", "reason", reason)
+	if err := revoker.Revoke(pickupID, reason); err != nil {
+		recorder.Event(obj, corev1.EventTypeWarning, reasonRevokeFailed, err.Error())
+		if errors.Is(err, signer.ErrPermanent) {
+			log.Error(err, "Not revoking unverified certificate")
+			return nil
+		}
+		return fmt.Errorf("error revoking: %w", err)
+	}
+	recorder.Eventf(obj, corev1.EventTypeNormal, reasonRevoked,
+		"Certificate revoked with reason %q because it failed verification", reason)
+	return nil
+}
+
+func hasFinalizer(obj metav1.Object, finalizer string) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFinalizer(obj metav1.Object, finalizer string) {
+	var finalizers []string
+	for _, f := range obj.GetFinalizers() {
+		if f != finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	obj.SetFinalizers(finalizers)
+}
@@ -0,0 +1,414 @@
+/*
+Copyright 2020 The Cert-Manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	certificatesclient "k8s.io/client-go/kubernetes/typed/certificates/v1beta1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/policy"
+	"github.com/cert-manager/signer-venafi/internal/secrets"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+const (
+	// The Secret label which selects the Secrets that are watched, and the
+	// annotations which request a certificate
+	labelKeySecretManaged         = "signer-venafi.cert-manager.io/managed"
+	labelValueSecretManaged       = "true"
+	annotationKeySecretSignerName = "signer-venafi.cert-manager.io/signer-name"
+	annotationKeySecretDNSNames   = "signer-venafi.cert-manager.io/dns-names"
+	annotationKeySecretDuration   = "signer-venafi.cert-manager.io/duration"
+	// The reason of the Approved condition of auto-approved CSRs
+	reasonSecretAutoApproved = "AutoApproved"
+	// The reasons used in Secret events
+	reasonInvalidSecret     = "InvalidSecret"
+	reasonDNSNamesNotOwned  = "DNSNamesNotOwned"
+	reasonRequested         = "Requested"
+	reasonIssued            = "Issued"
+	reasonRequestFailed     = "RequestFailed"
+	reasonPendingCSRInvalid = "PendingCSRInvalid"
+)
+
+// SecretReconciler manages the private key and certificate of Secrets which
+// have the managed label and are annotated with the signer name.
+// It generates a private key, records it in the Secret with the name of a new
+// CSR, then creates that CSR with the signer name, and stores the issued
+// certificate in the Secret. The CSR is approved by the Approver if it is set,
+// and otherwise must be approved like any other.
+// The certificate is renewed when two thirds of its lifetime has passed.
+type SecretReconciler struct {
+	client.Client
+	// Reader reads the Secrets from the API server, because only those with
+	// the managed label are watched, so they are not in the cache of the
+	// Client.
+	Reader     client.Reader
+	Log        logr.Logger
+	SignerName string
+	Recorder   record.EventRecorder
+	// Approver approves the CSRs created for the Secrets, once the DNS names
+	// have been checked against the namespace of the Secret again.
+	// If it is nil, the CSRs are not approved.
+	Approver certificatesclient.CertificateSigningRequestInterface
+	// Ownership checks that the DNS names requested by each Secret are owned
+	// by the namespace of the Secret, before its CSR is created.
+	// The CSRs are requested by the signer, rather than by the user who
+	// annotated the Secret, so the namespace of the Secret is the only
+	// identity against which they can be checked.
+	Ownership *policy.DNSOwnership
+}
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=create
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests/approval,verbs=update
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=signers,verbs=approve
+
+func (r *SecretReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithName("Reconcile").WithValues("secret", req.NamespacedName)
+	ctx := context.Background()
+
+	var secret corev1.Secret
+	if err := r.Reader.Get(ctx, req.NamespacedName, &secret); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			log.V(1).Info("Ignoring", "reason", "Secret not found")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("error getting Secret: %v", err)
+	}
+	if secret.Annotations[annotationKeySecretSignerName] != r.SignerName {
+		log.V(1).Info("Ignoring", "reason", "Secret signer name does not match")
+		return ctrl.Result{}, nil
+	}
+
+	dnsNames := capihelper.SplitList(secret.Annotations[annotationKeySecretDNSNames])
+	if len(dnsNames) == 0 {
+		r.Recorder.Eventf(&secret, corev1.EventTypeWarning, reasonInvalidSecret, "Annotation %s is required", annotationKeySecretDNSNames)
+		return ctrl.Result{}, nil
+	}
+	// Without the duration annotation, the duration is left to the backend.
+	var duration time.Duration
+	if value := secret.Annotations[annotationKeySecretDuration]; value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			r.Recorder.Eventf(&secret, corev1.EventTypeWarning, reasonInvalidSecret, "Invalid %s annotation: %v", annotationKeySecretDuration, err)
+			return ctrl.Result{}, nil
+		}
+		duration = d
+	}
+
+	if csrName := secret.Annotations[secrets.AnnotationKeyPendingCSR]; csrName != "" {
+		return r.reconcilePending(ctx, log, &secret, csrName, dnsNames, duration)
+	}
+
+	if renewAt, ok := renewalTime(&secret, dnsNames); ok && time.Now().Before(renewAt) {
+		log.V(1).Info("Certificate is up to date", "renew-at", renewAt)
+		return ctrl.Result{RequeueAfter: time.Until(renewAt)}, nil
+	}
+	return ctrl.Result{}, r.request(ctx, log, &secret, dnsNames)
+}
+
+// request generates a new private key and records it in the Secret, with the
+// name of the CSR which is then created for it by reconcilePending.
+// The Secret is updated before the CSR is created, so that the CSR is never
+// created without the Secret recording it.
+func (r *SecretReconciler) request(ctx context.Context, log logr.Logger, secret *corev1.Secret, dnsNames []string) error {
+	if ok, err := r.checkOwnership(ctx, secret, dnsNames); !ok {
+		return err
+	}
+
+	key, err := pki.GenerateECPrivateKey(pki.ECCurve256)
+	if err != nil {
+		return fmt.Errorf("error generating private key: %v", err)
+	}
+	keyPEM, err := pki.EncodeECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	csrName := fmt.Sprintf("%s-%s-%s", secret.Namespace, secret.Name, utilrand.String(5))
+	log.V(1).Info("Requesting certificate", "certificatesigningrequest", csrName)
+
+	metav1.SetMetaDataAnnotation(&secret.ObjectMeta, secrets.AnnotationKeyPendingCSR, csrName)
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[secrets.DataKeyPendingKey] = keyPEM
+	if err := r.Client.Update(ctx, secret); err != nil {
+		return fmt.Errorf("error updating Secret: %v", err)
+	}
+	return nil
+}
+
+// checkOwnership returns true if the DNS names are owned by the namespace of
+// the Secret. Otherwise it records an event on the Secret, and returns an
+// error if the check should be retried.
+func (r *SecretReconciler) checkOwnership(ctx context.Context, secret *corev1.Secret, dnsNames []string) (bool, error) {
+	err := r.Ownership.CheckNamespace(ctx, secret.Namespace, dnsNames)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, signer.ErrTemporary):
+		return false, err
+	}
+	r.Recorder.Event(secret, corev1.EventTypeWarning, reasonDNSNamesNotOwned, err.Error())
+	return false, nil
+}
+
+// create creates the pending CSR of the Secret for its pending private key.
+func (r *SecretReconciler) create(ctx context.Context, log logr.Logger, secret *corev1.Secret, csrName string, dnsNames []string, duration time.Duration) error {
+	if ok, err := r.checkOwnership(ctx, secret, dnsNames); !ok {
+		return err
+	}
+
+	key, err := pki.DecodePrivateKeyBytes(secret.Data[secrets.DataKeyPendingKey])
+	if err != nil {
+		log.Error(err, "Invalid pending private key, requesting a new certificate")
+		return r.clearPending(ctx, secret)
+	}
+	request, err := certificateRequest(key, dnsNames)
+	if err != nil {
+		return err
+	}
+
+	annotations := map[string]string{
+		secrets.AnnotationKeyManagedSecret: secret.Namespace + "/" + secret.Name,
+	}
+	if duration > 0 {
+		annotations[capihelper.AnnotationKeyRequestDuration] = duration.String()
+	}
+	signerName := r.SignerName
+	csr := &capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        csrName,
+			Annotations: annotations,
+		},
+		Spec: capi.CertificateSigningRequestSpec{
+			SignerName: &signerName,
+			Request:    request,
+			Usages: []capi.KeyUsage{
+				capi.UsageDigitalSignature,
+				capi.UsageKeyEncipherment,
+				capi.UsageServerAuth,
+			},
+		},
+	}
+	log.V(1).Info("Creating CSR", "certificatesigningrequest", csrName, "dns-names", dnsNames, "duration", duration)
+	if err := r.Client.Create(ctx, csr); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			// Created by an earlier reconcile, whose CSR is not yet cached.
+			return nil
+		}
+		return fmt.Errorf("error creating CSR: %v", err)
+	}
+	r.Recorder.Eventf(secret, corev1.EventTypeNormal, reasonRequested, "Created CSR %s", csr.Name)
+	return nil
+}
+
+// certificateRequest returns a PEM encoded certificate request for the DNS
+// names, signed by the key.
+func certificateRequest(key crypto.Signer, dnsNames []string) ([]byte, error) {
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: dnsNames[0]},
+		DNSNames: dnsNames,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CSR: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}), nil
+}
+
+// reconcilePending creates the pending CSR if it does not exist, and stores
+// its certificate in the Secret once it has been issued.
+// If the CSR is denied or fails, it is left in place, and a new CSR is only
+// created once it has been deleted.
+func (r *SecretReconciler) reconcilePending(ctx context.Context, log logr.Logger, secret *corev1.Secret, csrName string, dnsNames []string, duration time.Duration) (ctrl.Result, error) {
+	var csr capi.CertificateSigningRequest
+	err := r.Client.Get(ctx, client.ObjectKey{Name: csrName}, &csr)
+	switch {
+	case client.IgnoreNotFound(err) == nil && err != nil:
+		return ctrl.Result{}, r.create(ctx, log, secret, csrName, dnsNames, duration)
+	case err != nil:
+		return ctrl.Result{}, fmt.Errorf("error getting CSR: %v", err)
+	}
+
+	if csr.Annotations[secrets.AnnotationKeyManagedSecret] != secret.Namespace+"/"+secret.Name {
+		r.Recorder.Eventf(secret, corev1.EventTypeWarning, reasonPendingCSRInvalid,
+			"CSR %s was not created for this Secret, requesting a new certificate", csr.Name)
+		return ctrl.Result{}, r.clearPending(ctx, secret)
+	}
+
+	_, denied := capihelper.GetCertApprovalCondition(&csr.Status)
+	if denied || capihelper.IsCertificateRequestFailed(&csr) {
+		r.Recorder.Eventf(secret, corev1.EventTypeWarning, reasonRequestFailed,
+			"CSR %s was denied or failed. Delete the CSR to request a new certificate", csr.Name)
+		return ctrl.Result{}, nil
+	}
+	if csr.Status.Certificate == nil {
+		if approved, _ := capihelper.GetCertApprovalCondition(&csr.Status); !approved && r.Approver != nil {
+			return ctrl.Result{}, r.approve(ctx, log, secret, &csr, dnsNames)
+		}
+		log.V(1).Info("Waiting for CSR to be signed", "certificatesigningrequest", csrName)
+		return ctrl.Result{}, nil
+	}
+
+	cert, err := pki.DecodeX509CertificateBytes(csr.Status.Certificate)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error decoding certificate: %v", err)
+	}
+	key, err := pki.DecodePrivateKeyBytes(secret.Data[secrets.DataKeyPendingKey])
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error decoding pending private key: %v", err)
+	}
+	if ok, err := pki.PublicKeyMatchesCertificate(key.Public(), cert); err != nil || !ok {
+		return ctrl.Result{}, fmt.Errorf("certificate of CSR %s does not match the pending private key", csr.Name)
+	}
+
+	secret.Data[corev1.TLSCertKey] = csr.Status.Certificate
+	secret.Data[corev1.TLSPrivateKeyKey] = secret.Data[secrets.DataKeyPendingKey]
+	delete(secret.Data, secrets.DataKeyPendingKey)
+	delete(secret.Annotations, secrets.AnnotationKeyPendingCSR)
+	if err := r.Client.Update(ctx, secret); err != nil {
+		return ctrl.Result{}, fmt.Errorf("error updating Secret: %v", err)
+	}
+	r.Recorder.Eventf(secret, corev1.EventTypeNormal, reasonIssued, "Certificate issued, valid until %s", cert.NotAfter)
+	return ctrl.Result{}, nil
+}
+
+// approve approves the pending CSR of the Secret, if its DNS names are still
+// owned by the namespace of the Secret.
+func (r *SecretReconciler) approve(ctx context.Context, log logr.Logger, secret *corev1.Secret, csr *capi.CertificateSigningRequest, dnsNames []string) error {
+	if ok, err := r.checkOwnership(ctx, secret, dnsNames); !ok {
+		return err
+	}
+	log.V(1).Info("Approving CSR", "certificatesigningrequest", csr.Name)
+	csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+		Type:           capi.CertificateApproved,
+		Reason:         reasonSecretAutoApproved,
+		Message:        fmt.Sprintf("Requested for Secret %s/%s", secret.Namespace, secret.Name),
+		LastUpdateTime: metav1.Now(),
+	})
+	if _, err := r.Approver.UpdateApproval(ctx, csr, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error approving CSR: %v", err)
+	}
+	return nil
+}
+
+// clearPending removes the pending CSR and private key from the Secret, so
+// that a new certificate is requested.
+func (r *SecretReconciler) clearPending(ctx context.Context, secret *corev1.Secret) error {
+	delete(secret.Annotations, secrets.AnnotationKeyPendingCSR)
+	delete(secret.Data, secrets.DataKeyPendingKey)
+	if err := r.Client.Update(ctx, secret); err != nil {
+		return fmt.Errorf("error updating Secret: %v", err)
+	}
+	return nil
+}
+
+// renewalTime returns the time at which the certificate in the Secret should
+// be renewed, and false if there is no usable certificate for the DNS names.
+func renewalTime(secret *corev1.Secret, dnsNames []string) (time.Time, bool) {
+	if len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		return time.Time{}, false
+	}
+	cert, err := pki.DecodeX509CertificateBytes(secret.Data[corev1.TLSCertKey])
+	if err != nil || !capihelper.EqualStrings(cert.DNSNames, dnsNames) {
+		return time.Time{}, false
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(lifetime * 2 / 3), true
+}
+
+func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor(eventSourceName)
+	}
+	if r.Reader == nil {
+		r.Reader = mgr.GetAPIReader()
+	}
+
+	// Only the Secrets with the managed label are watched, rather than
+	// caching every Secret in the cluster.
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labels.Set{labelKeySecretManaged: labelValueSecretManaged}.String()
+		}))
+	managedSecrets := factory.Core().V1().Secrets().Informer()
+	err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		factory.Start(stop)
+		<-stop
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+
+	c, err := controller.New("secret", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Informer{Informer: managedSecrets}, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+	return c.Watch(
+		&source.Kind{Type: &capi.CertificateSigningRequest{}},
+		&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(csrToManagedSecret)},
+	)
+}
+
+// csrToManagedSecret maps a CSR to the Secret for which it was created.
+func csrToManagedSecret(o handler.MapObject) []reconcile.Request {
+	parts := strings.Split(o.Meta.GetAnnotations()[secrets.AnnotationKeyManagedSecret], "/")
+	if len(parts) != 2 {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: parts[0], Name: parts[1]}},
+	}
+}
@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/policy"
+	"github.com/cert-manager/signer-venafi/internal/secrets"
+)
+
+// These tests call the SecretReconciler directly, with a fake client, so that
+// the order in which it writes the Secret and the CSR can be checked.
+var _ = Describe("Secret Reconciler", func() {
+	var (
+		ctx        context.Context
+		cl         client.Client
+		recorder   *record.FakeRecorder
+		reconciler *SecretReconciler
+		key        client.ObjectKey
+	)
+
+	newSecret := func(dnsNames string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "ns1",
+				Name:      "secret1",
+				Annotations: map[string]string{
+					annotationKeySecretSignerName: sampleSignerName,
+					annotationKeySecretDNSNames:   dnsNames,
+				},
+			},
+		}
+	}
+	setup := func(objects ...runtime.Object) {
+		ctx = context.Background()
+		objects = append(objects,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "app"}},
+		)
+		cl = fake.NewFakeClientWithScheme(clientgoscheme.Scheme, objects...)
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &SecretReconciler{
+			Client:     cl,
+			Reader:     cl,
+			Log:        ctrl.Log.WithName("SecretReconciler"),
+			SignerName: sampleSignerName,
+			Recorder:   recorder,
+			Ownership:  &policy.DNSOwnership{Client: cl, ClusterDomain: "cluster.local"},
+		}
+		key = client.ObjectKey{Namespace: "ns1", Name: "secret1"}
+	}
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: key})
+		Expect(err).ToNot(HaveOccurred())
+	}
+	getSecret := func() *corev1.Secret {
+		var secret corev1.Secret
+		Expect(cl.Get(ctx, key, &secret)).To(Succeed())
+		return &secret
+	}
+	listCSRs := func() []capi.CertificateSigningRequest {
+		var csrs capi.CertificateSigningRequestList
+		Expect(cl.List(ctx, &csrs)).To(Succeed())
+		return csrs.Items
+	}
+
+	It("Records the pending CSR in the Secret before creating it", func() {
+		setup(newSecret("app.ns1.svc"))
+
+		By("Recording the pending CSR name and private key")
+		reconcile()
+		secret := getSecret()
+		csrName := secret.Annotations[secrets.AnnotationKeyPendingCSR]
+		Expect(csrName).ToNot(BeEmpty())
+		Expect(secret.Data[secrets.DataKeyPendingKey]).ToNot(BeEmpty())
+		Expect(listCSRs()).To(BeEmpty())
+
+		By("Creating the CSR for the pending private key")
+		reconcile()
+		csrs := listCSRs()
+		Expect(csrs).To(HaveLen(1))
+		csr := csrs[0]
+		Expect(csr.Name).To(Equal(csrName))
+		Expect(csr.Annotations[secrets.AnnotationKeyManagedSecret]).To(Equal("ns1/secret1"))
+		Expect(csr.Annotations).ToNot(HaveKey(capihelper.AnnotationKeyRequestDuration), "the duration should be left to the backend")
+		privateKey, err := pki.DecodePrivateKeyBytes(secret.Data[secrets.DataKeyPendingKey])
+		Expect(err).ToNot(HaveOccurred())
+		req, err := pki.DecodeX509CertificateRequestBytes(csr.Spec.Request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(req.DNSNames).To(Equal([]string{"app.ns1.svc"}))
+		Expect(pki.PublicKeyMatchesCSR(privateKey.Public(), req)).To(BeTrue())
+
+		By("Not creating a duplicate CSR")
+		reconcile()
+		Expect(listCSRs()).To(HaveLen(1))
+	})
+
+	It("Creates the pending CSR again if it is deleted", func() {
+		setup(newSecret("app.ns1.svc"))
+		reconcile()
+		reconcile()
+		csrs := listCSRs()
+		Expect(csrs).To(HaveLen(1))
+		Expect(cl.Delete(ctx, &csrs[0])).To(Succeed())
+
+		reconcile()
+		recreated := listCSRs()
+		Expect(recreated).To(HaveLen(1))
+		Expect(recreated[0].Name).To(Equal(csrs[0].Name))
+		before, err := pki.DecodeX509CertificateRequestBytes(csrs[0].Spec.Request)
+		Expect(err).ToNot(HaveOccurred())
+		after, err := pki.DecodeX509CertificateRequestBytes(recreated[0].Spec.Request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(after.PublicKey).To(Equal(before.PublicKey), "the CSR should be for the same pending private key")
+	})
+
+	It("Does not request DNS names which are not owned by the namespace of the Secret", func() {
+		setup(newSecret("app.ns1.svc,www.example.com"))
+		reconcile()
+		Expect(getSecret().Annotations).ToNot(HaveKey(secrets.AnnotationKeyPendingCSR))
+		Expect(listCSRs()).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasonDNSNamesNotOwned)))
+	})
+
+	It("Does not create the pending CSR once the DNS names are no longer owned", func() {
+		setup(newSecret("app.ns1.svc"))
+		reconcile()
+		secret := getSecret()
+		secret.Annotations[annotationKeySecretDNSNames] = "www.example.com"
+		Expect(cl.Update(ctx, secret)).To(Succeed())
+
+		reconcile()
+		Expect(listCSRs()).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasonDNSNamesNotOwned)))
+	})
+
+	It("Requests a new certificate if the pending CSR was not created for the Secret", func() {
+		secret := newSecret("app.ns1.svc")
+		secret.Annotations[secrets.AnnotationKeyPendingCSR] = "other"
+		setup(secret, &capi.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "other",
+				Annotations: map[string]string{secrets.AnnotationKeyManagedSecret: "ns2/secret1"},
+			},
+		})
+		reconcile()
+		Expect(getSecret().Annotations).ToNot(HaveKey(secrets.AnnotationKeyPendingCSR))
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasonPendingCSRInvalid)))
+	})
+
+	It("Requests the duration of the Secret", func() {
+		secret := newSecret("app.ns1.svc")
+		secret.Annotations[annotationKeySecretDuration] = "2160h"
+		setup(secret)
+		reconcile()
+		reconcile()
+		csrs := listCSRs()
+		Expect(csrs).To(HaveLen(1))
+		Expect(csrs[0].Annotations[capihelper.AnnotationKeyRequestDuration]).To(Equal("2160h0m0s"))
+	})
+
+	It("Approves the pending CSR if it has an Approver", func() {
+		setup(newSecret("app.ns1.svc"))
+		clientset := kubefake.NewSimpleClientset()
+		reconciler.Approver = clientset.CertificatesV1beta1().CertificateSigningRequests()
+		reconcile()
+		reconcile()
+		csrs := listCSRs()
+		Expect(csrs).To(HaveLen(1))
+		_, err := reconciler.Approver.Create(ctx, &csrs[0], metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		reconcile()
+		approved, err := reconciler.Approver.Get(ctx, csrs[0].Name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(approved.Status.Conditions).To(HaveLen(1))
+		Expect(approved.Status.Conditions[0].Type).To(Equal(capi.CertificateApproved))
+		Expect(approved.Status.Conditions[0].Reason).To(Equal(reasonSecretAutoApproved))
+	})
+
+	It("Stores the issued certificate and private key in the Secret", func() {
+		setup(newSecret("app.ns1.svc"))
+		reconcile()
+		reconcile()
+		secret := getSecret()
+		pendingKey := secret.Data[secrets.DataKeyPendingKey]
+
+		By("Issuing a certificate for the pending private key")
+		privateKey, err := pki.DecodePrivateKeyBytes(pendingKey)
+		Expect(err).ToNot(HaveOccurred())
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "app.ns1.svc"},
+			DNSNames:     []string{"app.ns1.svc"},
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, privateKey.Public(), privateKey)
+		Expect(err).ToNot(HaveOccurred())
+		certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		csr := listCSRs()[0]
+		csr.Status.Certificate = certificate
+		Expect(cl.Update(ctx, &csr)).To(Succeed())
+
+		reconcile()
+		secret = getSecret()
+		Expect(secret.Data[corev1.TLSCertKey]).To(Equal(certificate))
+		Expect(secret.Data[corev1.TLSPrivateKeyKey]).To(Equal(pendingKey))
+		Expect(secret.Data).ToNot(HaveKey(secrets.DataKeyPendingKey))
+		Expect(secret.Annotations).ToNot(HaveKey(secrets.AnnotationKeyPendingCSR))
+
+		By("Not renewing the certificate before two thirds of its lifetime")
+		result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: key})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+		Expect(getSecret().Annotations).ToNot(HaveKey(secrets.AnnotationKeyPendingCSR))
+	})
+})
@@ -95,12 +95,15 @@ var _ = BeforeSuite(func(done Done) {
 	})
 	Expect(err).ToNot(HaveOccurred())
 
+	fakeSigner := &fake.Signer{Certificate: []byte(sampleCertificate)}
 	err = (&CertificateSigningRequestReconciler{
-		Client:     mgr.GetClient(),
-		Log:        ctrl.Log.WithName("controllers").WithName("CertificateSigningRequestReconciler"),
-		Scheme:     mgr.GetScheme(),
-		Signer:     &fake.Signer{Certificate: []byte(sampleCertificate)},
-		SignerName: sampleSignerName,
+		Client:           mgr.GetClient(),
+		Log:              ctrl.Log.WithName("controllers").WithName("CertificateSigningRequestReconciler"),
+		Scheme:           mgr.GetScheme(),
+		Signer:           fakeSigner,
+		SignerName:       sampleSignerName,
+		Revoker:          fakeSigner,
+		RevocationReason: "cessation-of-operation",
 	}).SetupWithManager(mgr)
 
 	doneMgr = make(chan struct{})
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	capi "k8s.io/api/certificates/v1beta1"
+)
+
+// The annotations used by cert-manager clients which target Kubernetes CSRs,
+// to request certificate properties which can not be expressed in the
+// certificates/v1beta1 API.
+const (
+	// AnnotationKeyRequestDuration is the requested duration of the
+	// certificate. E.g. 2160h
+	AnnotationKeyRequestDuration = "experimental.cert-manager.io/request-duration"
+	// AnnotationKeyRequestIsCA requests a CA certificate, if its value is
+	// "true".
+	AnnotationKeyRequestIsCA = "experimental.cert-manager.io/request-is-ca"
+)
+
+// GetRequestDuration returns the duration requested by the CSR annotation, or
+// zero if the CSR has no such annotation.
+func GetRequestDuration(csr *capi.CertificateSigningRequest) (time.Duration, error) {
+	value, ok := csr.Annotations[AnnotationKeyRequestDuration]
+	if !ok {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation: %v", AnnotationKeyRequestDuration, err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("invalid %s annotation: duration must be positive", AnnotationKeyRequestDuration)
+	}
+	return duration, nil
+}
+
+// GetRequestIsCA returns true if the CSR annotation requests a CA
+// certificate.
+func GetRequestIsCA(csr *capi.CertificateSigningRequest) (bool, error) {
+	value, ok := csr.Annotations[AnnotationKeyRequestIsCA]
+	if !ok {
+		return false, nil
+	}
+	isCA, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s annotation: %v", AnnotationKeyRequestIsCA, err)
+	}
+	return isCA, nil
+}
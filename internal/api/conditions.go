@@ -0,0 +1,33 @@
+package api
+
+import (
+	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CertificateFailed is the condition type added to a CSR by a signer which
+// refuses or is unable to sign it.
+// It is not defined in certificates/v1beta1 until Kubernetes 1.19.
+const CertificateFailed capi.RequestConditionType = "Failed"
+
+// IsCertificateRequestFailed returns true if a certificate request has the
+// "Failed" condition; false otherwise.
+func IsCertificateRequestFailed(csr *capi.CertificateSigningRequest) bool {
+	for _, c := range csr.Status.Conditions {
+		if c.Type == CertificateFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// GetApprovalTime returns the last update time of the Approved condition of a
+// certificate request, or a zero time if it has not been approved.
+func GetApprovalTime(csr *capi.CertificateSigningRequest) metav1.Time {
+	for _, c := range csr.Status.Conditions {
+		if c.Type == capi.CertificateApproved {
+			return c.LastUpdateTime
+		}
+	}
+	return metav1.Time{}
+}
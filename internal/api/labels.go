@@ -0,0 +1,9 @@
+package api
+
+// LabelKeyManagedBy and LabelValueManagedBy label the Secrets and ConfigMaps
+// which are written by the signer. Existing objects without this label are
+// never overwritten.
+const (
+	LabelKeyManagedBy   = "app.kubernetes.io/managed-by"
+	LabelValueManagedBy = "signer-venafi"
+)
@@ -0,0 +1,93 @@
+// Package approval implements the validating admission webhooks which enforce
+// the parts of the approval rules that depend on who approved a CSR or a
+// cert-manager CertificateRequest.
+// Neither records the user who approved it, or who added an annotation, so
+// these are checked against the authenticated user of each request to the API
+// server, before the change is stored.
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	capi "k8s.io/api/certificates/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/cert-manager/signer-venafi/internal/filter"
+)
+
+// Path is the path at which the webhook is served.
+const Path = "/validate-certificates-k8s-io-v1beta1-certificatesigningrequest"
+
+// +kubebuilder:webhook:path=/validate-certificates-k8s-io-v1beta1-certificatesigningrequest,mutating=false,failurePolicy=fail,groups=certificates.k8s.io,resources=certificatesigningrequests;certificatesigningrequests/approval;certificatesigningrequests/status,verbs=create;update,versions=v1beta1,name=approval.signer-venafi.cert-manager.io
+
+// Validator refuses changes to the CSRs of its signer names which do not
+// satisfy the ApprovalRules or Quorum of the filter of the signer name:
+//
+//   - Approved conditions added by users who are not allowed to approve, or
+//     with a reason which is not accepted.
+//   - Approver annotations added or changed by a user other than the approver
+//     they name.
+type Validator struct {
+	// Filters are the filters of each signer name.
+	Filters map[string]*filter.CSRFilter
+}
+
+var _ admission.Handler = &Validator{}
+
+func (o *Validator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var csr, old capi.CertificateSigningRequest
+	if err := json.Unmarshal(req.Object.Raw, &csr); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if req.Operation == admissionv1beta1.Update {
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	if csr.Spec.SignerName == nil {
+		return admission.Allowed("")
+	}
+	f, ok := o.Filters[*csr.Spec.SignerName]
+	if !ok {
+		return admission.Allowed("")
+	}
+
+	if f.Quorum != nil {
+		if err := f.Quorum.CheckAnnotations(old.Annotations, csr.Annotations, req.UserInfo.Username); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+	if f.ApprovalRules != nil {
+		if condition, ok := addedApproval(old, csr); ok {
+			if err := f.ApprovalRules.CheckApproval(condition, req.UserInfo); err != nil {
+				return admission.Denied(err.Error())
+			}
+		}
+	}
+	return admission.Allowed("")
+}
+
+// addedApproval returns the Approved condition of the CSR, if it is being
+// added, or its reason changed.
+func addedApproval(old, csr capi.CertificateSigningRequest) (capi.CertificateSigningRequestCondition, bool) {
+	condition, ok := approvedCondition(csr)
+	if !ok {
+		return condition, false
+	}
+	if oldCondition, ok := approvedCondition(old); ok && oldCondition.Reason == condition.Reason {
+		return condition, false
+	}
+	return condition, true
+}
+
+func approvedCondition(csr capi.CertificateSigningRequest) (capi.CertificateSigningRequestCondition, bool) {
+	for _, c := range csr.Status.Conditions {
+		if c.Type == capi.CertificateApproved {
+			return c, true
+		}
+	}
+	return capi.CertificateSigningRequestCondition{}, false
+}
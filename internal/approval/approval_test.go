@@ -0,0 +1,163 @@
+package approval_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/cert-manager/signer-venafi/internal/approval"
+	"github.com/cert-manager/signer-venafi/internal/filter"
+)
+
+const signerName = "example.com/foo"
+
+func newCSR(signerName string, annotations map[string]string, approvalReason string) *capi.CertificateSigningRequest {
+	csr := &capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "csr1", Annotations: annotations},
+		Spec: capi.CertificateSigningRequestSpec{
+			SignerName: pointer.StringPtr(signerName),
+			Username:   "carol",
+		},
+	}
+	if approvalReason != "" {
+		csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+			Type:   capi.CertificateApproved,
+			Reason: approvalReason,
+		})
+	}
+	return csr
+}
+
+func raw(t *testing.T, obj runtime.Object) runtime.RawExtension {
+	data, err := json.Marshal(obj)
+	require.NoError(t, err)
+	return runtime.RawExtension{Raw: data}
+}
+
+func TestValidator_Handle(t *testing.T) {
+	alice := filter.AnnotationKeyPrefixApprover + "alice"
+	bob := filter.AnnotationKeyPrefixApprover + "bob"
+	v := &approval.Validator{
+		Filters: map[string]*filter.CSRFilter{
+			signerName: {
+				SignerName: signerName,
+				ApprovalRules: &filter.ApprovalRules{
+					Reasons: []string{"AutoApproved", "KubectlApprove"},
+					Users:   []string{"system:kube-controller-manager"},
+					Groups:  []string{"approvers"},
+				},
+				Quorum: &filter.Quorum{Required: 2, Approvers: []string{"alice", "bob"}},
+			},
+		},
+	}
+	tests := []struct {
+		name        string
+		user        authenticationv1.UserInfo
+		subResource string
+		old         *capi.CertificateSigningRequest
+		csr         *capi.CertificateSigningRequest
+		wantAllowed bool
+	}{
+		{
+			name:        "AllowedOtherSignerName",
+			user:        authenticationv1.UserInfo{Username: "mallory"},
+			old:         newCSR("example.com/other", nil, ""),
+			csr:         newCSR("example.com/other", map[string]string{alice: "approved"}, "AutoApproved"),
+			wantAllowed: true,
+		},
+		{
+			name:        "AllowedOwnApproverAnnotation",
+			user:        authenticationv1.UserInfo{Username: "alice"},
+			old:         newCSR(signerName, map[string]string{bob: "approved"}, ""),
+			csr:         newCSR(signerName, map[string]string{alice: "approved", bob: "approved"}, ""),
+			wantAllowed: true,
+		},
+		{
+			name:        "DeniedForgedApproverAnnotation",
+			user:        authenticationv1.UserInfo{Username: "alice"},
+			old:         newCSR(signerName, map[string]string{alice: "approved"}, ""),
+			csr:         newCSR(signerName, map[string]string{alice: "approved", bob: "approved"}, ""),
+			wantAllowed: false,
+		},
+		{
+			name:        "DeniedForgedApproverAnnotationOnCreate",
+			user:        authenticationv1.UserInfo{Username: "carol"},
+			csr:         newCSR(signerName, map[string]string{alice: "approved"}, ""),
+			wantAllowed: false,
+		},
+		{
+			name:        "AllowedApprovalByUser",
+			user:        authenticationv1.UserInfo{Username: "system:kube-controller-manager"},
+			subResource: "approval",
+			old:         newCSR(signerName, nil, ""),
+			csr:         newCSR(signerName, nil, "AutoApproved"),
+			wantAllowed: true,
+		},
+		{
+			name:        "AllowedApprovalByGroup",
+			user:        authenticationv1.UserInfo{Username: "dave", Groups: []string{"system:authenticated", "approvers"}},
+			subResource: "approval",
+			old:         newCSR(signerName, nil, ""),
+			csr:         newCSR(signerName, nil, "KubectlApprove"),
+			wantAllowed: true,
+		},
+		{
+			name:        "DeniedApprovalByOtherUser",
+			user:        authenticationv1.UserInfo{Username: "mallory", Groups: []string{"system:authenticated"}},
+			subResource: "approval",
+			old:         newCSR(signerName, nil, ""),
+			csr:         newCSR(signerName, nil, "KubectlApprove"),
+			wantAllowed: false,
+		},
+		{
+			name:        "DeniedApprovalReason",
+			user:        authenticationv1.UserInfo{Username: "system:kube-controller-manager"},
+			subResource: "approval",
+			old:         newCSR(signerName, nil, ""),
+			csr:         newCSR(signerName, nil, "Other"),
+			wantAllowed: false,
+		},
+		{
+			name:        "DeniedApprovalReasonChangedByOtherUser",
+			user:        authenticationv1.UserInfo{Username: "mallory"},
+			subResource: "approval",
+			old:         newCSR(signerName, nil, "Other"),
+			csr:         newCSR(signerName, nil, "AutoApproved"),
+			wantAllowed: false,
+		},
+		{
+			name:        "AllowedExistingApprovalUnchanged",
+			user:        authenticationv1.UserInfo{Username: "system:serviceaccount:signer-venafi-system:default"},
+			subResource: "status",
+			old:         newCSR(signerName, nil, "AutoApproved"),
+			csr:         newCSR(signerName, nil, "AutoApproved"),
+			wantAllowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+				Operation:   admissionv1beta1.Create,
+				SubResource: tt.subResource,
+				UserInfo:    tt.user,
+				Object:      raw(t, tt.csr),
+			}}
+			if tt.old != nil {
+				req.Operation = admissionv1beta1.Update
+				req.OldObject = raw(t, tt.old)
+			}
+			resp := v.Handle(context.Background(), req)
+			assert.Equal(t, tt.wantAllowed, resp.Allowed, "%v", resp.Result)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	capi "k8s.io/api/certificates/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/cert-manager/signer-venafi/internal/filter"
+)
+
+// CertificateRequestPath is the path at which the CertificateRequest webhook
+// is served.
+const CertificateRequestPath = "/validate-cert-manager-io-certificaterequest"
+
+// The Approved condition which cert-manager v1.3 and later add to
+// CertificateRequests. It is not in the API types of the version of
+// cert-manager used by this signer.
+const certificateRequestConditionApproved cmapi.CertificateRequestConditionType = "Approved"
+
+// +kubebuilder:webhook:path=/validate-cert-manager-io-certificaterequest,mutating=false,failurePolicy=fail,groups=cert-manager.io,resources=certificaterequests;certificaterequests/status,verbs=create;update,versions=v1alpha2;v1alpha3;v1beta1;v1,name=certificaterequest-approval.signer-venafi.cert-manager.io
+
+// CertificateRequestValidator refuses Approved conditions, on the
+// CertificateRequests whose issuer reference has the IssuerGroup, which are
+// added by users who are not allowed to approve by the ApprovalRules, or with
+// a reason which is not accepted.
+// CertificateRequests are signed as CSRs, but the CSR webhook never sees
+// them, so their approvals are checked here instead.
+type CertificateRequestValidator struct {
+	IssuerGroup   string
+	ApprovalRules *filter.ApprovalRules
+}
+
+var _ admission.Handler = &CertificateRequestValidator{}
+
+func (o *CertificateRequestValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var cr, old cmapi.CertificateRequest
+	if err := json.Unmarshal(req.Object.Raw, &cr); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if req.Operation == admissionv1beta1.Update {
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	if cr.Spec.IssuerRef.Group != o.IssuerGroup {
+		return admission.Allowed("")
+	}
+
+	if condition, ok := addedCertificateRequestApproval(old, cr); ok {
+		if err := o.ApprovalRules.CheckApproval(condition, req.UserInfo); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+	return admission.Allowed("")
+}
+
+// addedCertificateRequestApproval returns the Approved condition of the
+// CertificateRequest as a CSR condition, if it is being added, or its reason
+// changed.
+func addedCertificateRequestApproval(old, cr cmapi.CertificateRequest) (capi.CertificateSigningRequestCondition, bool) {
+	condition, ok := certificateRequestApproval(cr)
+	if !ok {
+		return condition, false
+	}
+	if oldCondition, ok := certificateRequestApproval(old); ok && oldCondition.Reason == condition.Reason {
+		return condition, false
+	}
+	return condition, true
+}
+
+func certificateRequestApproval(cr cmapi.CertificateRequest) (capi.CertificateSigningRequestCondition, bool) {
+	for _, c := range cr.Status.Conditions {
+		if c.Type == certificateRequestConditionApproved && c.Status == cmmeta.ConditionTrue {
+			return capi.CertificateSigningRequestCondition{
+				Type:    capi.CertificateApproved,
+				Reason:  c.Reason,
+				Message: c.Message,
+			}, true
+		}
+	}
+	return capi.CertificateSigningRequestCondition{}, false
+}
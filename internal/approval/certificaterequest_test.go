@@ -0,0 +1,110 @@
+package approval_test
+
+import (
+	"context"
+	"testing"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	"github.com/stretchr/testify/assert"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/cert-manager/signer-venafi/internal/approval"
+	"github.com/cert-manager/signer-venafi/internal/filter"
+)
+
+const issuerGroup = "signer-venafi.cert-manager.io"
+
+func newCertificateRequest(group string, approvalReason string) *cmapi.CertificateRequest {
+	cr := &cmapi.CertificateRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "cr1"},
+		Spec: cmapi.CertificateRequestSpec{
+			IssuerRef: cmmeta.ObjectReference{Group: group, Kind: "Issuer", Name: "foo"},
+		},
+	}
+	if approvalReason != "" {
+		cr.Status.Conditions = append(cr.Status.Conditions, cmapi.CertificateRequestCondition{
+			Type:   "Approved",
+			Status: cmmeta.ConditionTrue,
+			Reason: approvalReason,
+		})
+	}
+	return cr
+}
+
+func TestCertificateRequestValidator_Handle(t *testing.T) {
+	v := &approval.CertificateRequestValidator{
+		IssuerGroup: issuerGroup,
+		ApprovalRules: &filter.ApprovalRules{
+			Reasons: []string{"cert-manager.io"},
+			Users:   []string{"system:serviceaccount:cert-manager:cert-manager"},
+		},
+	}
+	tests := []struct {
+		name        string
+		user        authenticationv1.UserInfo
+		old         *cmapi.CertificateRequest
+		cr          *cmapi.CertificateRequest
+		wantAllowed bool
+	}{
+		{
+			name:        "AllowedOtherIssuerGroup",
+			user:        authenticationv1.UserInfo{Username: "mallory"},
+			old:         newCertificateRequest("example.com", ""),
+			cr:          newCertificateRequest("example.com", "cert-manager.io"),
+			wantAllowed: true,
+		},
+		{
+			name:        "AllowedApprovalByUser",
+			user:        authenticationv1.UserInfo{Username: "system:serviceaccount:cert-manager:cert-manager"},
+			old:         newCertificateRequest(issuerGroup, ""),
+			cr:          newCertificateRequest(issuerGroup, "cert-manager.io"),
+			wantAllowed: true,
+		},
+		{
+			name:        "DeniedApprovalByOtherUser",
+			user:        authenticationv1.UserInfo{Username: "mallory"},
+			old:         newCertificateRequest(issuerGroup, ""),
+			cr:          newCertificateRequest(issuerGroup, "cert-manager.io"),
+			wantAllowed: false,
+		},
+		{
+			name:        "DeniedApprovedOnCreate",
+			user:        authenticationv1.UserInfo{Username: "mallory"},
+			cr:          newCertificateRequest(issuerGroup, "cert-manager.io"),
+			wantAllowed: false,
+		},
+		{
+			name:        "DeniedApprovalReason",
+			user:        authenticationv1.UserInfo{Username: "system:serviceaccount:cert-manager:cert-manager"},
+			old:         newCertificateRequest(issuerGroup, ""),
+			cr:          newCertificateRequest(issuerGroup, "Other"),
+			wantAllowed: false,
+		},
+		{
+			name:        "AllowedExistingApprovalUnchanged",
+			user:        authenticationv1.UserInfo{Username: "system:serviceaccount:signer-venafi-system:default"},
+			old:         newCertificateRequest(issuerGroup, "cert-manager.io"),
+			cr:          newCertificateRequest(issuerGroup, "cert-manager.io"),
+			wantAllowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+				Operation: admissionv1beta1.Create,
+				UserInfo:  tt.user,
+				Object:    raw(t, tt.cr),
+			}}
+			if tt.old != nil {
+				req.Operation = admissionv1beta1.Update
+				req.OldObject = raw(t, tt.old)
+			}
+			resp := v.Handle(context.Background(), req)
+			assert.Equal(t, tt.wantAllowed, resp.Allowed, "%v", resp.Result)
+		})
+	}
+}
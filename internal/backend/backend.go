@@ -0,0 +1,303 @@
+// Package backend is a registry of the signer backends, each of which is
+// configured by a typed config block, and loads the configuration file which
+// selects the backend of each signer name.
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cert-manager/signer-venafi/internal/chain"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/trust"
+)
+
+// Config is the config block of a backend.
+type Config interface {
+	// Validate returns every problem with the config block, without
+	// reading any files or contacting the CA.
+	Validate() []error
+}
+
+// Options are the dependencies of the signers of every backend.
+type Options struct {
+	// SignerName is the signer name of the signer, which labels its metrics.
+	SignerName string
+	// Instance, if set, names the signer among those of the signer name,
+	// such as an instance of a composite signer, and labels its metrics.
+	Instance string
+	Log      logr.Logger
+	// Reader reads Kubernetes resources, such as the CA Secret of the
+	// local-ca backend.
+	Reader client.Reader
+	// Chain, if set, is updated with the CA chain returned with each
+	// certificate.
+	Chain *trust.Chain
+	// Assembler, if set, assembles the certificate chain returned by Pickup.
+	Assembler *chain.Assembler
+}
+
+// Backend is a kind of signer backend.
+type Backend struct {
+	// NewConfig returns a config block holding the defaults of the backend,
+	// into which the configuration of each signer is decoded.
+	NewConfig func() Config
+	// New returns a signer for a valid config block.
+	New func(config Config, opts Options) (signer.Signer, error)
+}
+
+// Registry holds the backends by name.
+type Registry map[string]Backend
+
+// Register adds a backend to the registry. It panics if the name is already
+// registered.
+func (r Registry) Register(name string, b Backend) {
+	if _, ok := r[name]; ok {
+		panic(fmt.Sprintf("backend %q is already registered", name))
+	}
+	r[name] = b
+}
+
+// Names returns the sorted names of the registered backends.
+func (r Registry) Names() []string {
+	var names []string
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SignerConfig selects the backend of a signer name.
+type SignerConfig struct {
+	SignerName string
+	Backend    string
+	Config     Config
+	// Shadow, if set, selects a shadow backend which is also sent each CSR
+	// of the signer name, for comparison.
+	Shadow *ShadowConfig
+	// Settings are the settings of the signer name, which do not depend on
+	// its backend.
+	Settings Settings
+}
+
+// ShadowConfig selects the shadow backend of a signer name.
+type ShadowConfig struct {
+	Backend string
+	Config  Config
+	// Timeout is how long to wait for each shadow certificate, or zero
+	// for the default.
+	Timeout time.Duration
+	// ValidityTolerance is the difference in validity periods which is not
+	// reported, or zero for the default.
+	ValidityTolerance time.Duration
+}
+
+// file is the format of the backend configuration file.
+type file struct {
+	Signers []struct {
+		SignerName string          `json:"signerName"`
+		Backend    string          `json:"backend"`
+		Config     json.RawMessage `json:"config"`
+		Shadow     *struct {
+			Backend           string          `json:"backend"`
+			Config            json.RawMessage `json:"config"`
+			Timeout           metav1.Duration `json:"timeout"`
+			ValidityTolerance metav1.Duration `json:"validityTolerance"`
+		} `json:"shadow"`
+		Policy         json.RawMessage  `json:"policy"`
+		Approval       json.RawMessage  `json:"approval"`
+		MaxCSRAge      *metav1.Duration `json:"maxCSRAge"`
+		MaxApprovalAge *metav1.Duration `json:"maxApprovalAge"`
+		Chain          json.RawMessage  `json:"chain"`
+	} `json:"signers"`
+}
+
+// LoadFile reads the backend configuration file.
+// See Load.
+func (r Registry) LoadFile(path string, defaults Settings) ([]SignerConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backend configuration: %v", err)
+	}
+	return r.Load(data, defaults)
+}
+
+// Load decodes the YAML backend configuration, which lists the backend and
+// config block of each signer name:
+//
+//	signers:
+//	- signerName: example.com/foo
+//	  backend: venafi-tpp
+//	  config:
+//	    url: https://tpp.example.com/vedsdk
+//	    zone: Kubernetes\Foo
+//	  shadow:
+//	    backend: venafi-cloud
+//	    config:
+//	      zone: Foo\Default
+//	  policy:
+//	    allowedPrivilegedIdentities:
+//	    - system:kube-scheduler
+//
+// The optional shadow block selects a second backend which is sent each CSR,
+// and whose certificates are compared with those of the first and discarded.
+// The optional settings, policy, approval, maxCSRAge, maxApprovalAge and
+// chain, are decoded over the defaults, so that each field which is not set keeps the
+// default.
+//
+// The config block of each signer is decoded into the config of its backend,
+// and unknown fields are rejected. The signers are returned together with an
+// aggregate of every decoding error, so that they can be reported at once.
+// Load does not validate the config blocks; see Validate.
+func (r Registry) Load(data []byte, defaults Settings) ([]SignerConfig, error) {
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing backend configuration: %v", err)
+	}
+	var f file
+	if err := decodeStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("error decoding backend configuration: %v", err)
+	}
+
+	var (
+		signers []SignerConfig
+		errs    []error
+	)
+	for i, s := range f.Signers {
+		sc := SignerConfig{SignerName: s.SignerName, Backend: s.Backend}
+		sc.Config, err = r.decodeConfig(s.Backend, s.Config)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("signers[%d]: error decoding config: %v", i, err))
+		}
+		if err := decodeSettingsBlock(defaults.Policy, s.Policy, &sc.Settings.Policy); err != nil {
+			errs = append(errs, fmt.Errorf("signers[%d]: error decoding policy: %v", i, err))
+		}
+		if err := decodeSettingsBlock(defaults.Approval, s.Approval, &sc.Settings.Approval); err != nil {
+			errs = append(errs, fmt.Errorf("signers[%d]: error decoding approval: %v", i, err))
+		}
+		sc.Settings.MaxCSRAge = defaults.MaxCSRAge
+		if s.MaxCSRAge != nil {
+			sc.Settings.MaxCSRAge = *s.MaxCSRAge
+		}
+		sc.Settings.MaxApprovalAge = defaults.MaxApprovalAge
+		if s.MaxApprovalAge != nil {
+			sc.Settings.MaxApprovalAge = *s.MaxApprovalAge
+		}
+		if err := decodeSettingsBlock(defaults.Chain, s.Chain, &sc.Settings.Chain); err != nil {
+			errs = append(errs, fmt.Errorf("signers[%d]: error decoding chain: %v", i, err))
+		}
+		if s.Shadow != nil {
+			sc.Shadow = &ShadowConfig{
+				Backend:           s.Shadow.Backend,
+				Timeout:           s.Shadow.Timeout.Duration,
+				ValidityTolerance: s.Shadow.ValidityTolerance.Duration,
+			}
+			sc.Shadow.Config, err = r.decodeConfig(s.Shadow.Backend, s.Shadow.Config)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("signers[%d]: shadow: error decoding config: %v", i, err))
+			}
+		}
+		signers = append(signers, sc)
+	}
+	return signers, utilerrors.NewAggregate(errs)
+}
+
+// decodeConfig returns the config of a backend, decoded from its config block
+// over the defaults, or nil if the backend is not registered.
+func (r Registry) decodeConfig(name string, data json.RawMessage) (Config, error) {
+	b, ok := r[name]
+	if !ok {
+		return nil, nil
+	}
+	config := b.NewConfig()
+	if len(data) > 0 {
+		if err := decodeStrict(data, config); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// Validate returns an aggregate of every problem with the signers: missing
+// or duplicate signer names, unknown backends, and invalid config blocks and
+// settings.
+func (r Registry) Validate(signers []SignerConfig) error {
+	var errs []error
+	seen := map[string]bool{}
+	for i, s := range signers {
+		prefix := fmt.Sprintf("signer %q", s.SignerName)
+		if s.SignerName == "" {
+			prefix = fmt.Sprintf("signers[%d]", i)
+			errs = append(errs, fmt.Errorf("%s: signerName is required", prefix))
+		} else if seen[s.SignerName] {
+			errs = append(errs, fmt.Errorf("%s: signer name is configured more than once", prefix))
+		}
+		seen[s.SignerName] = true
+
+		errs = append(errs, r.validateConfig(prefix, s.Backend, s.Config)...)
+		for _, err := range s.Settings.Validate() {
+			errs = append(errs, fmt.Errorf("%s: %v", prefix, err))
+		}
+		if s.Shadow != nil {
+			shadowPrefix := prefix + ": shadow"
+			errs = append(errs, r.validateConfig(shadowPrefix, s.Shadow.Backend, s.Shadow.Config)...)
+			if s.Shadow.Timeout < 0 {
+				errs = append(errs, fmt.Errorf("%s: timeout must not be negative", shadowPrefix))
+			}
+			if s.Shadow.ValidityTolerance < 0 {
+				errs = append(errs, fmt.Errorf("%s: validityTolerance must not be negative", shadowPrefix))
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (r Registry) validateConfig(prefix, name string, config Config) []error {
+	if _, ok := r[name]; !ok {
+		return []error{fmt.Errorf("%s: unknown backend %q, must be one of %v", prefix, name, r.Names())}
+	}
+	if config == nil {
+		return []error{fmt.Errorf("%s: %s: config is required", prefix, name)}
+	}
+	var errs []error
+	for _, err := range config.Validate() {
+		errs = append(errs, fmt.Errorf("%s: %s: %v", prefix, name, err))
+	}
+	return errs
+}
+
+// New returns the signer of a valid SignerConfig.
+func (r Registry) New(s SignerConfig, opts Options) (signer.Signer, error) {
+	b, ok := r[s.Backend]
+	if !ok {
+		return nil, fmt.Errorf("signer %q: unknown backend %q", s.SignerName, s.Backend)
+	}
+	sig, err := b.New(s.Config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("signer %q: %s: %v", s.SignerName, s.Backend, err)
+	}
+	return sig, nil
+}
+
+// NewShadow returns the shadow signer of a valid SignerConfig which has a
+// Shadow.
+func (r Registry) NewShadow(s SignerConfig, opts Options) (signer.Signer, error) {
+	return r.New(SignerConfig{SignerName: s.SignerName, Backend: s.Shadow.Backend, Config: s.Shadow.Config}, opts)
+}
+
+func decodeStrict(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	return d.Decode(v)
+}
@@ -0,0 +1,487 @@
+package backend_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cert-manager/signer-venafi/internal/backend"
+	"github.com/cert-manager/signer-venafi/internal/chain"
+	"github.com/cert-manager/signer-venafi/internal/policy"
+	"github.com/cert-manager/signer-venafi/internal/signer/composite"
+	"github.com/cert-manager/signer-venafi/internal/signer/fake"
+	"github.com/cert-manager/signer-venafi/internal/signer/vault"
+	"github.com/cert-manager/signer-venafi/internal/signer/venafi"
+)
+
+// defaultSettings are the settings of the signers of the tests, which are
+// otherwise set by the flags.
+var defaultSettings = backend.Settings{
+	Policy: backend.PolicyConfig{
+		DeniedOrganizations: policy.DefaultDeniedOrganizations,
+		DeniedCommonNames:   policy.DefaultDeniedCommonNames,
+		MinRSAKeySize:       policy.DefaultMinRSAKeySize,
+	},
+	MaxCSRAge: metav1.Duration{Duration: 24 * time.Hour},
+	Chain:     backend.ChainConfig{Mode: chain.ModeLeaf},
+}
+
+func TestRegistry_Load(t *testing.T) {
+	r := backend.NewRegistry()
+	signers, err := r.Load([]byte(`
+signers:
+- signerName: example.com/tpp
+  backend: venafi-tpp
+  config:
+    url: https://tpp.example.com/vedsdk
+    zone: Kubernetes
+    username: signer
+    passwordFile: /etc/signer-venafi/tpp-password
+- signerName: example.com/vault
+  backend: vault
+  config:
+    address: https://vault.example.com:8200
+    role: kubernetes
+    kubernetesRole: signer-venafi
+- signerName: example.com/plugin
+  backend: plugin
+  config:
+    socket: /var/run/plugin.sock
+    timeout: 10s
+`), defaultSettings)
+	require.NoError(t, err)
+	require.NoError(t, r.Validate(signers))
+	require.Len(t, signers, 3)
+
+	assert.Equal(t, "example.com/tpp", signers[0].SignerName)
+	assert.Equal(t, backend.VenafiTPP, signers[0].Backend)
+	assert.Equal(t, &backend.TPPConfig{
+		URL:            "https://tpp.example.com/vedsdk",
+		Zone:           "Kubernetes",
+		Username:       "signer",
+		PasswordFile:   "/etc/signer-venafi/tpp-password",
+		CircuitBreaker: backend.DefaultCircuitBreakerConfig(),
+	}, signers[0].Config)
+
+	vaultConfig := signers[1].Config.(*backend.VaultConfig)
+	assert.Equal(t, "pki", vaultConfig.PKIPath, "defaults should be kept")
+	assert.Equal(t, backend.VaultAuthKubernetes, vaultConfig.Auth, "defaults should be kept")
+
+	assert.Equal(t, 10*time.Second, signers[2].Config.(*backend.PluginConfig).Timeout.Duration)
+}
+
+func TestRegistry_Errors(t *testing.T) {
+	r := backend.NewRegistry()
+	signers, err := r.Load([]byte(`
+signers:
+- signerName: example.com/tpp
+  backend: venafi-tpp
+  config:
+    url: https://tpp.example.com/vedsdk
+- signerName: example.com/tpp
+  backend: local-ca
+  config:
+    secret: no-namespace
+- signerName: example.com/unknown
+  backend: acme
+- backend: fake
+  config:
+    certificateFile: /tmp/cert.pem
+- signerName: example.com/typo
+  backend: vault
+  config:
+    adress: https://vault.example.com:8200
+`), defaultSettings)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `signers[4]: error decoding config: json: unknown field "adress"`)
+
+	err = r.Validate(signers)
+	require.Error(t, err)
+	for _, want := range []string{
+		`signer "example.com/tpp": venafi-tpp: zone is required`,
+		`signer "example.com/tpp": venafi-tpp: username is required`,
+		`signer "example.com/tpp": venafi-tpp: passwordFile is required`,
+		`signer "example.com/tpp": signer name is configured more than once`,
+		`signer "example.com/tpp": local-ca: secret must be in the form <namespace>/<name>`,
+		`signer "example.com/unknown": unknown backend "acme"`,
+		`signers[3]: signerName is required`,
+		`signer "example.com/typo": vault: address is required`,
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestRegistry_LoadInvalid(t *testing.T) {
+	r := backend.NewRegistry()
+	_, err := r.Load([]byte("signers: {"), defaultSettings)
+	assert.Error(t, err)
+	_, err = r.Load([]byte("signer: []"), defaultSettings)
+	assert.Error(t, err)
+}
+
+func TestRegistry_ValidateMissingConfig(t *testing.T) {
+	r := backend.NewRegistry()
+	err := r.Validate([]backend.SignerConfig{
+		{SignerName: "example.com/foo", Backend: backend.VenafiCloud},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `signer "example.com/foo": venafi-cloud: config is required`)
+}
+
+func TestRegistry_New(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backend")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certificateFile := filepath.Join(dir, "tls.crt")
+	require.NoError(t, ioutil.WriteFile(certificateFile, []byte("certificate"), 0600))
+	apiKeyFile := filepath.Join(dir, "api-key")
+	require.NoError(t, ioutil.WriteFile(apiKeyFile, []byte("key\n"), 0600))
+
+	r := backend.NewRegistry()
+	opts := backend.Options{Log: zapr.NewLogger(zaptest.NewLogger(t))}
+
+	s, err := r.New(backend.SignerConfig{
+		SignerName: "example.com/fake",
+		Backend:    backend.Fake,
+		Config:     &backend.FakeConfig{CertificateFile: certificateFile},
+	}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("certificate"), s.(*fake.Signer).Certificate)
+
+	s, err = r.New(backend.SignerConfig{
+		SignerName: "example.com/cloud",
+		Backend:    backend.VenafiCloud,
+		Config:     &backend.CloudConfig{Zone: "zone", APIKeyFile: apiKeyFile},
+	}, opts)
+	require.NoError(t, err)
+	assert.IsType(t, &venafi.Signer{}, s)
+
+	s, err = r.New(backend.SignerConfig{
+		SignerName: "example.com/vault",
+		Backend:    backend.Vault,
+		Config: &backend.VaultConfig{
+			Address:             "https://vault:8200",
+			PKIPath:             "pki",
+			Role:                "web",
+			Auth:                backend.VaultAuthKubernetes,
+			KubernetesRole:      "signer",
+			KubernetesTokenFile: filepath.Join(dir, "token"),
+		},
+	}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, vault.DefaultTimeout, s.(*vault.Signer).HTTPClient.Timeout,
+		"requests to Vault should time out")
+
+	_, err = r.New(backend.SignerConfig{
+		SignerName: "example.com/tpp",
+		Backend:    backend.VenafiTPP,
+		Config: &backend.TPPConfig{
+			URL:          "https://tpp.example.com/vedsdk",
+			Zone:         "zone",
+			Username:     "user",
+			PasswordFile: filepath.Join(dir, "missing"),
+		},
+	}, opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `signer "example.com/tpp": venafi-tpp: error reading passwordFile`)
+}
+
+func TestRegistry_Composite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backend")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certificateFile := filepath.Join(dir, "tls.crt")
+	require.NoError(t, ioutil.WriteFile(certificateFile, []byte("certificate"), 0600))
+
+	r := backend.NewRegistry()
+	signers, err := r.Load([]byte(`
+signers:
+- signerName: example.com/foo
+  backend: composite
+  config:
+    mode: round-robin
+    instances:
+    - name: dc1
+      weight: 2
+      backend: fake
+      config:
+        certificateFile: `+certificateFile+`
+    - name: dc2
+      backend: fake
+      config:
+        certificateFile: `+certificateFile+`
+`), defaultSettings)
+	require.NoError(t, err)
+	require.NoError(t, r.Validate(signers))
+
+	c := signers[0].Config.(*backend.CompositeConfig)
+	assert.Equal(t, composite.ModeRoundRobin, c.Mode)
+	assert.Equal(t, time.Minute, c.Cooldown.Duration, "defaults should be kept")
+	require.Len(t, c.Instances, 2)
+	assert.Equal(t, &backend.FakeConfig{CertificateFile: certificateFile}, c.Instances[1].Config)
+
+	s, err := r.New(signers[0], backend.Options{
+		SignerName: "example.com/foo",
+		Log:        zapr.NewLogger(zaptest.NewLogger(t)),
+	})
+	require.NoError(t, err)
+	require.IsType(t, &composite.RevokingSigner{}, s, "the fake instances can revoke")
+	cs := s.(*composite.RevokingSigner)
+	require.Len(t, cs.Instances, 2)
+	assert.Equal(t, "dc1", cs.Instances[0].Name)
+	assert.Equal(t, 2, cs.Instances[0].Weight)
+	assert.IsType(t, &fake.Signer{}, cs.Instances[0].Signer)
+}
+
+func TestRegistry_CompositeErrors(t *testing.T) {
+	r := backend.NewRegistry()
+	_, err := r.Load([]byte(`
+signers:
+- signerName: example.com/foo
+  backend: composite
+  config:
+    instances:
+    - name: dc1
+      backend: fake
+      config:
+        certificate: /tmp/cert.pem
+`), defaultSettings)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `instances[0]: json: unknown field "certificate"`)
+
+	signers, err := r.Load([]byte(`
+signers:
+- signerName: example.com/foo
+  backend: composite
+  config:
+    mode: random
+    instances:
+    - name: dc1
+      backend: venafi-cloud
+      config:
+        zone: zone
+    - name: dc2
+      backend: composite
+    - name: dc3
+      backend: acme
+`), defaultSettings)
+	require.NoError(t, err)
+	err = r.Validate(signers)
+	require.Error(t, err)
+	for _, want := range []string{
+		`signer "example.com/foo": composite: mode must be one of [failover round-robin]`,
+		`signer "example.com/foo": composite: instances[0]: venafi-cloud: apiKeyFile is required`,
+		`signer "example.com/foo": composite: instances[1]: instances can not use the composite backend`,
+		`signer "example.com/foo": composite: instances[2]: unknown backend "acme"`,
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestRegistry_Shadow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backend")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certificateFile := filepath.Join(dir, "tls.crt")
+	require.NoError(t, ioutil.WriteFile(certificateFile, []byte("certificate"), 0600))
+
+	r := backend.NewRegistry()
+	signers, err := r.Load([]byte(`
+signers:
+- signerName: example.com/foo
+  backend: fake
+  config:
+    certificateFile: `+certificateFile+`
+  shadow:
+    backend: fake
+    timeout: 1m
+    config:
+      certificateFile: `+certificateFile+`
+`), defaultSettings)
+	require.NoError(t, err)
+	require.NoError(t, r.Validate(signers))
+	require.Len(t, signers, 1)
+	require.NotNil(t, signers[0].Shadow)
+	assert.Equal(t, backend.Fake, signers[0].Shadow.Backend)
+	assert.Equal(t, time.Minute, signers[0].Shadow.Timeout)
+	assert.Equal(t, &backend.FakeConfig{CertificateFile: certificateFile}, signers[0].Shadow.Config)
+
+	s, err := r.NewShadow(signers[0], backend.Options{Log: zapr.NewLogger(zaptest.NewLogger(t))})
+	require.NoError(t, err)
+	assert.IsType(t, &fake.Signer{}, s)
+}
+
+func TestRegistry_ShadowErrors(t *testing.T) {
+	r := backend.NewRegistry()
+	signers, err := r.Load([]byte(`
+signers:
+- signerName: example.com/foo
+  backend: venafi-cloud
+  config:
+    zone: zone
+    apiKeyFile: /etc/signer-venafi/api-key
+  shadow:
+    backend: vault
+    config:
+      adress: https://vault.example.com:8200
+- signerName: example.com/bar
+  backend: venafi-cloud
+  config:
+    zone: zone
+    apiKeyFile: /etc/signer-venafi/api-key
+  shadow:
+    backend: acme
+    validityTolerance: -1m
+`), defaultSettings)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `signers[0]: shadow: error decoding config: json: unknown field "adress"`)
+
+	err = r.Validate(signers)
+	require.Error(t, err)
+	for _, want := range []string{
+		`signer "example.com/foo": shadow: vault: address is required`,
+		`signer "example.com/bar": shadow: unknown backend "acme"`,
+		`signer "example.com/bar": shadow: validityTolerance must not be negative`,
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestRegistry_CircuitBreaker(t *testing.T) {
+	r := backend.NewRegistry()
+	signers, err := r.Load([]byte(`
+signers:
+- signerName: example.com/cloud
+  backend: venafi-cloud
+  config:
+    zone: zone
+    apiKeyFile: /etc/signer-venafi/api-key
+    circuitBreaker:
+      openDuration: 1m
+- signerName: example.com/disabled
+  backend: venafi
+  config:
+    configFile: /etc/signer-venafi/vcert.ini
+    circuitBreaker:
+      failureThreshold: 0
+- signerName: example.com/invalid
+  backend: venafi-cloud
+  config:
+    zone: zone
+    apiKeyFile: /etc/signer-venafi/api-key
+    circuitBreaker:
+      failureThreshold: -1
+      openDuration: 0s
+`), defaultSettings)
+	require.NoError(t, err)
+	require.Len(t, signers, 3)
+	assert.Equal(t, backend.CircuitBreakerConfig{
+		FailureThreshold: 5,
+		OpenDuration:     metav1.Duration{Duration: time.Minute},
+	}, signers[0].Config.(*backend.CloudConfig).CircuitBreaker, "defaults should be kept")
+	assert.Equal(t, 0, signers[1].Config.(*backend.VcertConfig).CircuitBreaker.FailureThreshold)
+
+	err = r.Validate(signers)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `signer "example.com/invalid": venafi-cloud: circuitBreaker: failureThreshold must not be negative`)
+	assert.NotContains(t, err.Error(), "example.com/cloud")
+	assert.NotContains(t, err.Error(), "example.com/disabled")
+}
+
+func TestRegistry_Settings(t *testing.T) {
+	r := backend.NewRegistry()
+	signers, err := r.Load([]byte(`
+signers:
+- signerName: example.com/default
+  backend: local-ca
+  config:
+    secret: ns/ca
+- signerName: example.com/scheduler
+  backend: local-ca
+  config:
+    secret: ns/ca
+  policy:
+    deniedCommonNames:
+    - system:kube-proxy
+    allowedPrivilegedIdentities:
+    - system:kube-scheduler
+    minRSAKeySize: 3072
+  approval:
+    reasons:
+    - AutoApproved
+    requiredApprovers: 1
+    approvers:
+    - alice
+  maxCSRAge: 1h
+  maxApprovalAge: 10m
+  chain:
+    mode: full
+    trustAnchorsFile: /etc/signer-venafi/roots.pem
+- signerName: example.com/invalid
+  backend: local-ca
+  config:
+    secret: ns/ca
+  policy:
+    deniedOrganizations: []
+    minRSAKeySize: -1
+  approval:
+    requiredApprovers: 2
+    approvers:
+    - alice
+  maxApprovalAge: -1m
+  chain:
+    mode: intermediates
+`), defaultSettings)
+	require.NoError(t, err)
+	require.Len(t, signers, 3)
+
+	assert.Equal(t, defaultSettings, signers[0].Settings)
+	assert.Equal(t, backend.PolicyConfig{
+		DeniedOrganizations:         policy.DefaultDeniedOrganizations,
+		DeniedCommonNames:           []string{"system:kube-proxy"},
+		AllowedPrivilegedIdentities: []string{"system:kube-scheduler"},
+		MinRSAKeySize:               3072,
+	}, signers[1].Settings.Policy, "defaults should be kept")
+	assert.Equal(t, backend.ApprovalConfig{
+		Reasons:           []string{"AutoApproved"},
+		RequiredApprovers: 1,
+		Approvers:         []string{"alice"},
+	}, signers[1].Settings.Approval)
+	assert.Equal(t, time.Hour, signers[1].Settings.MaxCSRAge.Duration)
+	assert.Equal(t, 10*time.Minute, signers[1].Settings.MaxApprovalAge.Duration)
+	assert.Equal(t, 24*time.Hour, signers[2].Settings.MaxCSRAge.Duration, "defaults should be kept")
+	assert.Equal(t, backend.ChainConfig{
+		Mode:             chain.ModeFull,
+		TrustAnchorsFile: "/etc/signer-venafi/roots.pem",
+	}, signers[1].Settings.Chain)
+	assert.Equal(t, []string{"system:kube-controller-manager", "system:kube-scheduler"}, policy.DefaultDeniedCommonNames,
+		"defaults should not be changed")
+
+	err = r.Validate(signers)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `signer "example.com/invalid": policy: deniedOrganizations must not be empty`)
+	assert.Contains(t, err.Error(), `signer "example.com/invalid": policy: minRSAKeySize must not be negative`)
+	assert.Contains(t, err.Error(), `signer "example.com/invalid": approval: requiredApprovers is greater than the number of approvers`)
+	assert.Contains(t, err.Error(), `signer "example.com/invalid": maxApprovalAge must not be negative`)
+	assert.Contains(t, err.Error(), `signer "example.com/invalid": chain: trustAnchorsFile is required with mode intermediates`)
+	assert.NotContains(t, err.Error(), "example.com/default")
+	assert.NotContains(t, err.Error(), "example.com/scheduler")
+
+	_, err = r.Load([]byte(`
+signers:
+- signerName: example.com/typo
+  backend: local-ca
+  policy:
+    deniedOrganisations: []
+`), defaultSettings)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `signers[0]: error decoding policy: json: unknown field "deniedOrganisations"`)
+}
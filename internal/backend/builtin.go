@@ -0,0 +1,51 @@
+package backend
+
+// The names of the built-in backends
+const (
+	Venafi      = "venafi"
+	VenafiTPP   = "venafi-tpp"
+	VenafiCloud = "venafi-cloud"
+	LocalCA     = "local-ca"
+	Vault       = "vault"
+	Plugin      = "plugin"
+	Fake        = "fake"
+	Composite   = "composite"
+)
+
+// NewRegistry returns a registry of the built-in backends.
+func NewRegistry() Registry {
+	r := Registry{}
+	r.Register(Venafi, Backend{
+		NewConfig: func() Config { return &VcertConfig{CircuitBreaker: DefaultCircuitBreakerConfig()} },
+		New:       newVcertSigner,
+	})
+	r.Register(VenafiTPP, Backend{
+		NewConfig: func() Config { return &TPPConfig{CircuitBreaker: DefaultCircuitBreakerConfig()} },
+		New:       newTPPSigner,
+	})
+	r.Register(VenafiCloud, Backend{
+		NewConfig: func() Config { return &CloudConfig{CircuitBreaker: DefaultCircuitBreakerConfig()} },
+		New:       newCloudSigner,
+	})
+	r.Register(LocalCA, Backend{
+		NewConfig: func() Config { return &LocalCAConfig{} },
+		New:       newLocalCASigner,
+	})
+	r.Register(Vault, Backend{
+		NewConfig: newVaultConfig,
+		New:       newVaultSigner,
+	})
+	r.Register(Plugin, Backend{
+		NewConfig: newPluginConfig,
+		New:       newPluginSigner,
+	})
+	r.Register(Fake, Backend{
+		NewConfig: func() Config { return &FakeConfig{} },
+		New:       newFakeSigner,
+	})
+	r.Register(Composite, Backend{
+		NewConfig: newCompositeConfig(r),
+		New:       newCompositeSigner,
+	})
+	return r
+}
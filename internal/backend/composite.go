@@ -0,0 +1,158 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/composite"
+)
+
+// CompositeConfig configures the composite backend, which spreads CSRs across
+// several instances of other backends, such as Venafi TPP clusters in
+// different datacenters.
+type CompositeConfig struct {
+	// Mode is failover (the default), which sends each CSR to the first
+	// healthy instance, or round-robin, which spreads the CSRs across the
+	// healthy instances in proportion to their weights.
+	Mode composite.Mode `json:"mode,omitempty"`
+	// Cooldown is the time for which an instance is skipped after it fails.
+	// Defaults to 1m.
+	Cooldown  metav1.Duration     `json:"cooldown,omitempty"`
+	Instances []CompositeInstance `json:"instances"`
+
+	// registry decodes the config blocks of the instances.
+	registry Registry
+}
+
+// CompositeInstance is an instance of a composite backend.
+type CompositeInstance struct {
+	// Name identifies the instance in pickup IDs, so it must not be changed
+	// while any CSR is waiting to be picked up.
+	Name string `json:"name"`
+	// Weight is the share of the CSRs sent to the instance in round-robin
+	// mode. Defaults to 1.
+	Weight  int    `json:"weight,omitempty"`
+	Backend string `json:"backend"`
+	// Config is the config block of the backend of the instance.
+	Config Config `json:"-"`
+}
+
+func newCompositeConfig(r Registry) func() Config {
+	return func() Config {
+		return &CompositeConfig{
+			Mode:     composite.ModeFailover,
+			Cooldown: metav1.Duration{Duration: time.Minute},
+			registry: r,
+		}
+	}
+}
+
+// UnmarshalJSON decodes the config block of each instance into the config of
+// its backend, rejecting unknown fields.
+func (c *CompositeConfig) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Mode      *composite.Mode  `json:"mode"`
+		Cooldown  *metav1.Duration `json:"cooldown"`
+		Instances []struct {
+			Name    string          `json:"name"`
+			Weight  int             `json:"weight"`
+			Backend string          `json:"backend"`
+			Config  json.RawMessage `json:"config"`
+		} `json:"instances"`
+	}
+	if err := decodeStrict(data, &raw); err != nil {
+		return err
+	}
+	if raw.Mode != nil {
+		c.Mode = *raw.Mode
+	}
+	if raw.Cooldown != nil {
+		c.Cooldown = *raw.Cooldown
+	}
+	var errs []error
+	c.Instances = nil
+	for i, ri := range raw.Instances {
+		instance := CompositeInstance{Name: ri.Name, Weight: ri.Weight, Backend: ri.Backend}
+		if b, ok := c.registry[ri.Backend]; ok && ri.Backend != Composite {
+			instance.Config = b.NewConfig()
+			if len(ri.Config) > 0 {
+				if err := decodeStrict(ri.Config, instance.Config); err != nil {
+					errs = append(errs, fmt.Errorf("instances[%d]: %v", i, err))
+				}
+			}
+		}
+		c.Instances = append(c.Instances, instance)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (c *CompositeConfig) Validate() []error {
+	errs := c.signer().Validate()
+	if c.Cooldown.Duration < 0 {
+		errs = append(errs, fmt.Errorf("cooldown must not be negative"))
+	}
+	for i, instance := range c.Instances {
+		prefix := fmt.Sprintf("instances[%d]", i)
+		switch _, ok := c.registry[instance.Backend]; {
+		case instance.Backend == Composite:
+			errs = append(errs, fmt.Errorf("%s: instances can not use the %s backend", prefix, Composite))
+			continue
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s: unknown backend %q", prefix, instance.Backend))
+			continue
+		case instance.Config == nil:
+			errs = append(errs, fmt.Errorf("%s: %s: config is required", prefix, instance.Backend))
+			continue
+		}
+		for _, err := range instance.Config.Validate() {
+			errs = append(errs, fmt.Errorf("%s: %s: %v", prefix, instance.Backend, err))
+		}
+	}
+	return errs
+}
+
+// signer returns a composite signer with the instances of the config, but
+// without their signers.
+func (c *CompositeConfig) signer() *composite.Signer {
+	s := &composite.Signer{
+		Mode:     c.Mode,
+		Cooldown: c.Cooldown.Duration,
+	}
+	for _, instance := range c.Instances {
+		s.Instances = append(s.Instances, composite.Instance{Name: instance.Name, Weight: instance.Weight})
+	}
+	return s
+}
+
+func newCompositeSigner(config Config, opts Options) (signer.Signer, error) {
+	c := config.(*CompositeConfig)
+	s := c.signer()
+	s.SignerName = opts.SignerName
+	s.Log = opts.Log
+	var errs []error
+	for i, instance := range c.Instances {
+		instanceOpts := opts
+		instanceOpts.Log = opts.Log.WithName(instance.Backend).WithValues("instance", instance.Name)
+		instanceOpts.Instance = instance.Name
+		instanceSigner, err := c.registry[instance.Backend].New(instance.Config, instanceOpts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("instances[%d]: %s: %v", i, instance.Backend, err))
+			continue
+		}
+		s.Instances[i].Signer = instanceSigner
+	}
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+	rs, err := s.Revoking()
+	if err != nil {
+		opts.Log.Info("Revocation is disabled", "reason", err.Error())
+		return s, nil
+	}
+	return rs, nil
+}
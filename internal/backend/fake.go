@@ -0,0 +1,32 @@
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/fake"
+)
+
+// FakeConfig configures the fake backend, which returns the same certificate
+// for every CSR. It is only intended for testing.
+type FakeConfig struct {
+	// CertificateFile is the PEM file containing the certificate.
+	CertificateFile string `json:"certificateFile"`
+}
+
+func (c *FakeConfig) Validate() []error {
+	if c.CertificateFile == "" {
+		return []error{fmt.Errorf("certificateFile is required")}
+	}
+	return nil
+}
+
+func newFakeSigner(config Config, opts Options) (signer.Signer, error) {
+	c := config.(*FakeConfig)
+	certificate, err := ioutil.ReadFile(c.CertificateFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificateFile: %v", err)
+	}
+	return &fake.Signer{Certificate: certificate}, nil
+}
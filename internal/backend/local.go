@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/local"
+)
+
+// LocalCAConfig configures the local-ca backend, which signs certificates
+// with a CA certificate and private key loaded from a Secret or from files.
+type LocalCAConfig struct {
+	// Secret is the <namespace>/<name> of a TLS Secret containing the CA
+	// certificate and private key.
+	Secret string `json:"secret,omitempty"`
+	// CertFile and KeyFile are the PEM files containing the CA certificate
+	// and private key, if Secret is not set.
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+func (c *LocalCAConfig) Validate() []error {
+	switch {
+	case c.Secret != "" && (c.CertFile != "" || c.KeyFile != ""):
+		return []error{fmt.Errorf("secret can not be used with certFile and keyFile")}
+	case c.Secret != "":
+		if _, err := secretKey(c.Secret); err != nil {
+			return []error{err}
+		}
+	case c.CertFile == "" || c.KeyFile == "":
+		return []error{fmt.Errorf("secret, or certFile and keyFile, are required")}
+	}
+	return nil
+}
+
+func newLocalCASigner(config Config, opts Options) (signer.Signer, error) {
+	c := config.(*LocalCAConfig)
+	var certPEM, keyPEM []byte
+	if c.Secret != "" {
+		key, err := secretKey(c.Secret)
+		if err != nil {
+			return nil, err
+		}
+		var secret corev1.Secret
+		if err := opts.Reader.Get(context.Background(), key, &secret); err != nil {
+			return nil, fmt.Errorf("error getting secret %s: %v", key, err)
+		}
+		certPEM, keyPEM = secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	} else {
+		var err error
+		if certPEM, err = ioutil.ReadFile(c.CertFile); err != nil {
+			return nil, fmt.Errorf("error reading certFile: %v", err)
+		}
+		if keyPEM, err = ioutil.ReadFile(c.KeyFile); err != nil {
+			return nil, fmt.Errorf("error reading keyFile: %v", err)
+		}
+	}
+	certs, key, err := local.ParseCA(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &local.Signer{
+		CACertificates: certs,
+		CAKey:          key,
+		Log:            opts.Log,
+		Chain:          opts.Chain,
+		Assembler:      opts.Assembler,
+	}, nil
+}
+
+func secretKey(name string) (client.ObjectKey, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return client.ObjectKey{}, fmt.Errorf("secret must be in the form <namespace>/<name>")
+	}
+	return client.ObjectKey{Namespace: parts[0], Name: parts[1]}, nil
+}
@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cert-manager/signer-venafi/internal/plugin"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// PluginConfig configures the plugin backend, which forwards each CSR to an
+// external signer plugin.
+type PluginConfig struct {
+	// Socket is the Unix socket on which the plugin serves.
+	Socket string `json:"socket"`
+	// Timeout is the deadline of each call to the plugin. Defaults to 30s.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+func newPluginConfig() Config {
+	return &PluginConfig{
+		Timeout: metav1.Duration{Duration: time.Second * 30},
+	}
+}
+
+func (c *PluginConfig) Validate() []error {
+	var errs []error
+	if c.Socket == "" {
+		errs = append(errs, fmt.Errorf("socket is required"))
+	}
+	if c.Timeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("timeout must be positive"))
+	}
+	return errs
+}
+
+func newPluginSigner(config Config, opts Options) (signer.Signer, error) {
+	c := config.(*PluginConfig)
+	conn, err := plugin.Dial(c.Socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to plugin: %v", err)
+	}
+	return &plugin.Signer{
+		Client:  plugin.NewSignerClient(conn),
+		Log:     opts.Log,
+		Timeout: c.Timeout.Duration,
+	}, nil
+}
@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cert-manager/signer-venafi/internal/chain"
+)
+
+// Settings are the settings of a signer name which do not depend on its
+// backend, such as its policy. The flags of the manager set the defaults,
+// over which the settings of each signer in the backend configuration are
+// decoded.
+type Settings struct {
+	Policy   PolicyConfig   `json:"policy"`
+	Approval ApprovalConfig `json:"approval"`
+	// MaxCSRAge and MaxApprovalAge, if not zero, are the longest time since
+	// a CSR was created, or approved, at which it is still signed.
+	MaxCSRAge      metav1.Duration `json:"maxCSRAge"`
+	MaxApprovalAge metav1.Duration `json:"maxApprovalAge"`
+	Chain          ChainConfig     `json:"chain"`
+}
+
+// PolicyConfig selects the policies which the CSRs of a signer name must
+// satisfy to be signed.
+type PolicyConfig struct {
+	// DeniedOrganizations and DeniedCommonNames are the subject identities
+	// which are never signed, unless they are listed in
+	// AllowedPrivilegedIdentities. They must not be empty.
+	DeniedOrganizations         []string `json:"deniedOrganizations"`
+	DeniedCommonNames           []string `json:"deniedCommonNames"`
+	AllowedPrivilegedIdentities []string `json:"allowedPrivilegedIdentities"`
+	// AllowedKeyAlgorithms, MinRSAKeySize, AllowedECDSACurves and
+	// AllowedSignatureAlgorithms restrict the public key and the signature
+	// of CSRs.
+	AllowedKeyAlgorithms       []string `json:"allowedKeyAlgorithms"`
+	MinRSAKeySize              int      `json:"minRSAKeySize"`
+	AllowedECDSACurves         []string `json:"allowedECDSACurves"`
+	AllowedSignatureAlgorithms []string `json:"allowedSignatureAlgorithms"`
+}
+
+// ApprovalConfig selects the approvals of the CSRs of a signer name which are
+// accepted.
+type ApprovalConfig struct {
+	// Reasons are the accepted reasons of Approved conditions, and Users and
+	// Groups those who may add them. If empty, any are accepted.
+	Reasons []string `json:"reasons"`
+	Users   []string `json:"users"`
+	Groups  []string `json:"groups"`
+	// RequiredApprovers, if not zero, is the number of distinct Approvers
+	// who must also approve each CSR.
+	RequiredApprovers int      `json:"requiredApprovers"`
+	Approvers         []string `json:"approvers"`
+}
+
+// ChainConfig selects the CA certificates which follow the certificates of a
+// signer name, and the trust anchors to which they are validated.
+type ChainConfig struct {
+	Mode      chain.Mode `json:"mode"`
+	RootFirst bool       `json:"rootFirst"`
+	// TrustAnchorsFile is a PEM file of root certificates. It is required
+	// unless the Mode is leaf.
+	TrustAnchorsFile string `json:"trustAnchorsFile"`
+}
+
+// Validate returns every problem with the settings.
+func (s Settings) Validate() []error {
+	var errs []error
+	// The deny lists can be extended or changed, but not emptied: the
+	// allowlist is the only way to opt out of them.
+	if len(s.Policy.DeniedOrganizations) == 0 {
+		errs = append(errs, fmt.Errorf("policy: deniedOrganizations must not be empty, "+
+			"use allowedPrivilegedIdentities to exempt identities"))
+	}
+	if len(s.Policy.DeniedCommonNames) == 0 {
+		errs = append(errs, fmt.Errorf("policy: deniedCommonNames must not be empty, "+
+			"use allowedPrivilegedIdentities to exempt identities"))
+	}
+	if s.Policy.MinRSAKeySize < 0 {
+		errs = append(errs, fmt.Errorf("policy: minRSAKeySize must not be negative"))
+	}
+	if s.Approval.RequiredApprovers < 0 {
+		errs = append(errs, fmt.Errorf("approval: requiredApprovers must not be negative"))
+	}
+	if s.Approval.RequiredApprovers > len(s.Approval.Approvers) {
+		errs = append(errs, fmt.Errorf("approval: requiredApprovers is greater than the number of approvers"))
+	}
+	if s.MaxCSRAge.Duration < 0 {
+		errs = append(errs, fmt.Errorf("maxCSRAge must not be negative"))
+	}
+	if s.MaxApprovalAge.Duration < 0 {
+		errs = append(errs, fmt.Errorf("maxApprovalAge must not be negative"))
+	}
+	supported := false
+	for _, m := range chain.Modes {
+		supported = supported || m == s.Chain.Mode
+	}
+	if !supported {
+		errs = append(errs, fmt.Errorf("chain: mode must be one of %v", chain.Modes))
+	} else if s.Chain.Mode != chain.ModeLeaf && s.Chain.TrustAnchorsFile == "" {
+		errs = append(errs, fmt.Errorf("chain: trustAnchorsFile is required with mode %s", s.Chain.Mode))
+	}
+	return errs
+}
+
+// decodeSettingsBlock decodes a settings block over a copy of its defaults.
+// The defaults are copied through JSON, so that decoding the lists of the
+// block does not overwrite those of the defaults.
+func decodeSettingsBlock(defaults interface{}, data json.RawMessage, v interface{}) error {
+	d, err := json.Marshal(defaults)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(d, v); err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		return decodeStrict(data, v)
+	}
+	return nil
+}
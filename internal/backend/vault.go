@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/vault"
+)
+
+// The names of the Vault authentication methods
+const (
+	VaultAuthKubernetes = "kubernetes"
+	VaultAuthAppRole    = "approle"
+)
+
+// VaultConfig configures the vault backend, which signs certificates using a
+// HashiCorp Vault PKI secrets engine.
+type VaultConfig struct {
+	// Address is the URL of the Vault server.
+	Address string `json:"address"`
+	// PKIPath is the mount path of the PKI secrets engine. Defaults to pki.
+	PKIPath string `json:"pkiPath,omitempty"`
+	// Role is the PKI role used to sign certificates.
+	Role string `json:"role"`
+	// Auth is the authentication method, kubernetes (the default) or
+	// approle.
+	Auth string `json:"auth,omitempty"`
+	// AuthPath is the mount path of the authentication method. Defaults to
+	// the name of the method.
+	AuthPath string `json:"authPath,omitempty"`
+	// KubernetesRole and the ServiceAccount token in KubernetesTokenFile are
+	// used by the kubernetes authentication method.
+	KubernetesRole      string `json:"kubernetesRole,omitempty"`
+	KubernetesTokenFile string `json:"kubernetesTokenFile,omitempty"`
+	// AppRoleRoleID and the secret ID in AppRoleSecretIDFile are used by the
+	// approle authentication method.
+	AppRoleRoleID       string `json:"appRoleRoleID,omitempty"`
+	AppRoleSecretIDFile string `json:"appRoleSecretIDFile,omitempty"`
+	// CAFile, if set, is a PEM file containing the CA certificates used to
+	// verify the Vault server, instead of the system roots.
+	CAFile string `json:"caFile,omitempty"`
+}
+
+func newVaultConfig() Config {
+	return &VaultConfig{
+		PKIPath:             "pki",
+		Auth:                VaultAuthKubernetes,
+		KubernetesTokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+	}
+}
+
+func (c *VaultConfig) Validate() []error {
+	var errs []error
+	if c.Address == "" {
+		errs = append(errs, fmt.Errorf("address is required"))
+	}
+	if c.Role == "" {
+		errs = append(errs, fmt.Errorf("role is required"))
+	}
+	switch c.Auth {
+	case VaultAuthKubernetes:
+		if c.KubernetesRole == "" {
+			errs = append(errs, fmt.Errorf("kubernetesRole is required"))
+		}
+	case VaultAuthAppRole:
+		if c.AppRoleRoleID == "" {
+			errs = append(errs, fmt.Errorf("appRoleRoleID is required"))
+		}
+		if c.AppRoleSecretIDFile == "" {
+			errs = append(errs, fmt.Errorf("appRoleSecretIDFile is required"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("auth must be %s or %s", VaultAuthKubernetes, VaultAuthAppRole))
+	}
+	return errs
+}
+
+func newVaultSigner(config Config, opts Options) (signer.Signer, error) {
+	c := config.(*VaultConfig)
+	authPath := c.AuthPath
+	if authPath == "" {
+		authPath = c.Auth
+	}
+	s := &vault.Signer{
+		Address:    c.Address,
+		Path:       c.PKIPath,
+		Role:       c.Role,
+		HTTPClient: &http.Client{Timeout: vault.DefaultTimeout},
+		Log:        opts.Log,
+		Chain:      opts.Chain,
+		Assembler:  opts.Assembler,
+	}
+	switch c.Auth {
+	case VaultAuthKubernetes:
+		s.Auth = &vault.KubernetesAuth{Path: authPath, Role: c.KubernetesRole, TokenFile: c.KubernetesTokenFile}
+	case VaultAuthAppRole:
+		secretID, err := readSecretFile(c.AppRoleSecretIDFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading appRoleSecretIDFile: %v", err)
+		}
+		s.Auth = &vault.AppRoleAuth{Path: authPath, RoleID: c.AppRoleRoleID, SecretID: secretID}
+	}
+	if c.CAFile != "" {
+		data, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading caFile: %v", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("caFile %s contains no certificates", c.CAFile)
+		}
+		s.HTTPClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	}
+	return s, nil
+}
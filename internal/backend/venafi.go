@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Venafi/vcert"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cert-manager/signer-venafi/internal/breaker"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/venafi"
+)
+
+// VcertConfig configures the venafi backend, which connects to Venafi TPP or
+// Venafi Cloud as described by a vcert INI file.
+type VcertConfig struct {
+	// ConfigFile is the path of the vcert INI file.
+	ConfigFile string `json:"configFile"`
+	// Zone, if set, is used instead of the zone in the INI file.
+	Zone string `json:"zone,omitempty"`
+	// CAChainFile, if set, is a PEM file containing the CA chain of the
+	// zone, which is published before any certificate is picked up.
+	CAChainFile    string               `json:"caChainFile,omitempty"`
+	CircuitBreaker CircuitBreakerConfig `json:"circuitBreaker"`
+}
+
+func (c *VcertConfig) Validate() []error {
+	errs := c.CircuitBreaker.validate()
+	if c.ConfigFile == "" {
+		errs = append(errs, fmt.Errorf("configFile is required"))
+	}
+	return errs
+}
+
+// TPPConfig configures the venafi-tpp backend, which connects to Venafi TPP.
+type TPPConfig struct {
+	// URL is the URL of the TPP web SDK, e.g. https://tpp.example.com/vedsdk.
+	URL  string `json:"url"`
+	Zone string `json:"zone"`
+	// Username and the password in PasswordFile are the credentials of the
+	// TPP user.
+	Username     string `json:"username"`
+	PasswordFile string `json:"passwordFile"`
+	// TrustBundleFile, if set, is a PEM file containing the CA certificates
+	// used to verify the TPP server.
+	TrustBundleFile string               `json:"trustBundleFile,omitempty"`
+	CAChainFile     string               `json:"caChainFile,omitempty"`
+	CircuitBreaker  CircuitBreakerConfig `json:"circuitBreaker"`
+}
+
+func (c *TPPConfig) Validate() []error {
+	errs := c.CircuitBreaker.validate()
+	if c.URL == "" {
+		errs = append(errs, fmt.Errorf("url is required"))
+	}
+	if c.Zone == "" {
+		errs = append(errs, fmt.Errorf("zone is required"))
+	}
+	if c.Username == "" {
+		errs = append(errs, fmt.Errorf("username is required"))
+	}
+	if c.PasswordFile == "" {
+		errs = append(errs, fmt.Errorf("passwordFile is required"))
+	}
+	return errs
+}
+
+// CloudConfig configures the venafi-cloud backend, which connects to Venafi
+// Cloud.
+type CloudConfig struct {
+	// URL, if set, is used instead of the public Venafi Cloud URL.
+	URL  string `json:"url,omitempty"`
+	Zone string `json:"zone"`
+	// APIKeyFile is the file containing the Venafi Cloud API key.
+	APIKeyFile     string               `json:"apiKeyFile"`
+	CAChainFile    string               `json:"caChainFile,omitempty"`
+	CircuitBreaker CircuitBreakerConfig `json:"circuitBreaker"`
+}
+
+func (c *CloudConfig) Validate() []error {
+	errs := c.CircuitBreaker.validate()
+	if c.Zone == "" {
+		errs = append(errs, fmt.Errorf("zone is required"))
+	}
+	if c.APIKeyFile == "" {
+		errs = append(errs, fmt.Errorf("apiKeyFile is required"))
+	}
+	return errs
+}
+
+// CircuitBreakerConfig configures the circuit breaker in front of Venafi,
+// which stops the calls to Venafi after FailureThreshold consecutive
+// failures, for OpenDuration, before probing whether it has recovered.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures which open the
+	// circuit breaker, or zero to disable it.
+	FailureThreshold int             `json:"failureThreshold"`
+	OpenDuration     metav1.Duration `json:"openDuration"`
+}
+
+// DefaultCircuitBreakerConfig returns the default circuit breaker
+// configuration of the Venafi backends.
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		FailureThreshold: breaker.DefaultFailureThreshold,
+		OpenDuration:     metav1.Duration{Duration: breaker.DefaultOpenDuration},
+	}
+}
+
+func (c *CircuitBreakerConfig) validate() []error {
+	var errs []error
+	if c.FailureThreshold < 0 {
+		errs = append(errs, fmt.Errorf("circuitBreaker: failureThreshold must not be negative"))
+	}
+	if c.FailureThreshold > 0 && c.OpenDuration.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("circuitBreaker: openDuration must be positive"))
+	}
+	return errs
+}
+
+func newVcertSigner(config Config, opts Options) (signer.Signer, error) {
+	c := config.(*VcertConfig)
+	vcertConfig := &vcert.Config{
+		ConfigFile: c.ConfigFile,
+	}
+	if err := vcertConfig.LoadFromFile(); err != nil {
+		return nil, fmt.Errorf("unable to load vcert config file %s: %v", c.ConfigFile, err)
+	}
+	return newVenafiSigner(vcertConfig, c.Zone, c.CAChainFile, c.CircuitBreaker, opts)
+}
+
+func newTPPSigner(config Config, opts Options) (signer.Signer, error) {
+	c := config.(*TPPConfig)
+	password, err := readSecretFile(c.PasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading passwordFile: %v", err)
+	}
+	vcertConfig := &vcert.Config{
+		ConnectorType: endpoint.ConnectorTypeTPP,
+		BaseUrl:       c.URL,
+		Zone:          c.Zone,
+		Credentials: &endpoint.Authentication{
+			User:     c.Username,
+			Password: password,
+		},
+	}
+	if c.TrustBundleFile != "" {
+		data, err := ioutil.ReadFile(c.TrustBundleFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading trustBundleFile: %v", err)
+		}
+		vcertConfig.ConnectionTrust = string(data)
+	}
+	return newVenafiSigner(vcertConfig, "", c.CAChainFile, c.CircuitBreaker, opts)
+}
+
+func newCloudSigner(config Config, opts Options) (signer.Signer, error) {
+	c := config.(*CloudConfig)
+	apiKey, err := readSecretFile(c.APIKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading apiKeyFile: %v", err)
+	}
+	return newVenafiSigner(&vcert.Config{
+		ConnectorType: endpoint.ConnectorTypeCloud,
+		BaseUrl:       c.URL,
+		Zone:          c.Zone,
+		Credentials: &endpoint.Authentication{
+			APIKey: apiKey,
+		},
+	}, "", c.CAChainFile, c.CircuitBreaker, opts)
+}
+
+func newVenafiSigner(vcertConfig *vcert.Config, zone, caChainFile string, cb CircuitBreakerConfig, opts Options) (*venafi.Signer, error) {
+	var zoneChain []byte
+	if caChainFile != "" {
+		var err error
+		zoneChain, err = ioutil.ReadFile(caChainFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading caChainFile: %v", err)
+		}
+	}
+	s := &venafi.Signer{
+		ClientFactory: func() (endpoint.Connector, error) {
+			vcertClient, err := vcert.NewClient(vcertConfig)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising vcert client: %w", err)
+			}
+			return vcertClient, nil
+		},
+		Log:       opts.Log,
+		Zone:      zone,
+		ZoneChain: zoneChain,
+		Chain:     opts.Chain,
+		Assembler: opts.Assembler,
+	}
+	if cb.FailureThreshold > 0 {
+		s.Breaker = &breaker.Breaker{
+			SignerName:       opts.SignerName,
+			Instance:         opts.Instance,
+			Log:              opts.Log.WithName("Breaker"),
+			FailureThreshold: cb.FailureThreshold,
+			OpenDuration:     cb.OpenDuration.Duration,
+		}
+	}
+	return s, nil
+}
+
+// readSecretFile returns the contents of a file containing a credential,
+// without any trailing newline.
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
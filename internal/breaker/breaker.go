@@ -0,0 +1,192 @@
+// Package breaker implements a circuit breaker, which stops calls to a CA
+// after repeated failures, so that an outage of the CA is not made worse by
+// every CSR being retried against it.
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/cert-manager/signer-venafi/internal/metrics"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// State is the state of a Breaker.
+type State string
+
+// The states of a Breaker
+const (
+	// StateClosed allows every call.
+	StateClosed State = "closed"
+	// StateOpen fails every call, without making it.
+	StateOpen State = "open"
+	// StateHalfOpen allows a single probe call, whose result closes or
+	// re-opens the Breaker.
+	StateHalfOpen State = "half-open"
+)
+
+// States are the states of a Breaker.
+var States = []State{StateClosed, StateOpen, StateHalfOpen}
+
+// ErrOpen is returned instead of making a call while a Breaker is open.
+var ErrOpen = fmt.Errorf("%w: circuit breaker is open", signer.ErrTemporary)
+
+// The defaults of the Breaker fields
+const (
+	DefaultFailureThreshold = 5
+	DefaultOpenDuration     = time.Second * 30
+)
+
+// Breaker counts the consecutive failures of calls, which are the calls
+// returning an error wrapping signer.ErrTemporary, such as a connection error,
+// a timeout or a server error. Other errors mean that the CA answered, so they
+// are counted as successes. When FailureThreshold calls have failed, it opens,
+// and fails calls with ErrOpen, until OpenDuration has passed. It is then
+// half-open, and allows a single probe call: if that succeeds the Breaker
+// closes, otherwise it opens again.
+//
+// A Breaker must not be copied after first use.
+type Breaker struct {
+	// SignerName and Instance label the metrics of the Breaker.
+	SignerName string
+	Instance   string
+	Log        logr.Logger
+	// FailureThreshold is the number of consecutive failures which open the
+	// Breaker.
+	FailureThreshold int
+	// OpenDuration is how long the Breaker stays open before allowing a
+	// probe.
+	OpenDuration time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+	lastErr  error
+}
+
+// Do calls f, unless the Breaker is open, and counts an error returned by f
+// which wraps signer.ErrTemporary as a failure. It returns the error of f, or
+// ErrOpen.
+func (o *Breaker) Do(f func() error) error {
+	if err := o.allow(); err != nil {
+		return err
+	}
+	err := f()
+	o.record(err)
+	return err
+}
+
+// State returns the current state of the Breaker.
+func (o *Breaker) State() State {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.init()
+	if o.state == StateOpen && time.Since(o.openedAt) >= o.openDuration() {
+		return StateHalfOpen
+	}
+	return o.state
+}
+
+// Health returns an error unless the Breaker is closed.
+func (o *Breaker) Health() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.init()
+	if o.state == StateClosed {
+		return nil
+	}
+	return fmt.Errorf("circuit breaker is %s since %s after %d consecutive failures, the last of which was: %v",
+		o.state, o.openedAt.Format(time.RFC3339), o.failures, o.lastErr)
+}
+
+func (o *Breaker) allow() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.init()
+	switch o.state {
+	case StateOpen:
+		if time.Since(o.openedAt) < o.openDuration() {
+			return ErrOpen
+		}
+		o.setState(StateHalfOpen)
+		fallthrough
+	case StateHalfOpen:
+		if o.probing {
+			return ErrOpen
+		}
+		o.Log.Info("Probing for recovery")
+		o.probing = true
+	}
+	return nil
+}
+
+func (o *Breaker) record(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.state == StateHalfOpen {
+		o.probing = false
+	}
+	if !errors.Is(err, signer.ErrTemporary) {
+		if o.state != StateClosed {
+			o.Log.Info("Closing circuit breaker after a successful call")
+			o.setState(StateClosed)
+		}
+		o.failures = 0
+		o.lastErr = nil
+		return
+	}
+	o.failures++
+	o.lastErr = err
+	switch {
+	case o.state == StateOpen:
+		// The call was allowed before concurrent calls opened the Breaker.
+		return
+	case o.state == StateHalfOpen:
+		o.Log.Error(err, "Probe failed, re-opening circuit breaker", "open-duration", o.openDuration())
+	case o.failures >= o.failureThreshold():
+		o.Log.Error(err, "Opening circuit breaker", "failures", o.failures, "open-duration", o.openDuration())
+	default:
+		return
+	}
+	o.openedAt = time.Now()
+	o.setState(StateOpen)
+}
+
+// init sets the initial state. It must be called with mu held.
+func (o *Breaker) init() {
+	if o.state == "" {
+		o.setState(StateClosed)
+	}
+}
+
+// setState must be called with mu held.
+func (o *Breaker) setState(state State) {
+	o.state = state
+	for _, s := range States {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		metrics.CircuitBreakerState.WithLabelValues(o.SignerName, o.Instance, string(s)).Set(value)
+	}
+}
+
+func (o *Breaker) failureThreshold() int {
+	if o.FailureThreshold > 0 {
+		return o.FailureThreshold
+	}
+	return DefaultFailureThreshold
+}
+
+func (o *Breaker) openDuration() time.Duration {
+	if o.OpenDuration > 0 {
+		return o.OpenDuration
+	}
+	return DefaultOpenDuration
+}
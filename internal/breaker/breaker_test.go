@@ -0,0 +1,133 @@
+package breaker_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/cert-manager/signer-venafi/internal/breaker"
+	"github.com/cert-manager/signer-venafi/internal/metrics"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+var errUnavailable = fmt.Errorf("%w: connection refused", signer.ErrTemporary)
+
+func fail() error    { return errUnavailable }
+func succeed() error { return nil }
+
+func newBreaker(t *testing.T, signerName string) *breaker.Breaker {
+	return &breaker.Breaker{
+		SignerName:       signerName,
+		Log:              zapr.NewLogger(zaptest.NewLogger(t)),
+		FailureThreshold: 3,
+		OpenDuration:     time.Millisecond * 50,
+	}
+}
+
+func stateMetric(signerName string, state breaker.State) float64 {
+	return testutil.ToFloat64(metrics.CircuitBreakerState.WithLabelValues(signerName, "", string(state)))
+}
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(t, "example.com/breaker")
+	assert.Equal(t, breaker.StateClosed, b.State())
+	assert.NoError(t, b.Health())
+	assert.Equal(t, float64(1), stateMetric("example.com/breaker", breaker.StateClosed))
+
+	// A success resets the count of consecutive failures.
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.NoError(t, b.Do(succeed))
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.Equal(t, breaker.StateClosed, b.State())
+
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.Equal(t, breaker.StateOpen, b.State())
+	assert.Equal(t, float64(1), stateMetric("example.com/breaker", breaker.StateOpen))
+	assert.Equal(t, float64(0), stateMetric("example.com/breaker", breaker.StateClosed))
+	err := b.Health()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "circuit breaker is open")
+	assert.Contains(t, err.Error(), "connection refused")
+
+	called := false
+	err = b.Do(func() error {
+		called = true
+		return nil
+	})
+	assert.False(t, called, "calls should fail fast while open")
+	assert.True(t, errors.Is(err, breaker.ErrOpen))
+	assert.True(t, errors.Is(err, signer.ErrTemporary))
+
+	// A failed probe opens the breaker again.
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, breaker.StateHalfOpen, b.State())
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.Equal(t, breaker.StateOpen, b.State())
+	assert.True(t, errors.Is(b.Do(succeed), breaker.ErrOpen))
+
+	// A successful probe closes it.
+	time.Sleep(time.Millisecond * 60)
+	assert.NoError(t, b.Do(succeed))
+	assert.Equal(t, breaker.StateClosed, b.State())
+	assert.NoError(t, b.Health())
+	assert.Equal(t, float64(1), stateMetric("example.com/breaker", breaker.StateClosed))
+}
+
+func TestBreaker_SingleProbe(t *testing.T) {
+	b := newBreaker(t, "example.com/breaker-probe")
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, errUnavailable, b.Do(fail))
+	}
+	time.Sleep(time.Millisecond * 60)
+
+	probing := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- b.Do(func() error {
+			close(probing)
+			time.Sleep(time.Millisecond * 50)
+			return nil
+		})
+	}()
+	<-probing
+	assert.True(t, errors.Is(b.Do(succeed), breaker.ErrOpen), "only one probe should be allowed while half-open")
+	assert.NoError(t, <-done)
+	assert.Equal(t, breaker.StateClosed, b.State())
+}
+
+func TestBreaker_AnsweredErrors(t *testing.T) {
+	b := newBreaker(t, "example.com/breaker-answered")
+	errDenied := fmt.Errorf("%w: policy violation", signer.ErrPermanent)
+	errUnauthorized := errors.New("401 Unauthorized")
+
+	// Errors which are not temporary do not open the breaker.
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, errDenied, b.Do(func() error { return errDenied }))
+		assert.Equal(t, errUnauthorized, b.Do(func() error { return errUnauthorized }))
+	}
+	assert.Equal(t, breaker.StateClosed, b.State())
+
+	// They reset the count of consecutive failures.
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.Equal(t, errDenied, b.Do(func() error { return errDenied }))
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.Equal(t, breaker.StateClosed, b.State())
+
+	// And a probe which the CA answers closes the breaker.
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.Equal(t, errUnavailable, b.Do(fail))
+	assert.Equal(t, breaker.StateOpen, b.State())
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, errDenied, b.Do(func() error { return errDenied }))
+	assert.Equal(t, breaker.StateClosed, b.State())
+}
@@ -0,0 +1,159 @@
+// Package chain assembles the certificate chain which is returned to the
+// requester from the leaf certificate and the CA chain returned by a signer,
+// and validates it to the configured trust anchors.
+package chain
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// Mode selects which CA certificates follow the leaf certificate.
+type Mode string
+
+const (
+	// ModeLeaf returns only the leaf certificate.
+	ModeLeaf Mode = "leaf"
+	// ModeIntermediates returns the leaf certificate and the intermediate CA
+	// certificates, but not the root CA certificate.
+	ModeIntermediates Mode = "intermediates"
+	// ModeFull returns the leaf certificate and the full CA chain, including
+	// the root CA certificate.
+	ModeFull Mode = "full"
+)
+
+// Modes are the supported Modes.
+var Modes = []Mode{ModeLeaf, ModeIntermediates, ModeFull}
+
+// Assembler assembles the certificate chain.
+// The leaf certificate is always first, because clients of the CSR API expect
+// the issued certificate to be the first PEM block.
+type Assembler struct {
+	Mode Mode
+	// RootFirst orders the CA certificates following the leaf from the root
+	// to the issuer of the leaf. Otherwise they are ordered from the issuer
+	// of the leaf to the root.
+	RootFirst bool
+	// TrustAnchors, if set, are the root certificates to which the leaf
+	// certificate must be validated, using the intermediates in the chain.
+	TrustAnchors *x509.CertPool
+}
+
+// Assemble returns the PEM encoded certificate chain, assembled from the PEM
+// encoded leaf certificate and the PEM encoded CA certificates returned with
+// it, which may be in any order.
+func (o *Assembler) Assemble(leafPEM []byte, caPEM []byte) ([]byte, error) {
+	leaves, err := parseCertificates(leafPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("no leaf certificate")
+	}
+	leaf := leaves[0]
+	cas, err := parseCertificates(caPEM)
+	if err != nil {
+		return nil, err
+	}
+	cas = append(cas, leaves[1:]...)
+
+	var path []*x509.Certificate
+	if o.TrustAnchors != nil {
+		intermediates := x509.NewCertPool()
+		for _, ca := range cas {
+			intermediates.AddCert(ca)
+		}
+		chains, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         o.TrustAnchors,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("certificate chain can not be validated to the trust anchors: %v", err)
+		}
+		path = chains[0]
+	} else {
+		path = buildPath(leaf, cas)
+	}
+
+	var chain []*x509.Certificate
+	switch o.Mode {
+	case ModeLeaf, "":
+	case ModeIntermediates:
+		for _, cert := range path[1:] {
+			if !isSelfSigned(cert) {
+				chain = append(chain, cert)
+			}
+		}
+	case ModeFull:
+		chain = path[1:]
+	default:
+		return nil, fmt.Errorf("unsupported chain mode %q", o.Mode)
+	}
+	if o.RootFirst {
+		for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+			chain[i], chain[j] = chain[j], chain[i]
+		}
+	}
+
+	out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+	for _, cert := range chain {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out, nil
+}
+
+// buildPath returns the leaf followed by its issuer, and so on, for as long as
+// the issuer can be found among the CA certificates.
+func buildPath(leaf *x509.Certificate, cas []*x509.Certificate) []*x509.Certificate {
+	path := []*x509.Certificate{leaf}
+	for current := leaf; !isSelfSigned(current); {
+		var issuer *x509.Certificate
+		for _, ca := range cas {
+			if bytes.Equal(ca.RawSubject, current.RawIssuer) && current.CheckSignatureFrom(ca) == nil && !inPath(path, ca) {
+				issuer = ca
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		path = append(path, issuer)
+		current = issuer
+	}
+	return path
+}
+
+func inPath(path []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, p := range path {
+		if p.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+}
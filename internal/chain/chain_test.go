@@ -0,0 +1,140 @@
+package chain_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cert-manager/signer-venafi/internal/chain"
+)
+
+type issued struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+// issue returns a certificate signed by the parent, or a self-signed
+// certificate if the parent is nil.
+func issue(t *testing.T, cn string, isCA bool, parent *issued) *issued {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	}
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, key.Public(), parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &issued{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+func TestAssembler_Assemble(t *testing.T) {
+	root := issue(t, "root", true, nil)
+	intermediate := issue(t, "intermediate", true, root)
+	leaf := issue(t, "leaf", false, intermediate)
+	otherRoot := issue(t, "other", true, nil)
+
+	anchors := x509.NewCertPool()
+	anchors.AddCert(root.cert)
+	otherAnchors := x509.NewCertPool()
+	otherAnchors.AddCert(otherRoot.cert)
+
+	tests := []struct {
+		name      string
+		assembler chain.Assembler
+		caPEM     string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "Leaf",
+			assembler: chain.Assembler{Mode: chain.ModeLeaf},
+			caPEM:     intermediate.pem + root.pem,
+			want:      leaf.pem,
+		},
+		{
+			name:      "Intermediates",
+			assembler: chain.Assembler{Mode: chain.ModeIntermediates},
+			caPEM:     root.pem + intermediate.pem,
+			want:      leaf.pem + intermediate.pem,
+		},
+		{
+			name:      "FullRootLast",
+			assembler: chain.Assembler{Mode: chain.ModeFull},
+			caPEM:     root.pem + intermediate.pem,
+			want:      leaf.pem + intermediate.pem + root.pem,
+		},
+		{
+			name:      "FullRootFirst",
+			assembler: chain.Assembler{Mode: chain.ModeFull, RootFirst: true},
+			caPEM:     intermediate.pem + root.pem,
+			want:      leaf.pem + root.pem + intermediate.pem,
+		},
+		{
+			name:      "FullUnrelatedCAIgnored",
+			assembler: chain.Assembler{Mode: chain.ModeFull},
+			caPEM:     otherRoot.pem + intermediate.pem + root.pem,
+			want:      leaf.pem + intermediate.pem + root.pem,
+		},
+		{
+			name:      "FullValidated",
+			assembler: chain.Assembler{Mode: chain.ModeFull, TrustAnchors: anchors},
+			caPEM:     intermediate.pem,
+			want:      leaf.pem + intermediate.pem + root.pem,
+		},
+		{
+			name:      "ErrorUntrusted",
+			assembler: chain.Assembler{Mode: chain.ModeFull, TrustAnchors: otherAnchors},
+			caPEM:     intermediate.pem + root.pem,
+			wantErr:   true,
+		},
+		{
+			name:      "ErrorMissingIntermediate",
+			assembler: chain.Assembler{Mode: chain.ModeLeaf, TrustAnchors: anchors},
+			caPEM:     root.pem,
+			wantErr:   true,
+		},
+		{
+			name:      "ErrorUnsupportedMode",
+			assembler: chain.Assembler{Mode: "foo"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := tt.assembler.Assemble([]byte(leaf.pem), []byte(tt.caPEM))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(actual))
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Package cleanup decides when completed CSRs are old enough to be deleted.
+package cleanup
+
+import (
+	"time"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+)
+
+// The states of completed CSRs, which are also the values of the state label
+// of metrics.CSRDeletedTotal
+const (
+	StateIssued = "issued"
+	StateFailed = "failed"
+	StateDenied = "denied"
+)
+
+// Retention holds the retention periods of issued, failed and denied CSRs.
+// Zero means that CSRs in that state are never deleted.
+type Retention struct {
+	Issued time.Duration
+	Failed time.Duration
+	Denied time.Duration
+}
+
+// Expired returns the state of a completed CSR and whether, at now, it is
+// older than the retention period for that state.
+// Denied and failed CSRs are aged from the time of their Denied or Failed
+// condition, or from their creation if the condition has no update time.
+// Issued CSRs are aged from their creation. If untilNotAfter is true, an
+// issued CSR is also kept until its certificate has expired.
+func (o Retention) Expired(csr *capi.CertificateSigningRequest, now time.Time, untilNotAfter bool) (string, bool) {
+	_, denied := capihelper.GetCertApprovalCondition(&csr.Status)
+	switch {
+	case denied:
+		return StateDenied, olderThan(now, conditionTime(csr, capi.CertificateDenied), o.Denied)
+	case capihelper.IsCertificateRequestFailed(csr):
+		return StateFailed, olderThan(now, conditionTime(csr, capihelper.CertificateFailed), o.Failed)
+	case csr.Status.Certificate != nil:
+		if untilNotAfter {
+			cert, err := pki.DecodeX509CertificateBytes(csr.Status.Certificate)
+			if err != nil || now.Before(cert.NotAfter) {
+				return StateIssued, false
+			}
+		}
+		return StateIssued, olderThan(now, csr.CreationTimestamp.Time, o.Issued)
+	}
+	return "", false
+}
+
+// conditionTime returns the last update time of the condition, or the CSR
+// creation time if the condition has no update time.
+func conditionTime(csr *capi.CertificateSigningRequest, conditionType capi.RequestConditionType) time.Time {
+	for _, c := range csr.Status.Conditions {
+		if c.Type == conditionType && !c.LastUpdateTime.IsZero() {
+			return c.LastUpdateTime.Time
+		}
+	}
+	return csr.CreationTimestamp.Time
+}
+
+func olderThan(now, t time.Time, retention time.Duration) bool {
+	return retention > 0 && now.Sub(t) > retention
+}
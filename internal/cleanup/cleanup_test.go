@@ -0,0 +1,212 @@
+package cleanup_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/cleanup"
+)
+
+// certificate returns a PEM encoded self-signed certificate which expires at
+// notAfter.
+func certificate(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "app"},
+		NotBefore:    notAfter.Add(-time.Hour * 24),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestRetention_Expired(t *testing.T) {
+	now := time.Now()
+	retention := cleanup.Retention{
+		Issued: time.Hour,
+		Failed: time.Hour * 2,
+		Denied: time.Hour * 3,
+	}
+	ago := func(d time.Duration) metav1.Time { return metav1.NewTime(now.Add(-d)) }
+	csr := func(created time.Duration, certificate []byte, conditions ...capi.CertificateSigningRequestCondition) *capi.CertificateSigningRequest {
+		return &capi.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: ago(created)},
+			Status: capi.CertificateSigningRequestStatus{
+				Conditions:  conditions,
+				Certificate: certificate,
+			},
+		}
+	}
+	condition := func(conditionType capi.RequestConditionType, updated time.Duration) capi.CertificateSigningRequestCondition {
+		c := capi.CertificateSigningRequestCondition{Type: conditionType}
+		if updated > 0 {
+			c.LastUpdateTime = ago(updated)
+		}
+		return c
+	}
+	approved := condition(capi.CertificateApproved, time.Hour*10)
+	unexpired := certificate(t, now.Add(time.Hour))
+	expired := certificate(t, now.Add(-time.Minute))
+
+	tests := []struct {
+		name          string
+		retention     cleanup.Retention
+		csr           *capi.CertificateSigningRequest
+		untilNotAfter bool
+		wantState     string
+		wantExpired   bool
+	}{
+		{
+			name:      "Pending",
+			retention: retention,
+			csr:       csr(time.Hour*10, nil),
+		},
+		{
+			name:      "ApprovedNotIssued",
+			retention: retention,
+			csr:       csr(time.Hour*10, nil, approved),
+		},
+		{
+			name:      "IssuedWithinRetention",
+			retention: retention,
+			csr:       csr(time.Minute*30, unexpired, approved),
+			wantState: cleanup.StateIssued,
+		},
+		{
+			name:        "IssuedAfterRetention",
+			retention:   retention,
+			csr:         csr(time.Hour*2, unexpired, approved),
+			wantState:   cleanup.StateIssued,
+			wantExpired: true,
+		},
+		{
+			name:      "IssuedZeroRetention",
+			retention: cleanup.Retention{Failed: time.Hour, Denied: time.Hour},
+			csr:       csr(time.Hour*1000, unexpired, approved),
+			wantState: cleanup.StateIssued,
+		},
+		{
+			name:          "IssuedUntilNotAfterUnexpired",
+			retention:     retention,
+			csr:           csr(time.Hour*2, unexpired, approved),
+			untilNotAfter: true,
+			wantState:     cleanup.StateIssued,
+		},
+		{
+			name:          "IssuedUntilNotAfterExpired",
+			retention:     retention,
+			csr:           csr(time.Hour*2, expired, approved),
+			untilNotAfter: true,
+			wantState:     cleanup.StateIssued,
+			wantExpired:   true,
+		},
+		{
+			name:          "IssuedUntilNotAfterInvalidCertificate",
+			retention:     retention,
+			csr:           csr(time.Hour*2, []byte("not a certificate"), approved),
+			untilNotAfter: true,
+			wantState:     cleanup.StateIssued,
+		},
+		{
+			name:      "FailedWithinRetention",
+			retention: retention,
+			csr:       csr(time.Hour*10, nil, approved, condition(capihelper.CertificateFailed, time.Hour)),
+			wantState: cleanup.StateFailed,
+		},
+		{
+			name:        "FailedAfterRetention",
+			retention:   retention,
+			csr:         csr(time.Hour*10, nil, approved, condition(capihelper.CertificateFailed, time.Hour*3)),
+			wantState:   cleanup.StateFailed,
+			wantExpired: true,
+		},
+		{
+			name:      "FailedZeroRetention",
+			retention: cleanup.Retention{Issued: time.Hour, Denied: time.Hour},
+			csr:       csr(time.Hour*10, nil, approved, condition(capihelper.CertificateFailed, time.Hour*3)),
+			wantState: cleanup.StateFailed,
+		},
+		{
+			name:      "FailedNoTimestampCreatedWithinRetention",
+			retention: retention,
+			csr:       csr(time.Hour, nil, approved, condition(capihelper.CertificateFailed, 0)),
+			wantState: cleanup.StateFailed,
+		},
+		{
+			name:        "FailedNoTimestampCreatedAfterRetention",
+			retention:   retention,
+			csr:         csr(time.Hour*3, nil, approved, condition(capihelper.CertificateFailed, 0)),
+			wantState:   cleanup.StateFailed,
+			wantExpired: true,
+		},
+		{
+			name:      "DeniedWithinRetention",
+			retention: retention,
+			csr:       csr(time.Hour*10, nil, condition(capi.CertificateDenied, time.Hour*2)),
+			wantState: cleanup.StateDenied,
+		},
+		{
+			name:        "DeniedAfterRetention",
+			retention:   retention,
+			csr:         csr(time.Hour*10, nil, condition(capi.CertificateDenied, time.Hour*4)),
+			wantState:   cleanup.StateDenied,
+			wantExpired: true,
+		},
+		{
+			name:      "DeniedZeroRetention",
+			retention: cleanup.Retention{Issued: time.Hour, Failed: time.Hour},
+			csr:       csr(time.Hour*10, nil, condition(capi.CertificateDenied, time.Hour*4)),
+			wantState: cleanup.StateDenied,
+		},
+		{
+			name:      "DeniedNoTimestampCreatedWithinRetention",
+			retention: retention,
+			csr:       csr(time.Hour*2, nil, condition(capi.CertificateDenied, 0)),
+			wantState: cleanup.StateDenied,
+		},
+		{
+			name:        "DeniedNoTimestampCreatedAfterRetention",
+			retention:   retention,
+			csr:         csr(time.Hour*4, nil, condition(capi.CertificateDenied, 0)),
+			wantState:   cleanup.StateDenied,
+			wantExpired: true,
+		},
+		{
+			name:      "DeniedTakesPrecedenceOverFailed",
+			retention: retention,
+			csr: csr(time.Hour*10, nil,
+				condition(capi.CertificateDenied, time.Hour),
+				condition(capihelper.CertificateFailed, time.Hour*10)),
+			wantState: cleanup.StateDenied,
+		},
+		{
+			name:      "FailedTakesPrecedenceOverIssued",
+			retention: retention,
+			csr:       csr(time.Hour*10, unexpired, approved, condition(capihelper.CertificateFailed, time.Minute)),
+			wantState: cleanup.StateFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, expired := tt.retention.Expired(tt.csr, now, tt.untilNotAfter)
+			assert.Equal(t, tt.wantState, state)
+			assert.Equal(t, tt.wantExpired, expired)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+)
+
+// ErrApprovalIgnored is wrapped by the errors returned by CSRFilter.Check when
+// a CSR has been approved, but not in a way that satisfies the ApprovalRules.
+var ErrApprovalIgnored = errors.New("approval ignored")
+
+// ApprovalRules restrict which Approved conditions are accepted as approval.
+// Empty lists impose no restriction.
+//
+// The user who approved a CSR is not recorded in the CSR, so Users and
+// Groups are enforced by the admission webhook, see CheckApproval, which
+// refuses approvals by other users. Reasons are checked by both.
+type ApprovalRules struct {
+	// Reasons are the accepted values of the Approved condition reason.
+	// E.g. AutoApproved, which is the reason used by kube-controller-manager.
+	Reasons []string
+	// Users are the users who may approve CSRs.
+	// E.g. system:serviceaccount:kube-system:certificate-controller.
+	Users []string
+	// Groups are the groups whose members may approve CSRs.
+	Groups []string
+}
+
+// Check returns an error wrapping ErrApprovalIgnored unless the CSR has an
+// Approved condition with an accepted reason.
+func (o *ApprovalRules) Check(csr capi.CertificateSigningRequest) error {
+	for _, c := range csr.Status.Conditions {
+		if c.Type != capi.CertificateApproved {
+			continue
+		}
+		if err := o.checkReason(c); err != nil {
+			return fmt.Errorf("%w: %v", ErrApprovalIgnored, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: CSR is not approved", ErrApprovalIgnored)
+}
+
+// CheckApproval returns an error unless the Approved condition, which is being
+// added by the user, has an accepted reason and the user is one of the Users
+// or a member of one of the Groups.
+func (o *ApprovalRules) CheckApproval(condition capi.CertificateSigningRequestCondition, user authenticationv1.UserInfo) error {
+	if err := o.checkReason(condition); err != nil {
+		return err
+	}
+	if len(o.Users) == 0 && len(o.Groups) == 0 {
+		return nil
+	}
+	if capihelper.Contains(o.Users, user.Username) || containsAny(o.Groups, user.Groups) {
+		return nil
+	}
+	return fmt.Errorf("user %q is not allowed to approve CSRs, only the users %v and the groups %v are",
+		user.Username, o.Users, o.Groups)
+}
+
+func (o *ApprovalRules) checkReason(condition capi.CertificateSigningRequestCondition) error {
+	if len(o.Reasons) > 0 && !capihelper.Contains(o.Reasons, condition.Reason) {
+		return fmt.Errorf("CSR approval reason %q is not one of %v", condition.Reason, o.Reasons)
+	}
+	return nil
+}
+
+func containsAny(list []string, items []string) bool {
+	for _, item := range items {
+		if capihelper.Contains(list, item) {
+			return true
+		}
+	}
+	return false
+}
@@ -20,6 +20,10 @@ type Filter interface {
 
 type CSRFilter struct {
 	SignerName string
+	// ApprovalRules, if set, restrict which approvals are accepted.
+	ApprovalRules *ApprovalRules
+	// Quorum, if set, requires the CSR to be approved by multiple approvers.
+	Quorum *Quorum
 }
 
 var _ Filter = &CSRFilter{}
@@ -36,6 +40,16 @@ func (o *CSRFilter) Check(csr capi.CertificateSigningRequest) error {
 		return fmt.Errorf("CSR is not approved")
 	case csr.Status.Certificate != nil:
 		return fmt.Errorf("CSR has already been signed")
+	case capihelper.IsCertificateRequestFailed(&csr):
+		return fmt.Errorf("CSR has failed")
+	}
+	if o.ApprovalRules != nil {
+		if err := o.ApprovalRules.Check(csr); err != nil {
+			return err
+		}
+	}
+	if o.Quorum != nil {
+		return o.Quorum.Check(csr)
 	}
 	return nil
 }
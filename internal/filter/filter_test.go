@@ -7,6 +7,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/pointer"
 
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
 	"github.com/cert-manager/signer-venafi/internal/filter"
 )
 
@@ -38,6 +39,7 @@ func TestCSRFilter_Check(t *testing.T) {
 	tests := []struct {
 		name    string
 		mutate  func(*capi.CertificateSigningRequest)
+		rules   *filter.ApprovalRules
 		wantErr bool
 	}{
 		{
@@ -81,6 +83,37 @@ func TestCSRFilter_Check(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "ErrorFailed",
+			mutate: func(csr *capi.CertificateSigningRequest) {
+				csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+					Type: capihelper.CertificateFailed,
+				})
+			},
+			wantErr: true,
+		},
+		{
+			name: "SuccessApprovalRules",
+			mutate: func(csr *capi.CertificateSigningRequest) {
+				csr.Status.Conditions[0].Reason = "AutoApproved"
+			},
+			rules: &filter.ApprovalRules{
+				Reasons: []string{"AutoApproved"},
+				// Users are checked by the admission webhook.
+				Users: []string{"system:kube-controller-manager"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "ErrorApprovalReason",
+			mutate: func(csr *capi.CertificateSigningRequest) {
+				csr.Status.Conditions[0].Reason = "KubectlApprove"
+			},
+			rules: &filter.ApprovalRules{
+				Reasons: []string{"AutoApproved"},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -108,7 +141,8 @@ func TestCSRFilter_Check(t *testing.T) {
 			}
 			tt.mutate(&csr)
 			o := &filter.CSRFilter{
-				SignerName: sampleSignerName,
+				SignerName:    sampleSignerName,
+				ApprovalRules: tt.rules,
 			}
 			if err := o.Check(csr); (err != nil) != tt.wantErr {
 				t.Errorf("CSRFilter.Check() error = %v, wantErr %v", err, tt.wantErr)
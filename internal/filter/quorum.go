@@ -0,0 +1,87 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	capi "k8s.io/api/certificates/v1beta1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+)
+
+// AnnotationKeyPrefixApprover is the prefix of the CSR annotations which are
+// used by approvers to record their approval.
+// An approver records their approval by adding the annotation
+// approver.signer-venafi.cert-manager.io/<approver>, where <approver> is
+// their Kubernetes user name. E.g.
+//
+//	kubectl annotate csr <csr> approver.signer-venafi.cert-manager.io/alice=approved
+const AnnotationKeyPrefixApprover = "approver.signer-venafi.cert-manager.io/"
+
+// Quorum requires a CSR to have been approved by a minimum number of distinct
+// approvers, none of whom is the requester.
+//
+// Any user who can update the CSR can add an approver annotation, so the
+// admission webhook uses CheckAnnotations to refuse annotations which are
+// not added by the approver they name.
+type Quorum struct {
+	// Required is the number of distinct approvers required.
+	Required int
+	// Approvers are the user names of the approvers whose approval is
+	// counted.
+	Approvers []string
+}
+
+// Check returns an error unless the CSR has been approved by the required
+// number of approvers.
+func (o *Quorum) Check(csr capi.CertificateSigningRequest) error {
+	approvedBy := o.approvers(csr)
+	if len(approvedBy) < o.Required {
+		return fmt.Errorf("CSR is awaiting %d-of-%d approval, approved by [%s]",
+			o.Required, len(o.Approvers), strings.Join(approvedBy, ", "))
+	}
+	return nil
+}
+
+// CheckAnnotations returns an error if the user is adding or changing an
+// approver annotation other than their own.
+// The annotations of a CSR which is being created have no old annotations.
+func (o *Quorum) CheckAnnotations(old, annotations map[string]string, username string) error {
+	var forged []string
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, AnnotationKeyPrefixApprover) {
+			continue
+		}
+		if oldValue, ok := old[key]; ok && oldValue == value {
+			continue
+		}
+		if strings.TrimPrefix(key, AnnotationKeyPrefixApprover) != username {
+			forged = append(forged, key)
+		}
+	}
+	if len(forged) > 0 {
+		sort.Strings(forged)
+		return fmt.Errorf("user %q can only add their own approver annotation %s, not %s",
+			username, AnnotationKeyPrefixApprover+username, strings.Join(forged, ", "))
+	}
+	return nil
+}
+
+// approvers returns the sorted names of the eligible approvers who have
+// approved the CSR.
+func (o *Quorum) approvers(csr capi.CertificateSigningRequest) []string {
+	var approvedBy []string
+	for key := range csr.Annotations {
+		if !strings.HasPrefix(key, AnnotationKeyPrefixApprover) {
+			continue
+		}
+		approver := strings.TrimPrefix(key, AnnotationKeyPrefixApprover)
+		if !capihelper.Contains(o.Approvers, approver) || approver == csr.Spec.Username {
+			continue
+		}
+		approvedBy = append(approvedBy, approver)
+	}
+	sort.Strings(approvedBy)
+	return approvedBy
+}
@@ -0,0 +1,112 @@
+package filter_test
+
+import (
+	"testing"
+
+	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cert-manager/signer-venafi/internal/filter"
+)
+
+func TestQuorum_Check(t *testing.T) {
+	tests := []struct {
+		name      string
+		requester string
+		approvers []string
+		wantErr   bool
+	}{
+		{
+			name:      "Success",
+			requester: "carol",
+			approvers: []string{"alice", "bob"},
+			wantErr:   false,
+		},
+		{
+			name:      "ErrorNotEnoughApprovers",
+			requester: "carol",
+			approvers: []string{"alice"},
+			wantErr:   true,
+		},
+		{
+			name:      "ErrorApproverIsRequester",
+			requester: "bob",
+			approvers: []string{"alice", "bob"},
+			wantErr:   true,
+		},
+		{
+			name:      "ErrorApproverNotEligible",
+			requester: "carol",
+			approvers: []string{"alice", "mallory"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := capi.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{},
+				},
+				Spec: capi.CertificateSigningRequestSpec{
+					Username: tt.requester,
+				},
+			}
+			for _, approver := range tt.approvers {
+				csr.Annotations[filter.AnnotationKeyPrefixApprover+approver] = "approved"
+			}
+			o := &filter.Quorum{
+				Required:  2,
+				Approvers: []string{"alice", "bob", "carol"},
+			}
+			if err := o.Check(csr); (err != nil) != tt.wantErr {
+				t.Errorf("Quorum.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuorum_CheckAnnotations(t *testing.T) {
+	alice := filter.AnnotationKeyPrefixApprover + "alice"
+	bob := filter.AnnotationKeyPrefixApprover + "bob"
+	tests := []struct {
+		name        string
+		old         map[string]string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{
+			name:        "SuccessOwnAnnotation",
+			annotations: map[string]string{alice: "approved"},
+		},
+		{
+			name:        "SuccessOtherAnnotationUnchanged",
+			old:         map[string]string{bob: "approved"},
+			annotations: map[string]string{alice: "approved", bob: "approved", "example.com/other": "x"},
+		},
+		{
+			name:        "SuccessOtherAnnotationRemoved",
+			old:         map[string]string{bob: "approved"},
+			annotations: map[string]string{},
+		},
+		{
+			name:        "ErrorOtherAnnotationAdded",
+			old:         map[string]string{alice: "approved"},
+			annotations: map[string]string{alice: "approved", bob: "approved"},
+			wantErr:     true,
+		},
+		{
+			name:        "ErrorOtherAnnotationChanged",
+			old:         map[string]string{bob: "rejected"},
+			annotations: map[string]string{bob: "approved"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &filter.Quorum{Required: 2, Approvers: []string{"alice", "bob"}}
+			if err := o.CheckAnnotations(tt.old, tt.annotations, "alice"); (err != nil) != tt.wantErr {
+				t.Errorf("Quorum.CheckAnnotations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Package metrics defines the Prometheus metrics of the signer and registers
+// them with the controller-runtime metrics registry, so that they are served
+// by the manager metrics endpoint.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "signer_venafi"
+
+var (
+	// CSRAgeSeconds is the age of each CSR when it is sent to the signer, or
+	// when it is failed for being too old.
+	CSRAgeSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "csr_age_seconds",
+			Help:      "Age of CSRs, since creation, when they are signed or expire before signing.",
+			Buckets:   []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600, 7 * 24 * 3600},
+		},
+		[]string{"signer_name", "result"},
+	)
+	// CSRExpiredTotal counts the CSRs which were failed because they were
+	// too old to be signed.
+	CSRExpiredTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "csr_expired_total",
+			Help:      "Number of CSRs which were failed because they expired before signing.",
+		},
+		[]string{"signer_name"},
+	)
+	// SupersededRevokedTotal counts the certificates which were revoked
+	// because a newer certificate was issued for the same identity.
+	SupersededRevokedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "superseded_revoked_total",
+			Help:      "Number of certificates which were revoked because they were superseded.",
+		},
+		[]string{"signer_name"},
+	)
+	// CSRDeletedTotal counts the completed CSRs which were deleted by the
+	// CSR cleaner, by the state of the CSR.
+	CSRDeletedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "csr_deleted_total",
+			Help:      "Number of completed CSRs which were deleted after their retention period.",
+		},
+		[]string{"signer_name", "state"},
+	)
+	// InstanceHealthy is 1 if an instance of a composite signer is healthy,
+	// and 0 while it is skipped after failing.
+	InstanceHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "instance_healthy",
+			Help:      "Whether each instance of a composite signer is healthy (1) or skipped after failing (0).",
+		},
+		[]string{"signer_name", "instance"},
+	)
+	// ShadowComparisonsTotal counts the certificates of shadow signers which
+	// were compared with the certificates of the primary signer, by result.
+	ShadowComparisonsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "shadow_comparisons_total",
+			Help:      "Number of shadow certificates compared with the primary certificate, by result: match, mismatch or error.",
+		},
+		[]string{"signer_name", "result"},
+	)
+	// ShadowDifferencesTotal counts the fields which differed between the
+	// certificates of shadow signers and the primary signer.
+	ShadowDifferencesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "shadow_differences_total",
+			Help:      "Number of shadow certificates which differ from the primary certificate, by field.",
+		},
+		[]string{"signer_name", "field"},
+	)
+	// CircuitBreakerState is 1 for the current state of the circuit breaker
+	// of each signer, and 0 for the other states.
+	CircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "circuit_breaker_state",
+			Help:      "The state of the circuit breaker in front of the CA of each signer: 1 for the current state, closed, open or half-open, and 0 for the others.",
+		},
+		[]string{"signer_name", "instance", "state"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		CSRAgeSeconds,
+		CSRExpiredTotal,
+		SupersededRevokedTotal,
+		CSRDeletedTotal,
+		InstanceHealthy,
+		ShadowComparisonsTotal,
+		ShadowDifferencesTotal,
+		CircuitBreakerState,
+	)
+}
@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// The deadline of each call to the plugin, if Signer.Timeout is not set
+const defaultTimeout = time.Second * 30
+
+// Signer implements signer.Signer by forwarding each call to a plugin.
+type Signer struct {
+	Client SignerClient
+	Log    logr.Logger
+	// Timeout is the deadline of each call to the plugin.
+	Timeout time.Duration
+}
+
+var (
+	_ signer.Signer        = &Signer{}
+	_ signer.HealthChecker = &Signer{}
+)
+
+func (o *Signer) Sign(csr capi.CertificateSigningRequest) (string, error) {
+	log := o.Log.WithName("Sign")
+
+	csr.APIVersion = capi.SchemeGroupVersion.String()
+	csr.Kind = "CertificateSigningRequest"
+	data, err := json.Marshal(&csr)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode CSR: %v", err)
+	}
+
+	ctx, cancel := o.context()
+	defer cancel()
+	log.V(1).Info("Sending CSR to plugin")
+	resp, err := o.Client.Sign(ctx, &SignRequest{CertificateSigningRequest: data})
+	if err != nil {
+		return "", fromStatus(err)
+	}
+	if resp.PickupId == "" {
+		return "", fmt.Errorf("plugin returned an empty pickup ID")
+	}
+	return resp.PickupId, nil
+}
+
+func (o *Signer) Pickup(pickupID string) ([]byte, error) {
+	log := o.Log.WithName("Pickup")
+
+	ctx, cancel := o.context()
+	defer cancel()
+	log.V(1).Info("Retrieving certificate from plugin", "pickup-id", pickupID)
+	resp, err := o.Client.Pickup(ctx, &PickupRequest{PickupId: pickupID})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	if len(resp.Certificate) == 0 {
+		return nil, fmt.Errorf("plugin returned an empty certificate")
+	}
+	return resp.Certificate, nil
+}
+
+// Health returns an error if the plugin can not be reached or reports that it
+// is not able to sign certificates.
+func (o *Signer) Health(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, o.timeout())
+	defer cancel()
+	resp, err := o.Client.Health(ctx, &HealthRequest{})
+	if err != nil {
+		return fromStatus(err)
+	}
+	if !resp.Serving {
+		return fmt.Errorf("plugin is not serving: %s", resp.Message)
+	}
+	return nil
+}
+
+func (o *Signer) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), o.timeout())
+}
+
+func (o *Signer) timeout() time.Duration {
+	if o.Timeout == 0 {
+		return defaultTimeout
+	}
+	return o.Timeout
+}
@@ -0,0 +1,168 @@
+// Package conformance is a test suite which checks that a plugin implements
+// the API described in plugin.proto as signer-venafi expects.
+//
+// Plugins written in Go can call Run from their own tests. Any other plugin
+// can be tested by running the tests of this package against its socket:
+//
+//	go test ./internal/plugin/conformance -args -plugin-socket /run/plugin.sock
+package conformance
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc"
+	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cert-manager/signer-venafi/internal/plugin"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// Options configures the CSRs sent to the plugin under test.
+type Options struct {
+	// SignerName is the signer name of the CSRs.
+	// Defaults to example.com/conformance.
+	SignerName string
+	// DNSName is the common name and DNS SAN of the CSRs, which must be
+	// allowed by the CA of the plugin.
+	// Defaults to conformance.example.com.
+	DNSName string
+	// Timeout is how long to wait for a certificate to be issued.
+	// Defaults to one minute.
+	Timeout time.Duration
+	// PollInterval is how long to wait between pickups while a certificate
+	// has not been issued. Defaults to one second.
+	PollInterval time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.SignerName == "" {
+		o.SignerName = "example.com/conformance"
+	}
+	if o.DNSName == "" {
+		o.DNSName = "conformance.example.com"
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Minute
+	}
+	if o.PollInterval == 0 {
+		o.PollInterval = time.Second
+	}
+}
+
+// Run runs the conformance tests against the plugin served to client.
+func Run(t *testing.T, client plugin.SignerClient, opts Options) {
+	opts.setDefaults()
+	s := &plugin.Signer{
+		Client: client,
+		Log:    zapr.NewLogger(zaptest.NewLogger(t)).WithName("conformance"),
+	}
+
+	t.Run("Health", func(t *testing.T) {
+		assert.NoError(t, s.Health(context.Background()))
+	})
+
+	t.Run("SignAndPickup", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		csr := newCSR(t, opts, key)
+
+		pickupID, err := s.Sign(csr)
+		require.NoError(t, err)
+		require.NotEmpty(t, pickupID)
+
+		var certificate []byte
+		deadline := time.Now().Add(opts.Timeout)
+		for {
+			certificate, err = s.Pickup(pickupID)
+			if !errors.Is(err, signer.ErrTemporary) || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(opts.PollInterval)
+		}
+		require.NoError(t, err)
+
+		block, _ := pem.Decode(certificate)
+		require.NotNil(t, block, "the certificate is not PEM encoded")
+		require.Equal(t, "CERTIFICATE", block.Type)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		assert.Equal(t, key.Public(), cert.PublicKey, "the certificate is not for the public key of the CSR")
+	})
+
+	t.Run("SignInvalidCSRIsPermanent", func(t *testing.T) {
+		csr := newCSR(t, opts, nil)
+		csr.Spec.Request = []byte("not a CSR")
+
+		_, err := s.Sign(csr)
+		assert.True(t, errors.Is(err, signer.ErrPermanent), "expected ErrPermanent, got %v", err)
+	})
+
+	t.Run("SignMalformedRequestIsPermanent", func(t *testing.T) {
+		s := &plugin.Signer{Client: malformedClient{client}, Log: s.Log}
+		_, err := s.Sign(newCSR(t, opts, nil))
+		assert.True(t, errors.Is(err, signer.ErrPermanent), "expected ErrPermanent, got %v", err)
+	})
+
+	t.Run("PickupUnknown", func(t *testing.T) {
+		_, err := s.Pickup("conformance-unknown-pickup-id")
+		assert.Error(t, err)
+	})
+}
+
+// newCSR returns a CSR for key, or for a new key if key is nil.
+func newCSR(t *testing.T, opts Options, key crypto.Signer) capi.CertificateSigningRequest {
+	if key == nil {
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: opts.DNSName},
+		DNSNames: []string{opts.DNSName},
+	}, key)
+	require.NoError(t, err)
+
+	return capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "conformance",
+		},
+		Spec: capi.CertificateSigningRequestSpec{
+			Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+			SignerName: &opts.SignerName,
+			Usages: []capi.KeyUsage{
+				capi.UsageDigitalSignature,
+				capi.UsageKeyEncipherment,
+				capi.UsageServerAuth,
+			},
+		},
+		Status: capi.CertificateSigningRequestStatus{
+			Conditions: []capi.CertificateSigningRequestCondition{
+				{Type: capi.CertificateApproved, Reason: "Conformance"},
+			},
+		},
+	}
+}
+
+// malformedClient sends a SignRequest which does not contain a CSR object.
+type malformedClient struct {
+	plugin.SignerClient
+}
+
+func (c malformedClient) Sign(ctx context.Context, in *plugin.SignRequest, opts ...grpc.CallOption) (*plugin.SignResponse, error) {
+	return c.SignerClient.Sign(ctx, &plugin.SignRequest{CertificateSigningRequest: []byte("{")}, opts...)
+}
@@ -0,0 +1,33 @@
+package conformance_test
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cert-manager/signer-venafi/internal/plugin"
+	"github.com/cert-manager/signer-venafi/internal/plugin/conformance"
+)
+
+var (
+	pluginSocket = flag.String("plugin-socket", "", "The Unix socket of the plugin under test.")
+	signerName   = flag.String("signer-name", "", "The signer name of the CSRs sent to the plugin.")
+	dnsName      = flag.String("dns-name", "", "The DNS name requested by the CSRs sent to the plugin.")
+)
+
+// TestPlugin runs the conformance tests against the plugin serving on
+// -plugin-socket.
+func TestPlugin(t *testing.T) {
+	if *pluginSocket == "" {
+		t.Skip("-plugin-socket not set")
+	}
+	conn, err := plugin.Dial(*pluginSocket)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conformance.Run(t, plugin.NewSignerClient(conn), conformance.Options{
+		SignerName: *signerName,
+		DNSName:    *dnsName,
+	})
+}
@@ -0,0 +1,72 @@
+// Package plugin implements the signer plugin API described in plugin.proto,
+// which allows certificates to be signed by a backend running in another
+// process, serving the API on a Unix socket.
+package plugin
+
+// plugin.pb.go is generated by `make generate`, which installs protoc and
+// protoc-gen-go.
+//go:generate protoc --go_out=plugins=grpc,paths=source_relative:. plugin.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// Dial returns a connection to the plugin serving on the Unix socket at path.
+// The connection is established in the background, so the plugin need not be
+// running yet.
+func Dial(path string) (*grpc.ClientConn, error) {
+	return grpc.Dial(path,
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}),
+	)
+}
+
+// Listen listens on the Unix socket at path, removing any socket left behind
+// by a previous instance of the plugin.
+func Listen(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+	}
+	return net.Listen("unix", path)
+}
+
+// toStatus converts an error returned by a signer.Signer to a gRPC status,
+// using the codes documented in plugin.proto.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, signer.ErrPermanent):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, signer.ErrTemporary):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Unknown, err.Error())
+	}
+}
+
+// fromStatus converts a gRPC status returned by a plugin to an error wrapping
+// signer.ErrPermanent or signer.ErrTemporary, using the codes documented in
+// plugin.proto.
+func fromStatus(err error) error {
+	s := status.Convert(err)
+	switch s.Code() {
+	case codes.FailedPrecondition, codes.InvalidArgument:
+		return fmt.Errorf("%w: %s", signer.ErrPermanent, s.Message())
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return fmt.Errorf("%w: %s", signer.ErrTemporary, s.Message())
+	default:
+		return fmt.Errorf("plugin error: %s: %s", s.Code(), s.Message())
+	}
+}
@@ -0,0 +1,180 @@
+package plugin_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	"github.com/cert-manager/signer-venafi/internal/plugin"
+	"github.com/cert-manager/signer-venafi/internal/plugin/conformance"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/local"
+)
+
+// serve serves srv on a Unix socket in a temporary directory and returns a
+// client connected to it.
+func serve(t *testing.T, srv *plugin.Server) (plugin.SignerClient, func()) {
+	dir, err := ioutil.TempDir("", "plugin")
+	require.NoError(t, err)
+	socket := filepath.Join(dir, "plugin.sock")
+	l, err := plugin.Listen(socket)
+	require.NoError(t, err)
+
+	s := grpc.NewServer()
+	plugin.RegisterSignerServer(s, srv)
+	go s.Serve(l)
+
+	conn, err := plugin.Dial(socket)
+	require.NoError(t, err)
+	return plugin.NewSignerClient(conn), func() {
+		conn.Close()
+		s.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "local-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24 * 365),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	log := zapr.NewLogger(zaptest.NewLogger(t))
+	client, stop := serve(t, &plugin.Server{
+		Signer: &local.Signer{
+			CACertificates: []*x509.Certificate{ca},
+			CAKey:          key,
+			Log:            log.WithName("Signer"),
+		},
+		Log: log.WithName("Server"),
+	})
+	defer stop()
+
+	conformance.Run(t, client, conformance.Options{})
+}
+
+// stubSigner returns the configured error from every call.
+type stubSigner struct {
+	err error
+}
+
+func (o *stubSigner) Sign(csr capi.CertificateSigningRequest) (string, error) {
+	return "", o.err
+}
+
+func (o *stubSigner) Pickup(pickupID string) ([]byte, error) {
+	return nil, o.err
+}
+
+func TestSigner_Errors(t *testing.T) {
+	type testCase struct {
+		name    string
+		err     error
+		wantErr error
+	}
+	tests := []testCase{
+		{
+			name:    "Temporary",
+			err:     fmt.Errorf("%w: pending", signer.ErrTemporary),
+			wantErr: signer.ErrTemporary,
+		},
+		{
+			name:    "Permanent",
+			err:     fmt.Errorf("%w: denied", signer.ErrPermanent),
+			wantErr: signer.ErrPermanent,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			log := zapr.NewLogger(zaptest.NewLogger(t))
+			client, stop := serve(t, &plugin.Server{
+				Signer: &stubSigner{err: tc.err},
+				Log:    log.WithName("Server"),
+			})
+			defer stop()
+			s := &plugin.Signer{Client: client, Log: log.WithName("Signer")}
+
+			_, err := s.Sign(capi.CertificateSigningRequest{})
+			assert.True(t, errors.Is(err, tc.wantErr), "expected %v, got %v", tc.wantErr, err)
+			assert.Contains(t, err.Error(), tc.err.Error())
+			_, err = s.Pickup("foo")
+			assert.True(t, errors.Is(err, tc.wantErr), "expected %v, got %v", tc.wantErr, err)
+		})
+	}
+
+	t.Run("Untyped", func(t *testing.T) {
+		log := zapr.NewLogger(zaptest.NewLogger(t))
+		client, stop := serve(t, &plugin.Server{
+			Signer: &stubSigner{err: errors.New("unexpected")},
+			Log:    log.WithName("Server"),
+		})
+		defer stop()
+		s := &plugin.Signer{Client: client, Log: log.WithName("Signer")}
+
+		_, err := s.Sign(capi.CertificateSigningRequest{})
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, signer.ErrTemporary))
+		assert.False(t, errors.Is(err, signer.ErrPermanent))
+	})
+
+	t.Run("PluginNotRunning", func(t *testing.T) {
+		conn, err := plugin.Dial("/nonexistent/plugin.sock")
+		require.NoError(t, err)
+		defer conn.Close()
+		s := &plugin.Signer{
+			Client:  plugin.NewSignerClient(conn),
+			Log:     zapr.NewLogger(zaptest.NewLogger(t)),
+			Timeout: time.Second,
+		}
+		_, err = s.Pickup("foo")
+		assert.True(t, errors.Is(err, signer.ErrTemporary), "expected ErrTemporary, got %v", err)
+	})
+}
+
+func TestSigner_Health(t *testing.T) {
+	log := zapr.NewLogger(zaptest.NewLogger(t))
+	healthErr := errors.New("CA unreachable")
+	client, stop := serve(t, &plugin.Server{
+		Signer:      &stubSigner{},
+		Log:         log.WithName("Server"),
+		HealthCheck: func() error { return healthErr },
+	})
+	defer stop()
+	s := &plugin.Signer{Client: client, Log: log.WithName("Signer")}
+
+	err := s.Health(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "CA unreachable")
+
+	healthErr = nil
+	assert.NoError(t, s.Health(context.Background()))
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// Server implements the Signer service using a signer.Signer, so that any
+// backend can be run as a plugin.
+type Server struct {
+	Signer signer.Signer
+	Log    logr.Logger
+	// HealthCheck, if set, returns an error if the backend is not able to sign
+	// certificates. Otherwise the plugin always reports that it is serving.
+	HealthCheck func() error
+}
+
+var _ SignerServer = &Server{}
+
+func (o *Server) Sign(ctx context.Context, req *SignRequest) (*SignResponse, error) {
+	log := o.Log.WithName("Sign")
+
+	var csr capi.CertificateSigningRequest
+	if err := json.Unmarshal(req.CertificateSigningRequest, &csr); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to decode CSR: %v", err)
+	}
+	log = log.WithValues("name", csr.Name)
+	pickupID, err := o.Signer.Sign(csr)
+	if err != nil {
+		log.Error(err, "Failed to sign")
+		return nil, toStatus(err)
+	}
+	log.V(1).Info("Signed", "pickup-id", pickupID)
+	return &SignResponse{PickupId: pickupID}, nil
+}
+
+func (o *Server) Pickup(ctx context.Context, req *PickupRequest) (*PickupResponse, error) {
+	log := o.Log.WithName("Pickup").WithValues("pickup-id", req.PickupId)
+
+	if req.PickupId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing pickup ID")
+	}
+	certificate, err := o.Signer.Pickup(req.PickupId)
+	if err != nil {
+		log.V(1).Info("Failed to pick up certificate", "reason", err.Error())
+		return nil, toStatus(err)
+	}
+	return &PickupResponse{Certificate: certificate}, nil
+}
+
+func (o *Server) Health(ctx context.Context, req *HealthRequest) (*HealthResponse, error) {
+	if o.HealthCheck != nil {
+		if err := o.HealthCheck(); err != nil {
+			return &HealthResponse{Message: err.Error()}, nil
+		}
+	}
+	return &HealthResponse{Serving: true}, nil
+}
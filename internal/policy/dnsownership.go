@@ -0,0 +1,156 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/secrets"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// AnnotationKeyAllowedDNSNames is the name of the Namespace annotation which
+// lists additional DNS names that may be requested by ServiceAccounts in that
+// Namespace.
+// The value is a comma separated list of DNS names, each of which may have a
+// leading "*." to allow any subdomain.
+const AnnotationKeyAllowedDNSNames = "signer-venafi.cert-manager.io/allowed-dns-names"
+
+// +kubebuilder:rbac:groups="",resources=namespaces;services,verbs=get;list;watch
+
+// DNSOwnership only allows a ServiceAccount to request DNS names which belong
+// to Services in its own Namespace, or which are allowed by an annotation on
+// its Namespace.
+// The CSRs created by the signer for managed Secrets are checked against the
+// Namespace of the Secret instead, if the Secret names the CSR as its pending
+// CSR. See secrets.ManagedSecretNamespace.
+type DNSOwnership struct {
+	Client client.Reader
+	// SecretReader, if set, is used to read managed Secrets, so that the
+	// Secrets in all namespaces need not be cached. Otherwise the DNS names
+	// of CSRs for managed Secrets are checked against the requester.
+	SecretReader client.Reader
+	// ClusterDomain is the DNS domain of the cluster. E.g. cluster.local
+	ClusterDomain string
+}
+
+var _ Policy = &DNSOwnership{}
+
+// Check returns nil for CSRs without DNS names, whatever their requester, so
+// that CSRs from requesters which are not ServiceAccounts, such as kubelet
+// client CSRs, are not refused.
+func (o *DNSOwnership) Check(csr capi.CertificateSigningRequest) error {
+	ctx := context.Background()
+
+	req, err := pki.DecodeX509CertificateRequestBytes(csr.Spec.Request)
+	if err != nil {
+		return fmt.Errorf("failed to decode CSR: %v", err)
+	}
+	if len(req.DNSNames) == 0 {
+		return nil
+	}
+
+	namespace, managed, err := o.managedSecretNamespace(ctx, csr)
+	if err != nil {
+		return err
+	}
+	if !managed {
+		if namespace, _, err = splitServiceAccountUsername(csr.Spec.Username); err != nil {
+			return err
+		}
+	}
+	return o.CheckNamespace(ctx, namespace, req.DNSNames)
+}
+
+// managedSecretNamespace returns the namespace of the managed Secret of the
+// CSR, and true, if the CSR was created for a managed Secret.
+func (o *DNSOwnership) managedSecretNamespace(ctx context.Context, csr capi.CertificateSigningRequest) (string, bool, error) {
+	if o.SecretReader == nil {
+		return "", false, nil
+	}
+	namespace, ok, err := secrets.ManagedSecretNamespace(ctx, o.SecretReader, csr)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %v", signer.ErrTemporary, err)
+	}
+	return namespace, ok, nil
+}
+
+// CheckNamespace returns an error if any of the DNS names are not owned by the
+// namespace.
+func (o *DNSOwnership) CheckNamespace(ctx context.Context, namespace string, dnsNames []string) error {
+	var ns corev1.Namespace
+	err := o.Client.Get(ctx, client.ObjectKey{Name: namespace}, &ns)
+	switch {
+	case apierrors.IsNotFound(err):
+		return fmt.Errorf("requester namespace %q does not exist", namespace)
+	case err != nil:
+		return fmt.Errorf("%w: error getting requester namespace: %v", signer.ErrTemporary, err)
+	}
+	allowed := capihelper.SplitList(ns.Annotations[AnnotationKeyAllowedDNSNames])
+
+	var denied []string
+	for _, dnsName := range dnsNames {
+		if matchesAny(allowed, dnsName) {
+			continue
+		}
+		owned, err := o.isServiceName(ctx, namespace, dnsName)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			denied = append(denied, dnsName)
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("DNS names are not owned by namespace %q: %s", namespace, strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// isServiceName returns true if dnsName is the cluster DNS name of an existing
+// Service in the namespace.
+func (o *DNSOwnership) isServiceName(ctx context.Context, namespace, dnsName string) (bool, error) {
+	serviceName := ""
+	for _, suffix := range []string{
+		"." + namespace + ".svc",
+		"." + namespace + ".svc." + o.ClusterDomain,
+	} {
+		if strings.HasSuffix(dnsName, suffix) {
+			serviceName = strings.TrimSuffix(dnsName, suffix)
+		}
+	}
+	if serviceName == "" || strings.Contains(serviceName, ".") {
+		return false, nil
+	}
+
+	var svc corev1.Service
+	err := o.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: serviceName}, &svc)
+	switch {
+	case apierrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("%w: error getting service: %v", signer.ErrTemporary, err)
+	}
+	return true, nil
+}
+
+// matchesAny returns true if dnsName is equal to one of the patterns, or is a
+// subdomain of a pattern with a leading "*.".
+func matchesAny(patterns []string, dnsName string) bool {
+	for _, pattern := range patterns {
+		if pattern == dnsName {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(dnsName, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+package policy_test
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/cert-manager/signer-venafi/internal/policy"
+	"github.com/cert-manager/signer-venafi/internal/secrets"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	signerfake "github.com/cert-manager/signer-venafi/internal/signer/fake"
+)
+
+func TestDNSOwnership_Check(t *testing.T) {
+	objects := []runtime.Object{
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "ns1",
+				Annotations: map[string]string{
+					policy.AnnotationKeyAllowedDNSNames: "app.example.com, *.apps.example.com",
+				},
+			},
+		},
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "ns2"},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "svc1"},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "svc2"},
+		},
+	}
+	tests := []struct {
+		name     string
+		username string
+		dnsNames []string
+		wantErr  bool
+	}{
+		{
+			name:     "SuccessNoDNSNames",
+			username: "system:serviceaccount:ns1:sa1",
+		},
+		{
+			name:     "SuccessServiceNames",
+			username: "system:serviceaccount:ns1:sa1",
+			dnsNames: []string{"svc1.ns1.svc", "svc1.ns1.svc.cluster.local"},
+		},
+		{
+			name:     "SuccessAnnotation",
+			username: "system:serviceaccount:ns1:sa1",
+			dnsNames: []string{"app.example.com", "foo.apps.example.com"},
+		},
+		{
+			name:     "ErrorOtherNamespaceService",
+			username: "system:serviceaccount:ns1:sa1",
+			dnsNames: []string{"svc2.ns2.svc"},
+			wantErr:  true,
+		},
+		{
+			name:     "ErrorServiceNotFound",
+			username: "system:serviceaccount:ns1:sa1",
+			dnsNames: []string{"svc3.ns1.svc"},
+			wantErr:  true,
+		},
+		{
+			name:     "ErrorNotAnnotated",
+			username: "system:serviceaccount:ns2:sa1",
+			dnsNames: []string{"app.example.com"},
+			wantErr:  true,
+		},
+		{
+			name:     "ErrorNamespaceNotFound",
+			username: "system:serviceaccount:ns3:sa1",
+			dnsNames: []string{"svc3.ns3.svc"},
+			wantErr:  true,
+		},
+		{
+			name:     "SuccessNotServiceAccountNoDNSNames",
+			username: "system:node:node1",
+		},
+		{
+			name:     "ErrorNotServiceAccount",
+			username: "system:node:node1",
+			dnsNames: []string{"svc1.ns1.svc"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := capi.CertificateSigningRequest{
+				Spec: capi.CertificateSigningRequestSpec{
+					Username: tt.username,
+					Request:  generateCSR(t, &x509.CertificateRequest{DNSNames: tt.dnsNames}),
+				},
+			}
+			o := &policy.DNSOwnership{
+				Client:        fake.NewFakeClientWithScheme(clientgoscheme.Scheme, objects...),
+				ClusterDomain: "cluster.local",
+			}
+			if err := o.Check(csr); (err != nil) != tt.wantErr {
+				t.Errorf("DNSOwnership.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestDNSOwnership_CheckManagedSecret verifies that the CSRs created by the
+// signer for managed Secrets are checked against the namespace of the Secret,
+// but only if the Secret names the CSR and holds its private key.
+func TestDNSOwnership_CheckManagedSecret(t *testing.T) {
+	key, err := pki.GenerateECPrivateKey(pki.ECCurve256)
+	require.NoError(t, err)
+	keyPEM, err := pki.EncodeECPrivateKey(key)
+	require.NoError(t, err)
+	otherKey, err := pki.GenerateECPrivateKey(pki.ECCurve256)
+	require.NoError(t, err)
+	otherKeyPEM, err := pki.EncodeECPrivateKey(otherKey)
+	require.NoError(t, err)
+
+	objects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "signer-venafi-system"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "svc1"}},
+	}
+	secret := func(name, csrName string, keyPEM []byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "ns1",
+				Name:        name,
+				Annotations: map[string]string{secrets.AnnotationKeyPendingCSR: csrName},
+			},
+			Data: map[string][]byte{secrets.DataKeyPendingKey: keyPEM},
+		}
+	}
+	secretObjects := []runtime.Object{
+		secret("secret1", "csr1", keyPEM),
+		secret("other-csr", "csr2", keyPEM),
+		secret("other-key", "csr1", otherKeyPEM),
+	}
+
+	tests := []struct {
+		name          string
+		managedSecret string
+		secretReader  bool
+		wantErr       bool
+	}{
+		{
+			name:          "SuccessSecretNamespace",
+			managedSecret: "ns1/secret1",
+			secretReader:  true,
+		},
+		{
+			name:          "ErrorNoSecretReader",
+			managedSecret: "ns1/secret1",
+			wantErr:       true,
+		},
+		{
+			name:          "ErrorSecretNotFound",
+			managedSecret: "ns1/secret2",
+			secretReader:  true,
+			wantErr:       true,
+		},
+		{
+			name:          "ErrorSecretNamesOtherCSR",
+			managedSecret: "ns1/other-csr",
+			secretReader:  true,
+			wantErr:       true,
+		},
+		{
+			name:          "ErrorSecretHoldsOtherKey",
+			managedSecret: "ns1/other-key",
+			secretReader:  true,
+			wantErr:       true,
+		},
+		{
+			name:          "ErrorInvalidAnnotation",
+			managedSecret: "secret1",
+			secretReader:  true,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := capi.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "csr1",
+					Annotations: map[string]string{secrets.AnnotationKeyManagedSecret: tt.managedSecret},
+				},
+				Spec: capi.CertificateSigningRequestSpec{
+					// The signer itself is the requester of the CSRs of
+					// managed Secrets.
+					Username: "system:serviceaccount:signer-venafi-system:default",
+					Request:  generateCSRWithKey(t, &x509.CertificateRequest{DNSNames: []string{"svc1.ns1.svc"}}, key),
+				},
+			}
+			o := &policy.DNSOwnership{
+				Client:        fake.NewFakeClientWithScheme(clientgoscheme.Scheme, objects...),
+				ClusterDomain: "cluster.local",
+			}
+			if tt.secretReader {
+				o.SecretReader = fake.NewFakeClientWithScheme(clientgoscheme.Scheme, secretObjects...)
+			}
+			if err := o.Check(csr); (err != nil) != tt.wantErr {
+				t.Errorf("DNSOwnership.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// errorReader fails every Get, like an unreachable API server.
+type errorReader struct {
+	client.Reader
+}
+
+func (errorReader) Get(context.Context, client.ObjectKey, runtime.Object) error {
+	return errors.New("connection refused")
+}
+
+func TestDNSOwnership_CheckTemporaryError(t *testing.T) {
+	csr := capi.CertificateSigningRequest{
+		Spec: capi.CertificateSigningRequestSpec{
+			Username: "system:serviceaccount:ns1:sa1",
+			Request:  generateCSR(t, &x509.CertificateRequest{DNSNames: []string{"svc1.ns1.svc"}}),
+		},
+	}
+	o := &policy.DNSOwnership{Client: errorReader{}, ClusterDomain: "cluster.local"}
+	err := o.Check(csr)
+	assert.True(t, errors.Is(err, signer.ErrTemporary), "expected ErrTemporary, got %v", err)
+
+	s := &policy.Signer{Signer: &signerfake.Signer{}, Policies: []policy.Policy{o}}
+	_, err = s.Sign(csr)
+	assert.True(t, errors.Is(err, signer.ErrTemporary), "expected ErrTemporary, got %v", err)
+	assert.False(t, errors.Is(err, signer.ErrPermanent), "a temporary error must not fail the CSR: %v", err)
+}
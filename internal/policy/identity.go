@@ -0,0 +1,63 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+)
+
+var (
+	// DefaultDeniedOrganizations are the subject organizations which are
+	// interpreted by the Kubernetes API server as privileged groups.
+	DefaultDeniedOrganizations = []string{
+		"system:masters",
+	}
+	// DefaultDeniedCommonNames are the subject common names which are
+	// interpreted by the Kubernetes API server as control-plane components.
+	DefaultDeniedCommonNames = []string{
+		"system:kube-controller-manager",
+		"system:kube-scheduler",
+	}
+)
+
+// PrivilegedIdentities rejects CSRs whose subject would be interpreted by the
+// Kubernetes API server as a privileged user or group.
+type PrivilegedIdentities struct {
+	// DeniedOrganizations are subject organizations (Kubernetes groups) which
+	// must not appear in a CSR.
+	DeniedOrganizations []string
+	// DeniedCommonNames are subject common names (Kubernetes users) which must
+	// not appear in a CSR.
+	DeniedCommonNames []string
+	// Allowed are organizations or common names which are exempt from the deny
+	// lists above.
+	// This is the only way to opt out of the default deny lists.
+	Allowed []string
+}
+
+var _ Policy = &PrivilegedIdentities{}
+
+func (o *PrivilegedIdentities) Check(csr capi.CertificateSigningRequest) error {
+	req, err := pki.DecodeX509CertificateRequestBytes(csr.Spec.Request)
+	if err != nil {
+		return fmt.Errorf("failed to decode CSR: %v", err)
+	}
+
+	var denied []string
+	for _, org := range req.Subject.Organization {
+		if capihelper.Contains(o.DeniedOrganizations, org) && !capihelper.Contains(o.Allowed, org) {
+			denied = append(denied, "O="+org)
+		}
+	}
+	if cn := req.Subject.CommonName; capihelper.Contains(o.DeniedCommonNames, cn) && !capihelper.Contains(o.Allowed, cn) {
+		denied = append(denied, "CN="+cn)
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("CSR subject contains privileged identities: %s", strings.Join(denied, ", "))
+	}
+	return nil
+}
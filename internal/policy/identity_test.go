@@ -0,0 +1,82 @@
+package policy_test
+
+import (
+	"testing"
+
+	capi "k8s.io/api/certificates/v1beta1"
+
+	"github.com/cert-manager/signer-venafi/internal/policy"
+)
+
+// sampleCSR has subject O=system:masters, CN=admin.
+// It is generated according to instructions in
+// https://github.com/kelseyhightower/kubernetes-the-hard-way/blob/1.15.3/docs/04-certificate-authority.md
+const sampleCSR = `
+-----BEGIN CERTIFICATE REQUEST-----
+MIICwTCCAakCAQAwfDELMAkGA1UEBhMCVVMxDzANBgNVBAgTBk9yZWdvbjERMA8G
+A1UEBxMIUG9ydGxhbmQxFzAVBgNVBAoTDnN5c3RlbTptYXN0ZXJzMSAwHgYDVQQL
+ExdLdWJlcm5ldGVzIFRoZSBIYXJkIFdheTEOMAwGA1UEAxMFYWRtaW4wggEiMA0G
+CSqGSIb3DQEBAQUAA4IBDwAwggEKAoIBAQDJQ3WG50I8jq6UwiOe15NJNuPDTR53
+Gb4qbH8xIese8gZABldAV98KOEd2JTkOuIpn59tfn3yraEDaG0fxbrQhZbpdwxFC
+BM+p3Hpm9jmWsZHBc1n0Ieox8NATJ3tL28lkhWQDEN+K8qcqeyGcjM72KgVek+KB
+n0ynofoUmqUKHnySwF2XlztIeiNywafQFQLWtaLDNmtHRHc9qnBE0NYStNvzkkaX
+FqljmZb+9m9QrBY8s1MEV7rRmMD2294TvmbrwSkIEvmE7eobcnLKTdfXYb0KHq77
+FRsujWxbjG96x69z3mZNuAn5XYHWvz+2GPewyxw7K6Tqroin9dOQxAStAgMBAAGg
+ADANBgkqhkiG9w0BAQsFAAOCAQEAjsdH/IgtMTiF7zXAlVTZvrT4rxRLPJZ7E7m2
+0PDHloRxK9nGywpmfXlLXDlJ2UL0i/Gipa01deujqhLwnq2LKuHfRn16fAMaHE9r
+qioviGdEr/HLiXTZ087/cuLMu+CxyVrB5KvTptXVFAWcHlVjbUcFvmRnQPYPAVEX
+WU54pq67c8CNy/b0JoCi/khmfbnalYvhYgQT9hhodkQeaq2/28LTtbJwXJ1mbQbC
+kH/YwZEoKrJnLO0PWP0/emiNMxJYp1cPeQDsILMnJOjaR/WakCncGID3XbQO6LRw
+OKbMbQNLoXS2f6qrS1Iqv4xxvHdDncH4zdhJiLdRqUJrSjPgMQ==
+-----END CERTIFICATE REQUEST-----
+`
+
+func TestPrivilegedIdentities_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  policy.PrivilegedIdentities
+		wantErr bool
+	}{
+		{
+			name: "ErrorDefaultDeniedOrganization",
+			policy: policy.PrivilegedIdentities{
+				DeniedOrganizations: policy.DefaultDeniedOrganizations,
+				DeniedCommonNames:   policy.DefaultDeniedCommonNames,
+			},
+			wantErr: true,
+		},
+		{
+			name: "ErrorDeniedCommonName",
+			policy: policy.PrivilegedIdentities{
+				DeniedCommonNames: []string{"admin"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "SuccessAllowed",
+			policy: policy.PrivilegedIdentities{
+				DeniedOrganizations: policy.DefaultDeniedOrganizations,
+				DeniedCommonNames:   policy.DefaultDeniedCommonNames,
+				Allowed:             []string{"system:masters"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "SuccessEmptyDenyLists",
+			policy:  policy.PrivilegedIdentities{},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := capi.CertificateSigningRequest{
+				Spec: capi.CertificateSigningRequestSpec{
+					Request: []byte(sampleCSR),
+				},
+			}
+			if err := tt.policy.Check(csr); (err != nil) != tt.wantErr {
+				t.Errorf("PrivilegedIdentities.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
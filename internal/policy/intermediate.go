@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+)
+
+var (
+	oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtensionNameConstraints  = asn1.ObjectIdentifier{2, 5, 29, 30}
+)
+
+// Intermediate only allows CSRs for subordinate CA certificates, which must
+// request the CA basic constraint with a path length of at most MaxPathLen,
+// and DNS name constraints which are all within PermittedDNSDomains.
+// The constraints are requested using the extension request attribute of the
+// CSR, and the issued certificate must carry exactly those constraints.
+type Intermediate struct {
+	MaxPathLen int
+	// PermittedDNSDomains are the DNS suffixes to which each intermediate
+	// must be constrained. E.g. mesh.example.com permits mesh.example.com
+	// and any subdomain.
+	PermittedDNSDomains []string
+}
+
+var (
+	_ Policy   = &Intermediate{}
+	_ Verifier = &Intermediate{}
+)
+
+// constraints are the CA constraints requested by a CSR or carried by a
+// certificate.
+type constraints struct {
+	isCA bool
+	// maxPathLen is -1 if the path length is not constrained.
+	maxPathLen          int
+	permittedDNSDomains []string
+}
+
+func (o *Intermediate) Check(csr capi.CertificateSigningRequest) error {
+	isCA, err := capihelper.GetRequestIsCA(&csr)
+	if err != nil {
+		return err
+	}
+	if !isCA {
+		return fmt.Errorf("CSR must request a CA certificate using the %s annotation", capihelper.AnnotationKeyRequestIsCA)
+	}
+
+	req, err := pki.DecodeX509CertificateRequestBytes(csr.Spec.Request)
+	if err != nil {
+		return fmt.Errorf("failed to decode CSR: %v", err)
+	}
+	for _, name := range req.DNSNames {
+		if !withinDomains(name, o.PermittedDNSDomains) {
+			return fmt.Errorf("DNS name %q is not within the permitted domains %v", name, o.PermittedDNSDomains)
+		}
+	}
+	c, err := requestedConstraints(req)
+	if err != nil {
+		return err
+	}
+	return o.checkConstraints(c)
+}
+
+// Verify returns an error unless the certificate carries the constraints
+// requested by the CSR, which also satisfy the policy.
+func (o *Intermediate) Verify(csr capi.CertificateSigningRequest, certificate []byte) error {
+	req, err := pki.DecodeX509CertificateRequestBytes(csr.Spec.Request)
+	if err != nil {
+		return fmt.Errorf("failed to decode CSR: %v", err)
+	}
+	requested, err := requestedConstraints(req)
+	if err != nil {
+		return err
+	}
+	cert, err := pki.DecodeX509CertificateBytes(certificate)
+	if err != nil {
+		return fmt.Errorf("failed to decode certificate: %v", err)
+	}
+	if len(cert.PermittedIPRanges) > 0 || len(cert.PermittedEmailAddresses) > 0 || len(cert.PermittedURIDomains) > 0 {
+		return fmt.Errorf("certificate has name constraints other than DNS names")
+	}
+	issued := constraints{
+		isCA:                cert.BasicConstraintsValid && cert.IsCA,
+		maxPathLen:          cert.MaxPathLen,
+		permittedDNSDomains: cert.PermittedDNSDomains,
+	}
+	if err := o.checkConstraints(issued); err != nil {
+		return fmt.Errorf("issued certificate: %v", err)
+	}
+	if issued.maxPathLen != requested.maxPathLen || !capihelper.EqualStrings(issued.permittedDNSDomains, requested.permittedDNSDomains) {
+		return fmt.Errorf("issued certificate constraints (path length %d, permitted DNS domains %v) "+
+			"do not match the requested constraints (path length %d, permitted DNS domains %v)",
+			issued.maxPathLen, issued.permittedDNSDomains, requested.maxPathLen, requested.permittedDNSDomains)
+	}
+	return nil
+}
+
+func (o *Intermediate) checkConstraints(c constraints) error {
+	switch {
+	case !c.isCA:
+		return fmt.Errorf("CA basic constraint is required")
+	case c.maxPathLen < 0:
+		return fmt.Errorf("path length constraint is required, maximum is %d", o.MaxPathLen)
+	case c.maxPathLen > o.MaxPathLen:
+		return fmt.Errorf("path length %d is greater than the maximum %d", c.maxPathLen, o.MaxPathLen)
+	case len(c.permittedDNSDomains) == 0:
+		return fmt.Errorf("permitted DNS domain name constraints are required, within %v", o.PermittedDNSDomains)
+	}
+	for _, domain := range c.permittedDNSDomains {
+		if !withinDomains(domain, o.PermittedDNSDomains) {
+			return fmt.Errorf("permitted DNS domain %q is not within %v", domain, o.PermittedDNSDomains)
+		}
+	}
+	return nil
+}
+
+// requestedConstraints parses the basic constraints and name constraints
+// extensions requested by the CSR.
+func requestedConstraints(req *x509.CertificateRequest) (constraints, error) {
+	c := constraints{maxPathLen: -1}
+	for _, ext := range req.Extensions {
+		switch {
+		case ext.Id.Equal(oidExtensionBasicConstraints):
+			var bc struct {
+				IsCA       bool `asn1:"optional"`
+				MaxPathLen int  `asn1:"optional,default:-1"`
+			}
+			if _, err := asn1.Unmarshal(ext.Value, &bc); err != nil {
+				return c, fmt.Errorf("failed to parse requested basic constraints: %v", err)
+			}
+			c.isCA = bc.IsCA
+			c.maxPathLen = bc.MaxPathLen
+		case ext.Id.Equal(oidExtensionNameConstraints):
+			type generalSubtree struct {
+				Name string `asn1:"tag:2,optional,ia5"`
+			}
+			var nc struct {
+				Permitted []generalSubtree `asn1:"optional,tag:0"`
+				Excluded  []generalSubtree `asn1:"optional,tag:1"`
+			}
+			if _, err := asn1.Unmarshal(ext.Value, &nc); err != nil {
+				return c, fmt.Errorf("failed to parse requested name constraints: %v", err)
+			}
+			for _, subtree := range nc.Permitted {
+				if subtree.Name == "" {
+					return c, fmt.Errorf("only DNS name constraints may be requested")
+				}
+				c.permittedDNSDomains = append(c.permittedDNSDomains, subtree.Name)
+			}
+		}
+	}
+	return c, nil
+}
+
+// withinDomains returns true if the name is one of the domains or a subdomain
+// of one of them.
+func withinDomains(name string, domains []string) bool {
+	name = strings.TrimPrefix(name, ".")
+	for _, domain := range domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package policy_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/policy"
+)
+
+// generateCACertificate returns a PEM encoded self-signed CA certificate with
+// the supplied path length and permitted DNS domains, and its parsed form.
+func generateCACertificate(t *testing.T, maxPathLen int, permittedDNSDomains []string) ([]byte, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "intermediate"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            maxPathLen,
+		MaxPathLenZero:        maxPathLen == 0,
+		PermittedDNSDomains:   permittedDNSDomains,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), cert
+}
+
+// generateCACSR returns a CSR which requests the same constraints as a CA
+// certificate with the supplied path length and permitted DNS domains.
+func generateCACSR(t *testing.T, maxPathLen int, permittedDNSDomains []string, isCA string) capi.CertificateSigningRequest {
+	_, cert := generateCACertificate(t, maxPathLen, permittedDNSDomains)
+	var extensions []pkix.Extension
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 19}) || ext.Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 30}) {
+			extensions = append(extensions, ext)
+		}
+	}
+	return capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{capihelper.AnnotationKeyRequestIsCA: isCA},
+		},
+		Spec: capi.CertificateSigningRequestSpec{
+			Request: generateCSR(t, &x509.CertificateRequest{
+				Subject:         pkix.Name{CommonName: "intermediate"},
+				ExtraExtensions: extensions,
+			}),
+		},
+	}
+}
+
+func TestIntermediate_Check(t *testing.T) {
+	p := policy.Intermediate{
+		MaxPathLen:          1,
+		PermittedDNSDomains: []string{"mesh.example.com"},
+	}
+	tests := []struct {
+		name    string
+		csr     capi.CertificateSigningRequest
+		wantErr bool
+	}{
+		{
+			name: "Success",
+			csr:  generateCACSR(t, 0, []string{"ns1.mesh.example.com"}, "true"),
+		},
+		{
+			name:    "ErrorNotCA",
+			csr:     generateCACSR(t, 0, []string{"mesh.example.com"}, "false"),
+			wantErr: true,
+		},
+		{
+			name:    "ErrorPathLenTooLong",
+			csr:     generateCACSR(t, 2, []string{"mesh.example.com"}, "true"),
+			wantErr: true,
+		},
+		{
+			name:    "ErrorPathLenUnconstrained",
+			csr:     generateCACSR(t, -1, []string{"mesh.example.com"}, "true"),
+			wantErr: true,
+		},
+		{
+			name:    "ErrorNoNameConstraints",
+			csr:     generateCACSR(t, 0, nil, "true"),
+			wantErr: true,
+		},
+		{
+			name:    "ErrorNameConstraintNotPermitted",
+			csr:     generateCACSR(t, 0, []string{"example.com"}, "true"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.Check(tt.csr); (err != nil) != tt.wantErr {
+				t.Errorf("Intermediate.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIntermediate_Verify(t *testing.T) {
+	p := policy.Intermediate{
+		MaxPathLen:          1,
+		PermittedDNSDomains: []string{"mesh.example.com"},
+	}
+	csr := generateCACSR(t, 0, []string{"ns1.mesh.example.com"}, "true")
+	tests := []struct {
+		name                string
+		maxPathLen          int
+		permittedDNSDomains []string
+		wantErr             bool
+	}{
+		{
+			name:                "Success",
+			maxPathLen:          0,
+			permittedDNSDomains: []string{"ns1.mesh.example.com"},
+		},
+		{
+			name:                "ErrorPathLenDiffers",
+			maxPathLen:          1,
+			permittedDNSDomains: []string{"ns1.mesh.example.com"},
+			wantErr:             true,
+		},
+		{
+			name:                "ErrorNameConstraintsDiffer",
+			maxPathLen:          0,
+			permittedDNSDomains: []string{"mesh.example.com"},
+			wantErr:             true,
+		},
+		{
+			name:       "ErrorNameConstraintsMissing",
+			maxPathLen: 0,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificate, _ := generateCACertificate(t, tt.maxPathLen, tt.permittedDNSDomains)
+			if err := p.Verify(csr, certificate); (err != nil) != tt.wantErr {
+				t.Errorf("Intermediate.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
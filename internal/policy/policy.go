@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+
+	capi "k8s.io/api/certificates/v1beta1"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// Policy is checked against every CSR immediately before it is signed.
+// Unlike filter.Filter, a Policy is enforced regardless of whether the CSR has
+// been approved, so that a mistaken approval can not lead to the issue of a
+// certificate that this signer must never issue.
+type Policy interface {
+	// Check returns an error if the CSR must not be signed, or an error
+	// wrapping signer.ErrTemporary if the CSR could not be checked, such as
+	// when the API server can not be reached.
+	Check(capi.CertificateSigningRequest) error
+}
+
+// Signer wraps a signer.Signer and refuses to sign any CSR which violates one
+// of the supplied Policies.
+type Signer struct {
+	signer.Signer
+	Policies []Policy
+}
+
+var _ signer.Signer = &Signer{}
+
+// Sign returns an error wrapping signer.ErrPermanent if the CSR violates any
+// of the policies, otherwise it passes the CSR to the wrapped Signer.
+// Temporary errors of the policies are returned as they are, so that the CSR
+// is checked again later.
+func (o *Signer) Sign(csr capi.CertificateSigningRequest) (string, error) {
+	for _, p := range o.Policies {
+		if err := p.Check(csr); err != nil {
+			if errors.Is(err, signer.ErrTemporary) {
+				return "", fmt.Errorf("policy check failed: %w", err)
+			}
+			return "", fmt.Errorf("%w: policy violation: %s", signer.ErrPermanent, err)
+		}
+	}
+	return o.Signer.Sign(csr)
+}
+
+// Verifier is implemented by Policies which also check each certificate after
+// it has been issued, because the signer may not issue exactly what was
+// requested.
+type Verifier interface {
+	// Verify returns an error if the certificate must not be delivered for
+	// the CSR.
+	Verify(csr capi.CertificateSigningRequest, certificate []byte) error
+}
+
+var _ signer.Verifier = &Signer{}
+
+// Verify returns an error wrapping signer.ErrPermanent if the certificate
+// fails the verification of any of the policies which implement Verifier.
+func (o *Signer) Verify(csr capi.CertificateSigningRequest, certificate []byte) error {
+	for _, p := range o.Policies {
+		v, ok := p.(Verifier)
+		if !ok {
+			continue
+		}
+		if err := v.Verify(csr, certificate); err != nil {
+			return fmt.Errorf("%w: verification failed: %s", signer.ErrPermanent, err)
+		}
+	}
+	return nil
+}
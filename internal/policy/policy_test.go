@@ -0,0 +1,84 @@
+package policy_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	"github.com/cert-manager/signer-venafi/internal/policy"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/fake"
+)
+
+// generateCSR returns a PEM encoded CSR for the supplied template, signed by a
+// new ECDSA P-256 key.
+func generateCSR(t *testing.T, tmpl *x509.CertificateRequest) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return generateCSRWithKey(t, tmpl, key)
+}
+
+// generateCSRWithKey returns a PEM encoded CSR for the supplied template,
+// signed by the supplied key.
+func generateCSRWithKey(t *testing.T, tmpl *x509.CertificateRequest, key crypto.Signer) []byte {
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+}
+
+type policyFunc func(capi.CertificateSigningRequest) error
+
+func (f policyFunc) Check(csr capi.CertificateSigningRequest) error { return f(csr) }
+
+func TestSigner_Sign(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		s := &policy.Signer{
+			Signer: &fake.Signer{},
+			Policies: []policy.Policy{
+				policyFunc(func(capi.CertificateSigningRequest) error { return nil }),
+			},
+		}
+		pickupID, err := s.Sign(capi.CertificateSigningRequest{})
+		require.NoError(t, err)
+		assert.NotEmpty(t, pickupID)
+	})
+	t.Run("ErrorPolicyViolation", func(t *testing.T) {
+		s := &policy.Signer{
+			Signer: &fake.Signer{},
+			Policies: []policy.Policy{
+				policyFunc(func(capi.CertificateSigningRequest) error { return errors.New("violation") }),
+			},
+		}
+		_, err := s.Sign(capi.CertificateSigningRequest{})
+		assert.True(t, errors.Is(err, signer.ErrPermanent), "expected ErrPermanent, got %v", err)
+	})
+}
+
+func TestSigner_Verify(t *testing.T) {
+	csr := generateCACSR(t, 0, []string{"mesh.example.com"}, "true")
+	s := &policy.Signer{
+		Signer: &fake.Signer{},
+		Policies: []policy.Policy{
+			policyFunc(func(capi.CertificateSigningRequest) error { return nil }),
+			&policy.Intermediate{PermittedDNSDomains: []string{"mesh.example.com"}},
+		},
+	}
+	t.Run("Success", func(t *testing.T) {
+		certificate, _ := generateCACertificate(t, 0, []string{"mesh.example.com"})
+		assert.NoError(t, s.Verify(csr, certificate))
+	})
+	t.Run("ErrorVerificationFailed", func(t *testing.T) {
+		certificate, _ := generateCACertificate(t, 0, []string{"example.com"})
+		err := s.Verify(csr, certificate)
+		assert.True(t, errors.Is(err, signer.ErrPermanent), "expected ErrPermanent, got %v", err)
+	})
+}
@@ -0,0 +1,73 @@
+package policy
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+)
+
+var (
+	// DefaultAllowedKeyAlgorithms are the public key algorithms supported by
+	// Venafi TPP and Venafi Cloud.
+	DefaultAllowedKeyAlgorithms = []string{"RSA", "ECDSA"}
+	// DefaultMinRSAKeySize is the smallest RSA key size that is allowed.
+	DefaultMinRSAKeySize = 2048
+	// DefaultAllowedCurves are the ECDSA curves that are allowed.
+	DefaultAllowedCurves = []string{"P-256", "P-384", "P-521"}
+	// DefaultAllowedSignatureAlgorithms are the CSR signature algorithms that
+	// are allowed.
+	DefaultAllowedSignatureAlgorithms = []string{
+		"SHA256-RSA", "SHA384-RSA", "SHA512-RSA",
+		"SHA256-RSAPSS", "SHA384-RSAPSS", "SHA512-RSAPSS",
+		"ECDSA-SHA256", "ECDSA-SHA384", "ECDSA-SHA512",
+	}
+)
+
+// PublicKey rejects CSRs with a public key algorithm, key size, curve or
+// signature algorithm that is not allowed, or with an invalid signature.
+// Algorithm and curve names are those returned by the String methods of
+// x509.PublicKeyAlgorithm and x509.SignatureAlgorithm, and by
+// elliptic.CurveParams.Name.
+type PublicKey struct {
+	AllowedAlgorithms          []string
+	MinRSAKeySize              int
+	AllowedCurves              []string
+	AllowedSignatureAlgorithms []string
+}
+
+var _ Policy = &PublicKey{}
+
+func (o *PublicKey) Check(csr capi.CertificateSigningRequest) error {
+	req, err := pki.DecodeX509CertificateRequestBytes(csr.Spec.Request)
+	if err != nil {
+		return fmt.Errorf("failed to decode CSR: %v", err)
+	}
+
+	if algorithm := req.PublicKeyAlgorithm.String(); !capihelper.Contains(o.AllowedAlgorithms, algorithm) {
+		return fmt.Errorf("public key algorithm %s is not allowed, allowed algorithms are %v", algorithm, o.AllowedAlgorithms)
+	}
+	if algorithm := req.SignatureAlgorithm.String(); !capihelper.Contains(o.AllowedSignatureAlgorithms, algorithm) {
+		return fmt.Errorf("signature algorithm %s is not allowed, allowed algorithms are %v", algorithm, o.AllowedSignatureAlgorithms)
+	}
+
+	switch pub := req.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if size := pub.N.BitLen(); size < o.MinRSAKeySize {
+			return fmt.Errorf("RSA key size %d is smaller than the minimum %d", size, o.MinRSAKeySize)
+		}
+	case *ecdsa.PublicKey:
+		if curve := pub.Curve.Params().Name; !capihelper.Contains(o.AllowedCurves, curve) {
+			return fmt.Errorf("ECDSA curve %s is not allowed, allowed curves are %v", curve, o.AllowedCurves)
+		}
+	}
+
+	if err := req.CheckSignature(); err != nil {
+		return fmt.Errorf("invalid CSR signature: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package policy_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	"github.com/cert-manager/signer-venafi/internal/policy"
+)
+
+func TestPublicKey_Check(t *testing.T) {
+	defaultPolicy := policy.PublicKey{
+		AllowedAlgorithms:          policy.DefaultAllowedKeyAlgorithms,
+		MinRSAKeySize:              policy.DefaultMinRSAKeySize,
+		AllowedCurves:              policy.DefaultAllowedCurves,
+		AllowedSignatureAlgorithms: policy.DefaultAllowedSignatureAlgorithms,
+	}
+	tmpl := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "foo"}}
+
+	rsaKey := func(bits int) crypto.Signer {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		require.NoError(t, err)
+		return key
+	}
+	ecdsaKey := func(curve elliptic.Curve) crypto.Signer {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		require.NoError(t, err)
+		return key
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		policy  policy.PublicKey
+		request []byte
+		wantErr bool
+	}{
+		{
+			name:    "SuccessRSA",
+			policy:  defaultPolicy,
+			request: generateCSRWithKey(t, tmpl, rsaKey(2048)),
+		},
+		{
+			name:    "SuccessECDSA",
+			policy:  defaultPolicy,
+			request: generateCSRWithKey(t, tmpl, ecdsaKey(elliptic.P384())),
+		},
+		{
+			name:    "ErrorRSAKeyTooSmall",
+			policy:  defaultPolicy,
+			request: generateCSRWithKey(t, tmpl, rsaKey(1024)),
+			wantErr: true,
+		},
+		{
+			name:    "ErrorCurveNotAllowed",
+			policy:  defaultPolicy,
+			request: generateCSRWithKey(t, tmpl, ecdsaKey(elliptic.P224())),
+			wantErr: true,
+		},
+		{
+			name:    "ErrorAlgorithmNotAllowed",
+			policy:  defaultPolicy,
+			request: generateCSRWithKey(t, tmpl, ed25519Key),
+			wantErr: true,
+		},
+		{
+			name: "ErrorSignatureAlgorithmNotAllowed",
+			policy: policy.PublicKey{
+				AllowedAlgorithms:          policy.DefaultAllowedKeyAlgorithms,
+				AllowedCurves:              policy.DefaultAllowedCurves,
+				AllowedSignatureAlgorithms: []string{"ECDSA-SHA512"},
+			},
+			request: generateCSRWithKey(t, tmpl, ecdsaKey(elliptic.P256())),
+			wantErr: true,
+		},
+		{
+			name:    "ErrorInvalidSignature",
+			policy:  defaultPolicy,
+			request: corruptSignature(t, generateCSRWithKey(t, tmpl, rsaKey(2048))),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := capi.CertificateSigningRequest{
+				Spec: capi.CertificateSigningRequestSpec{
+					Request: tt.request,
+				},
+			}
+			if err := tt.policy.Check(csr); (err != nil) != tt.wantErr {
+				t.Errorf("PublicKey.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// corruptSignature flips the last bit of the signature of a PEM encoded CSR.
+func corruptSignature(t *testing.T, csrPEM []byte) []byte {
+	block, _ := pem.Decode(csrPEM)
+	require.NotNil(t, block)
+	block.Bytes[len(block.Bytes)-1] ^= 1
+	return pem.EncodeToMemory(block)
+}
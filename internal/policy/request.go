@@ -0,0 +1,48 @@
+package policy
+
+import (
+	"fmt"
+	"time"
+
+	capi "k8s.io/api/certificates/v1beta1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+)
+
+// Request validates the certificate properties requested by the cert-manager
+// experimental CSR annotations.
+// The requested duration must be between MinDuration and MaxDuration, where
+// zero means no limit.
+// A CA certificate may only be requested from the signer names in
+// CASignerNames, which are configured for intermediate issuance.
+type Request struct {
+	MinDuration   time.Duration
+	MaxDuration   time.Duration
+	CASignerNames []string
+}
+
+var _ Policy = &Request{}
+
+func (o *Request) Check(csr capi.CertificateSigningRequest) error {
+	duration, err := capihelper.GetRequestDuration(&csr)
+	if err != nil {
+		return err
+	}
+	if duration != 0 {
+		if o.MinDuration > 0 && duration < o.MinDuration {
+			return fmt.Errorf("requested duration %s is shorter than the minimum %s", duration, o.MinDuration)
+		}
+		if o.MaxDuration > 0 && duration > o.MaxDuration {
+			return fmt.Errorf("requested duration %s is longer than the maximum %s", duration, o.MaxDuration)
+		}
+	}
+
+	isCA, err := capihelper.GetRequestIsCA(&csr)
+	if err != nil {
+		return err
+	}
+	if isCA && (csr.Spec.SignerName == nil || !capihelper.Contains(o.CASignerNames, *csr.Spec.SignerName)) {
+		return fmt.Errorf("CA certificates may only be requested from signer names %v", o.CASignerNames)
+	}
+	return nil
+}
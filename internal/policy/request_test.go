@@ -0,0 +1,95 @@
+package policy_test
+
+import (
+	"testing"
+	"time"
+
+	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/policy"
+)
+
+func TestRequest_Check(t *testing.T) {
+	const (
+		signerName   = "example.com/foo"
+		caSignerName = "example.com/intermediate"
+	)
+	p := policy.Request{
+		MinDuration:   time.Hour,
+		MaxDuration:   90 * 24 * time.Hour,
+		CASignerNames: []string{caSignerName},
+	}
+	tests := []struct {
+		name        string
+		signerName  string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{
+			name:       "SuccessNoAnnotations",
+			signerName: signerName,
+		},
+		{
+			name:        "SuccessDuration",
+			signerName:  signerName,
+			annotations: map[string]string{capihelper.AnnotationKeyRequestDuration: "2160h"},
+		},
+		{
+			name:        "ErrorDurationTooShort",
+			signerName:  signerName,
+			annotations: map[string]string{capihelper.AnnotationKeyRequestDuration: "30m"},
+			wantErr:     true,
+		},
+		{
+			name:        "ErrorDurationTooLong",
+			signerName:  signerName,
+			annotations: map[string]string{capihelper.AnnotationKeyRequestDuration: "2161h"},
+			wantErr:     true,
+		},
+		{
+			name:        "ErrorDurationInvalid",
+			signerName:  signerName,
+			annotations: map[string]string{capihelper.AnnotationKeyRequestDuration: "90d"},
+			wantErr:     true,
+		},
+		{
+			name:        "SuccessNotCA",
+			signerName:  signerName,
+			annotations: map[string]string{capihelper.AnnotationKeyRequestIsCA: "false"},
+		},
+		{
+			name:        "ErrorCANotAllowed",
+			signerName:  signerName,
+			annotations: map[string]string{capihelper.AnnotationKeyRequestIsCA: "true"},
+			wantErr:     true,
+		},
+		{
+			name:        "SuccessCAAllowed",
+			signerName:  caSignerName,
+			annotations: map[string]string{capihelper.AnnotationKeyRequestIsCA: "true"},
+		},
+		{
+			name:        "ErrorIsCAInvalid",
+			signerName:  caSignerName,
+			annotations: map[string]string{capihelper.AnnotationKeyRequestIsCA: "yes"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signerName := tt.signerName
+			csr := capi.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+				Spec: capi.CertificateSigningRequestSpec{
+					SignerName: &signerName,
+					Request:    []byte(sampleCSR),
+				},
+			}
+			if err := p.Check(csr); (err != nil) != tt.wantErr {
+				t.Errorf("Request.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	capi "k8s.io/api/certificates/v1beta1"
+)
+
+const serviceAccountUsernamePrefix = "system:serviceaccount:"
+
+// SPIFFE only allows CSRs for X.509 SPIFFE Verifiable Identity Documents
+// (SVIDs) whose SPIFFE ID matches the ServiceAccount which created the CSR.
+// See https://github.com/spiffe/spiffe/blob/master/standards/X509-SVID.md
+type SPIFFE struct {
+	// TrustDomain is the trust domain of all SPIFFE IDs issued by this signer.
+	TrustDomain string
+}
+
+var _ Policy = &SPIFFE{}
+
+func (o *SPIFFE) Check(csr capi.CertificateSigningRequest) error {
+	namespace, name, err := splitServiceAccountUsername(csr.Spec.Username)
+	if err != nil {
+		return err
+	}
+
+	req, err := pki.DecodeX509CertificateRequestBytes(csr.Spec.Request)
+	if err != nil {
+		return fmt.Errorf("failed to decode CSR: %v", err)
+	}
+
+	switch {
+	case len(req.DNSNames) > 0:
+		return fmt.Errorf("CSR must not contain DNS names: %v", req.DNSNames)
+	case len(req.IPAddresses) > 0:
+		return fmt.Errorf("CSR must not contain IP addresses: %v", req.IPAddresses)
+	case len(req.EmailAddresses) > 0:
+		return fmt.Errorf("CSR must not contain email addresses: %v", req.EmailAddresses)
+	case len(req.URIs) != 1:
+		return fmt.Errorf("CSR must contain exactly one URI, found %d", len(req.URIs))
+	}
+
+	expected := fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", o.TrustDomain, namespace, name)
+	if actual := req.URIs[0].String(); actual != expected {
+		return fmt.Errorf("CSR SPIFFE ID %q does not match the requester, expected %q", actual, expected)
+	}
+	return nil
+}
+
+// splitServiceAccountUsername returns the namespace and name from a
+// ServiceAccount username of the form system:serviceaccount:<ns>:<name>.
+func splitServiceAccountUsername(username string) (namespace, name string, err error) {
+	if !strings.HasPrefix(username, serviceAccountUsernamePrefix) {
+		return "", "", fmt.Errorf("CSR requester %q is not a ServiceAccount", username)
+	}
+	parts := strings.Split(strings.TrimPrefix(username, serviceAccountUsernamePrefix), ":")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("CSR requester %q is not a valid ServiceAccount username", username)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,111 @@
+package policy_test
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"testing"
+
+	capi "k8s.io/api/certificates/v1beta1"
+
+	"github.com/cert-manager/signer-venafi/internal/policy"
+)
+
+func TestSPIFFE_Check(t *testing.T) {
+	const username = "system:serviceaccount:ns1:sa1"
+	uri := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	tests := []struct {
+		name     string
+		username string
+		tmpl     *x509.CertificateRequest
+		wantErr  bool
+	}{
+		{
+			name:     "Success",
+			username: username,
+			tmpl: &x509.CertificateRequest{
+				URIs: []*url.URL{uri("spiffe://cluster.local/ns/ns1/sa/sa1")},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "ErrorNotServiceAccount",
+			username: "system:node:node1",
+			tmpl: &x509.CertificateRequest{
+				URIs: []*url.URL{uri("spiffe://cluster.local/ns/ns1/sa/sa1")},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "ErrorNamespaceMismatch",
+			username: "system:serviceaccount:ns2:sa1",
+			tmpl: &x509.CertificateRequest{
+				URIs: []*url.URL{uri("spiffe://cluster.local/ns/ns1/sa/sa1")},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "ErrorTrustDomainMismatch",
+			username: username,
+			tmpl: &x509.CertificateRequest{
+				URIs: []*url.URL{uri("spiffe://example.com/ns/ns1/sa/sa1")},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "ErrorNoURI",
+			username: username,
+			tmpl:     &x509.CertificateRequest{},
+			wantErr:  true,
+		},
+		{
+			name:     "ErrorMultipleURIs",
+			username: username,
+			tmpl: &x509.CertificateRequest{
+				URIs: []*url.URL{
+					uri("spiffe://cluster.local/ns/ns1/sa/sa1"),
+					uri("spiffe://cluster.local/ns/ns1/sa/sa1"),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "ErrorDNSName",
+			username: username,
+			tmpl: &x509.CertificateRequest{
+				DNSNames: []string{"example.com"},
+				URIs:     []*url.URL{uri("spiffe://cluster.local/ns/ns1/sa/sa1")},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "ErrorIPAddress",
+			username: username,
+			tmpl: &x509.CertificateRequest{
+				IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+				URIs:        []*url.URL{uri("spiffe://cluster.local/ns/ns1/sa/sa1")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := capi.CertificateSigningRequest{
+				Spec: capi.CertificateSigningRequestSpec{
+					Username: tt.username,
+					Request:  generateCSR(t, tt.tmpl),
+				},
+			}
+			o := &policy.SPIFFE{TrustDomain: "cluster.local"}
+			if err := o.Check(csr); (err != nil) != tt.wantErr {
+				t.Errorf("SPIFFE.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
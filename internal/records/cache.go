@@ -0,0 +1,33 @@
+package records
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// NewCache returns a cache of the objects in the namespace of the issuance
+// records, which is started by the manager, and a client which reads from
+// it. Unlike the cache of the manager, it does not list or watch the
+// ConfigMaps of every namespace, so the signer only needs access to the
+// ConfigMaps of the records namespace.
+func NewCache(mgr manager.Manager, namespace string) (cache.Cache, client.Client, error) {
+	c, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme:    mgr.GetScheme(),
+		Mapper:    mgr.GetRESTMapper(),
+		Namespace: namespace,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating issuance record cache: %v", err)
+	}
+	if err := mgr.Add(c); err != nil {
+		return nil, nil, fmt.Errorf("error adding issuance record cache: %v", err)
+	}
+	return c, &client.DelegatingClient{
+		Reader:       c,
+		Writer:       mgr.GetClient(),
+		StatusClient: mgr.GetClient(),
+	}, nil
+}
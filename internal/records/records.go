@@ -0,0 +1,142 @@
+// Package records stores a record of the certificates issued by the signer for
+// each identity, independently of the CSRs, which may be deleted soon after
+// they are signed.
+// The records for each identity are stored in a ConfigMap.
+package records
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// LabelKeyIssuanceRecord is the label which identifies issuance record
+	// ConfigMaps.
+	LabelKeyIssuanceRecord = "signer-venafi.cert-manager.io/issuance-record"
+	// AnnotationKeySignerName records the signer name of the identity of an
+	// issuance record ConfigMap.
+	AnnotationKeySignerName = "signer-venafi.cert-manager.io/signer-name"
+	// The annotation which records the subject of the identity of an
+	// issuance record ConfigMap, for the benefit of humans.
+	annotationKeySubject = "signer-venafi.cert-manager.io/subject"
+	// The ConfigMap data key which holds the JSON encoded list of Records
+	dataKeyRecords = "records"
+)
+
+// Record describes a certificate issued for an identity.
+type Record struct {
+	// PickupID is the signer pickup ID of the certificate.
+	PickupID string `json:"pickupID"`
+	// CSRName is the name of the CSR for which the certificate was issued.
+	CSRName string `json:"csrName"`
+	// Issued is the time at which the certificate was picked up.
+	Issued time.Time `json:"issued"`
+	// NotAfter is the expiry time of the certificate.
+	NotAfter time.Time `json:"notAfter"`
+	// Revoked is the time at which the certificate was revoked, if it has
+	// been revoked.
+	Revoked *time.Time `json:"revoked,omitempty"`
+	// RevocationFailed is the error which permanently prevented the
+	// certificate from being revoked, if any, e.g. because the CA does not
+	// know the certificate.
+	RevocationFailed string `json:"revocationFailed,omitempty"`
+}
+
+// Store reads and writes issuance record ConfigMaps in a single namespace.
+type Store struct {
+	Client    client.Client
+	Namespace string
+}
+
+// Add adds a record for a certificate issued to the subject by the signer.
+func (o *Store) Add(ctx context.Context, signerName, subject string, record Record) error {
+	key := client.ObjectKey{Namespace: o.Namespace, Name: Name(signerName, subject)}
+
+	var cm corev1.ConfigMap
+	err := o.Client.Get(ctx, key, &cm)
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("error getting issuance record: %v", err)
+	}
+	if err != nil {
+		cm = corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: key.Namespace,
+				Name:      key.Name,
+				Labels: map[string]string{
+					LabelKeyIssuanceRecord: "true",
+				},
+				Annotations: map[string]string{
+					AnnotationKeySignerName: signerName,
+					annotationKeySubject:    subject,
+				},
+			},
+		}
+		if err := Encode(&cm, []Record{record}); err != nil {
+			return err
+		}
+		if err := o.Client.Create(ctx, &cm); err != nil {
+			return fmt.Errorf("error creating issuance record: %v", err)
+		}
+		return nil
+	}
+
+	records, err := Decode(&cm)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if r.PickupID == record.PickupID {
+			return nil
+		}
+	}
+	if err := Encode(&cm, append(records, record)); err != nil {
+		return err
+	}
+	if err := o.Client.Update(ctx, &cm); err != nil {
+		return fmt.Errorf("error updating issuance record: %v", err)
+	}
+	return nil
+}
+
+// Name returns the name of the issuance record ConfigMap for an identity.
+func Name(signerName, subject string) string {
+	sum := sha256.Sum256([]byte(signerName + "\n" + subject))
+	return "issued-" + hex.EncodeToString(sum[:])[:32]
+}
+
+// Decode returns the records stored in an issuance record ConfigMap, sorted by
+// issue time.
+func Decode(cm *corev1.ConfigMap) ([]Record, error) {
+	var records []Record
+	if data := cm.Data[dataKeyRecords]; data != "" {
+		if err := json.Unmarshal([]byte(data), &records); err != nil {
+			return nil, fmt.Errorf("error decoding issuance record %s/%s: %v", cm.Namespace, cm.Name, err)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Issued.Before(records[j].Issued)
+	})
+	return records, nil
+}
+
+// Encode stores the records in an issuance record ConfigMap.
+func Encode(cm *corev1.ConfigMap, records []Record) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("error encoding issuance record: %v", err)
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[dataKeyRecords] = string(data)
+	return nil
+}
@@ -0,0 +1,56 @@
+package records_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/cert-manager/signer-venafi/internal/records"
+)
+
+func TestStore_Add(t *testing.T) {
+	const (
+		signerName = "example.com/foo"
+		subject    = "CN=system:node:node1,O=system:nodes"
+	)
+	ctx := context.Background()
+	cl := fake.NewFakeClientWithScheme(clientgoscheme.Scheme)
+	s := &records.Store{Client: cl, Namespace: "ns1"}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	second := records.Record{PickupID: "id2", CSRName: "csr2", Issued: now, NotAfter: now.Add(time.Hour)}
+	first := records.Record{PickupID: "id1", CSRName: "csr1", Issued: now.Add(-time.Minute), NotAfter: now.Add(time.Hour)}
+
+	require.NoError(t, s.Add(ctx, signerName, subject, second))
+	require.NoError(t, s.Add(ctx, signerName, subject, first))
+	// Adding the same pickup ID again has no effect.
+	require.NoError(t, s.Add(ctx, signerName, subject, first))
+
+	var cm corev1.ConfigMap
+	key := client.ObjectKey{Namespace: "ns1", Name: records.Name(signerName, subject)}
+	require.NoError(t, cl.Get(ctx, key, &cm))
+	assert.Equal(t, "true", cm.Labels[records.LabelKeyIssuanceRecord])
+	assert.Equal(t, signerName, cm.Annotations[records.AnnotationKeySignerName])
+
+	actual, err := records.Decode(&cm)
+	require.NoError(t, err)
+	assert.Equal(t, []records.Record{first, second}, actual)
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t,
+		records.Name("example.com/foo", "CN=a"),
+		records.Name("example.com/foo", "CN=a"),
+	)
+	assert.NotEqual(t,
+		records.Name("example.com/foo", "CN=a"),
+		records.Name("example.com/bar", "CN=a"),
+	)
+}
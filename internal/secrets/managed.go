@@ -0,0 +1,66 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The annotations and data keys of the Secrets whose private key and
+// certificate are managed by the signer, and of the CSRs created for them.
+const (
+	// AnnotationKeyManagedSecret is the CSR annotation which records the
+	// Secret for which the CSR was created, in the form <namespace>/<name>.
+	AnnotationKeyManagedSecret = "signer-venafi.cert-manager.io/managed-secret"
+	// AnnotationKeyPendingCSR is the Secret annotation which records the
+	// name of the pending CSR.
+	AnnotationKeyPendingCSR = "signer-venafi.cert-manager.io/csr-name"
+	// DataKeyPendingKey is the Secret data key which holds the private key
+	// of the pending CSR.
+	DataKeyPendingKey = "tls-pending.key"
+)
+
+// ManagedSecretNamespace returns the namespace of the managed Secret for which
+// the CSR was created, and true, if that Secret names the CSR as its pending
+// CSR and holds the private key of the CSR.
+// CSRs for managed Secrets are created by the signer, so their requester is
+// the signer. Only a user who is allowed to update the Secret can make it
+// name a CSR, so the CSR is requested on behalf of the namespace of the
+// Secret.
+func ManagedSecretNamespace(ctx context.Context, reader client.Reader, csr capi.CertificateSigningRequest) (string, bool, error) {
+	parts := strings.Split(csr.Annotations[AnnotationKeyManagedSecret], "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false, nil
+	}
+	key := client.ObjectKey{Namespace: parts[0], Name: parts[1]}
+
+	var secret corev1.Secret
+	err := reader.Get(ctx, key, &secret)
+	switch {
+	case client.IgnoreNotFound(err) == nil && err != nil:
+		return "", false, nil
+	case err != nil:
+		return "", false, fmt.Errorf("error getting managed secret: %v", err)
+	}
+	if secret.Annotations[AnnotationKeyPendingCSR] != csr.Name {
+		return "", false, nil
+	}
+
+	privateKey, err := pki.DecodePrivateKeyBytes(secret.Data[DataKeyPendingKey])
+	if err != nil {
+		return "", false, nil
+	}
+	req, err := pki.DecodeX509CertificateRequestBytes(csr.Spec.Request)
+	if err != nil {
+		return "", false, nil
+	}
+	if ok, err := pki.PublicKeyMatchesCSR(privateKey.Public(), req); err != nil || !ok {
+		return "", false, nil
+	}
+	return key.Namespace, true, nil
+}
@@ -0,0 +1,161 @@
+// Package secrets writes certificates issued for CSRs into Secrets, for
+// consumers which can not read the CSR status.
+package secrets
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+)
+
+const (
+	// AnnotationKeySecret is the CSR annotation which names the Secret, in
+	// the form <namespace>/<name>, into which the certificate is written.
+	AnnotationKeySecret = "signer-venafi.cert-manager.io/secret"
+	// LabelKeyCSRName labels each Secret with the name of the CSR whose
+	// certificate it contains.
+	LabelKeyCSRName = "signer-venafi.cert-manager.io/csr-name"
+	// The Secret data keys
+	dataKeyCertificate = "tls.crt"
+	dataKeyCA          = "ca.crt"
+)
+
+// ErrNotPermitted is wrapped by errors returned by Writer.Write if the Secret
+// can not be written because the CSR requester is not allowed to write it, or
+// because it is not managed by the signer.
+var ErrNotPermitted = errors.New("not permitted")
+
+// Writer writes certificates into the Secrets named by the CSR annotation.
+type Writer struct {
+	Client client.Client
+	// Reader is used to read Secrets, so that the Secrets in all namespaces
+	// need not be cached.
+	Reader client.Reader
+}
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;create;update
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// Write writes the certificate into the Secret named by the CSR annotation, if
+// the CSR has that annotation.
+// The certificate is written to tls.crt. Any certificates following the leaf
+// certificate are also written to ca.crt. The other keys of an existing Secret
+// are not changed.
+func (o *Writer) Write(ctx context.Context, csr capi.CertificateSigningRequest, certificate []byte) error {
+	value := csr.Annotations[AnnotationKeySecret]
+	if value == "" {
+		return nil
+	}
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("%w: invalid %s annotation %q, expected <namespace>/<name>", ErrNotPermitted, AnnotationKeySecret, value)
+	}
+	key := client.ObjectKey{Namespace: parts[0], Name: parts[1]}
+
+	var secret corev1.Secret
+	err := o.Reader.Get(ctx, key, &secret)
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("error getting secret: %v", err)
+	}
+	exists := err == nil
+
+	verb := "create"
+	if exists {
+		verb = "update"
+		if secret.Labels[capihelper.LabelKeyManagedBy] != capihelper.LabelValueManagedBy {
+			return fmt.Errorf("%w: secret %s is not managed by signer-venafi", ErrNotPermitted, key)
+		}
+	}
+	if err := o.authorize(ctx, csr, key, verb); err != nil {
+		return err
+	}
+
+	secret.Namespace = key.Namespace
+	secret.Name = key.Name
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	secret.Labels[capihelper.LabelKeyManagedBy] = capihelper.LabelValueManagedBy
+	secret.Labels[LabelKeyCSRName] = csr.Name
+	// Only the keys written by the signer are changed, so that other keys,
+	// such as the private key, are kept.
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[dataKeyCertificate] = certificate
+	if ca := caCertificates(certificate); len(ca) > 0 {
+		secret.Data[dataKeyCA] = ca
+	} else {
+		delete(secret.Data, dataKeyCA)
+	}
+
+	if exists {
+		err = o.Client.Update(ctx, &secret)
+	} else {
+		err = o.Client.Create(ctx, &secret)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing secret: %v", err)
+	}
+	return nil
+}
+
+// authorize returns an error wrapping ErrNotPermitted unless the requester of
+// the CSR is allowed to perform the verb on the Secret.
+func (o *Writer) authorize(ctx context.Context, csr capi.CertificateSigningRequest, key client.ObjectKey, verb string) error {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range csr.Spec.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	name := key.Name
+	if verb == "create" {
+		// The name is not known to the authorizer when creating resources.
+		name = ""
+	}
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   csr.Spec.Username,
+			Groups: csr.Spec.Groups,
+			UID:    csr.Spec.UID,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: key.Namespace,
+				Name:      name,
+				Verb:      verb,
+				Resource:  "secrets",
+			},
+		},
+	}
+	if err := o.Client.Create(ctx, sar); err != nil {
+		return fmt.Errorf("error creating subject access review: %v", err)
+	}
+	if !sar.Status.Allowed {
+		return fmt.Errorf("%w: requester %q may not %s secret %s", ErrNotPermitted, csr.Spec.Username, verb, key)
+	}
+	return nil
+}
+
+// caCertificates returns the PEM encoded certificates following the first
+// certificate in the PEM data.
+func caCertificates(certificate []byte) []byte {
+	var ca []byte
+	for first := true; ; first = false {
+		var block *pem.Block
+		block, certificate = pem.Decode(certificate)
+		if block == nil {
+			return ca
+		}
+		if !first {
+			ca = append(ca, pem.EncodeToMemory(block)...)
+		}
+	}
+}
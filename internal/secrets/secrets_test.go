@@ -0,0 +1,156 @@
+package secrets_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/secrets"
+)
+
+const (
+	sampleLeaf = "-----BEGIN CERTIFICATE-----\nbGVhZg==\n-----END CERTIFICATE-----\n"
+	sampleCA   = "-----BEGIN CERTIFICATE-----\nY2E=\n-----END CERTIFICATE-----\n"
+)
+
+// sarClient answers SubjectAccessReviews with a fixed decision.
+type sarClient struct {
+	client.Client
+	allowed bool
+}
+
+func (o *sarClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if sar, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+		sar.Status.Allowed = o.allowed
+		return nil
+	}
+	return o.Client.Create(ctx, obj, opts...)
+}
+
+// TestWriter_WriteKeepsOtherKeys verifies that only the keys written by the
+// signer are changed in an existing Secret.
+func TestWriter_WriteKeepsOtherKeys(t *testing.T) {
+	ctx := context.Background()
+	cl := fake.NewFakeClientWithScheme(clientgoscheme.Scheme, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns1",
+			Name:      "secret1",
+			Labels:    map[string]string{capihelper.LabelKeyManagedBy: capihelper.LabelValueManagedBy},
+		},
+		Data: map[string][]byte{
+			"tls.key": []byte("key"),
+			"tls.crt": []byte("old certificate"),
+			"ca.crt":  []byte("old CA"),
+		},
+	})
+	w := &secrets.Writer{
+		Client: &sarClient{Client: cl, allowed: true},
+		Reader: cl,
+	}
+	csr := capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "csr1",
+			Annotations: map[string]string{secrets.AnnotationKeySecret: "ns1/secret1"},
+		},
+	}
+	require.NoError(t, w.Write(ctx, csr, []byte(sampleLeaf)))
+
+	var secret corev1.Secret
+	require.NoError(t, cl.Get(ctx, client.ObjectKey{Namespace: "ns1", Name: "secret1"}, &secret))
+	assert.Equal(t, map[string][]byte{
+		"tls.key": []byte("key"),
+		"tls.crt": []byte(sampleLeaf),
+	}, secret.Data, "the stale ca.crt should be removed and tls.key kept")
+}
+
+func TestWriter_Write(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		existing   []runtime.Object
+		allowed    bool
+		wantErr    error
+	}{
+		{
+			name:       "SuccessCreate",
+			annotation: "ns1/secret1",
+			allowed:    true,
+		},
+		{
+			name:       "SuccessUpdate",
+			annotation: "ns1/secret1",
+			existing: []runtime.Object{
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "secret1",
+					Labels:    map[string]string{capihelper.LabelKeyManagedBy: capihelper.LabelValueManagedBy},
+				}},
+			},
+			allowed: true,
+		},
+		{
+			name:       "ErrorNotAllowed",
+			annotation: "ns1/secret1",
+			allowed:    false,
+			wantErr:    secrets.ErrNotPermitted,
+		},
+		{
+			name:       "ErrorNotManaged",
+			annotation: "ns1/secret1",
+			existing: []runtime.Object{
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "secret1"}},
+			},
+			allowed: true,
+			wantErr: secrets.ErrNotPermitted,
+		},
+		{
+			name:       "ErrorInvalidAnnotation",
+			annotation: "secret1",
+			allowed:    true,
+			wantErr:    secrets.ErrNotPermitted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cl := fake.NewFakeClientWithScheme(clientgoscheme.Scheme, tt.existing...)
+			w := &secrets.Writer{
+				Client: &sarClient{Client: cl, allowed: tt.allowed},
+				Reader: cl,
+			}
+			csr := capi.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "csr1",
+					Annotations: map[string]string{secrets.AnnotationKeySecret: tt.annotation},
+				},
+				Spec: capi.CertificateSigningRequestSpec{
+					Username: "system:serviceaccount:ns1:sa1",
+				},
+			}
+			err := w.Write(ctx, csr, []byte(sampleLeaf+sampleCA))
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var secret corev1.Secret
+			require.NoError(t, cl.Get(ctx, client.ObjectKey{Namespace: "ns1", Name: "secret1"}, &secret))
+			assert.Equal(t, sampleLeaf+sampleCA, string(secret.Data["tls.crt"]))
+			assert.Equal(t, sampleCA, string(secret.Data["ca.crt"]))
+			assert.Equal(t, "csr1", secret.Labels[secrets.LabelKeyCSRName])
+		})
+	}
+}
@@ -0,0 +1,302 @@
+// Package composite implements a signer.Signer which spreads CSRs across
+// several instances of a backend, such as Venafi TPP clusters in different
+// datacenters, failing over from instances which are unavailable.
+package composite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	"github.com/cert-manager/signer-venafi/internal/metrics"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// Mode is the way in which a Signer chooses the instance to which each CSR is
+// sent.
+type Mode string
+
+const (
+	// ModeFailover sends each CSR to the first healthy instance.
+	ModeFailover Mode = "failover"
+	// ModeRoundRobin spreads the CSRs across the healthy instances, in
+	// proportion to their weights.
+	ModeRoundRobin Mode = "round-robin"
+)
+
+// Modes are the supported modes.
+var Modes = []Mode{ModeFailover, ModeRoundRobin}
+
+// The time for which an instance is skipped after it fails, if
+// Signer.Cooldown is not set
+const defaultCooldown = time.Minute
+
+// The prefix of tagged pickup IDs, and the separator between the instance
+// name and the pickup ID of the instance. Pickup IDs of the backends, such as
+// Vault serial numbers, may contain the separator, so only the IDs with the
+// prefix are tagged.
+const (
+	tagPrefix    = "composite:"
+	tagSeparator = ":"
+)
+
+// Instance is one of the signers of a composite Signer.
+type Instance struct {
+	// Name identifies the instance in the pickup IDs returned by Sign, so
+	// it must not change while any CSR is waiting to be picked up.
+	Name   string
+	Signer signer.Signer
+	// Weight is the share of the CSRs sent to the instance in round-robin
+	// mode. Zero means 1.
+	Weight int
+}
+
+// Signer implements signer.Signer by sending each CSR to one of its
+// Instances. An instance which fails to sign a CSR, other than with
+// signer.ErrPermanent, is unhealthy for the Cooldown and the CSR is sent to
+// the next instance. Unhealthy instances are only used if every instance is
+// unhealthy.
+//
+// Each pickup ID is tagged with the name of the instance which accepted the
+// CSR, so that the certificate is always picked up from that instance.
+//
+// Signer is not a signer.Revoker, because its instances may not be able to
+// revoke. Use Revoking to get a RevokingSigner if they all are.
+type Signer struct {
+	Instances []Instance
+	Mode      Mode
+	// Cooldown is the time for which an instance is skipped after it fails.
+	Cooldown   time.Duration
+	SignerName string
+	Log        logr.Logger
+
+	mu sync.Mutex
+	// unhealthyUntil is the time until which each instance is skipped.
+	unhealthyUntil map[string]time.Time
+	// current is the current weight of each instance in the smooth weighted
+	// round-robin.
+	current map[string]int
+}
+
+// RevokingSigner is a Signer whose instances are all signer.Revokers.
+type RevokingSigner struct {
+	*Signer
+}
+
+var (
+	_ signer.Signer          = &Signer{}
+	_ signer.HealthChecker   = &Signer{}
+	_ signer.DurationIgnorer = &Signer{}
+	_ signer.Revoker         = &RevokingSigner{}
+)
+
+// Validate returns the reasons why the instances are not usable.
+func (o *Signer) Validate() []error {
+	var errs []error
+	if len(o.Instances) == 0 {
+		errs = append(errs, fmt.Errorf("at least one instance is required"))
+	}
+	seen := map[string]bool{}
+	for i, instance := range o.Instances {
+		switch {
+		case instance.Name == "":
+			errs = append(errs, fmt.Errorf("instances[%d]: name is required", i))
+		case strings.Contains(instance.Name, tagSeparator):
+			errs = append(errs, fmt.Errorf("instances[%d]: name %q must not contain %q", i, instance.Name, tagSeparator))
+		case seen[instance.Name]:
+			errs = append(errs, fmt.Errorf("instances[%d]: name %q is used more than once", i, instance.Name))
+		}
+		if instance.Weight < 0 {
+			errs = append(errs, fmt.Errorf("instances[%d]: weight must not be negative", i))
+		}
+		seen[instance.Name] = true
+	}
+	switch o.Mode {
+	case ModeFailover, ModeRoundRobin:
+	default:
+		errs = append(errs, fmt.Errorf("mode must be one of %v", Modes))
+	}
+	return errs
+}
+
+// Revoking returns the Signer as a RevokingSigner, or an error naming the
+// first instance which is not a signer.Revoker.
+func (o *Signer) Revoking() (*RevokingSigner, error) {
+	for _, instance := range o.Instances {
+		if _, ok := instance.Signer.(signer.Revoker); !ok {
+			return nil, fmt.Errorf("instance %q does not support revocation", instance.Name)
+		}
+	}
+	return &RevokingSigner{Signer: o}, nil
+}
+
+func (o *Signer) Sign(csr capi.CertificateSigningRequest) (string, error) {
+	log := o.Log.WithName("Sign")
+
+	var errs []string
+	for _, instance := range o.candidates() {
+		log.V(1).Info("Sending CSR to instance", "instance", instance.Name)
+		pickupID, err := instance.Signer.Sign(csr)
+		if err == nil {
+			o.setHealthy(instance.Name, true)
+			return tagPrefix + instance.Name + tagSeparator + pickupID, nil
+		}
+		if errors.Is(err, signer.ErrPermanent) {
+			// The CSR would be refused by every instance.
+			o.setHealthy(instance.Name, true)
+			return "", err
+		}
+		log.Error(err, "Instance failed, trying the next instance", "instance", instance.Name)
+		o.setHealthy(instance.Name, false)
+		errs = append(errs, fmt.Sprintf("%s: %v", instance.Name, err))
+	}
+	return "", fmt.Errorf("%w: every instance failed: %s", signer.ErrTemporary, strings.Join(errs, "; "))
+}
+
+func (o *Signer) Pickup(pickupID string) ([]byte, error) {
+	instance, id, err := o.instanceFor(pickupID)
+	if err != nil {
+		return nil, err
+	}
+	return instance.Signer.Pickup(id)
+}
+
+// Revoke revokes the certificate using the instance which issued it.
+func (o *RevokingSigner) Revoke(pickupID string, reason string) error {
+	instance, id, err := o.instanceFor(pickupID)
+	if err != nil {
+		return err
+	}
+	return instance.Signer.(signer.Revoker).Revoke(id, reason)
+}
+
+// Cancel cancels the request using the instance which accepted it.
+func (o *RevokingSigner) Cancel(pickupID string) error {
+	instance, id, err := o.instanceFor(pickupID)
+	if err != nil {
+		return err
+	}
+	return instance.Signer.(signer.Revoker).Cancel(id)
+}
+
+// Health returns an error if every instance is unhealthy.
+func (o *Signer) Health(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	for _, instance := range o.Instances {
+		if !now.Before(o.unhealthyUntil[instance.Name]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("every instance is unhealthy")
+}
+
+// IgnoresDuration returns true if every instance ignores the requested
+// duration, so that the duration is still sent to the instances which honour
+// it.
+func (o *Signer) IgnoresDuration() bool {
+	for _, instance := range o.Instances {
+		if d, ok := instance.Signer.(signer.DurationIgnorer); !ok || !d.IgnoresDuration() {
+			return false
+		}
+	}
+	return true
+}
+
+// instanceFor returns the instance named in a tagged pickup ID, and the pickup
+// ID of that instance.
+// Pickup IDs without the tag prefix were returned before the composite signer
+// was configured, by the backend which is now the first instance. A tag which
+// names no instance is an error, because the instance may have been renamed
+// or removed, and the ID must not be sent to another instance.
+func (o *Signer) instanceFor(pickupID string) (Instance, string, error) {
+	if len(o.Instances) == 0 {
+		return Instance{}, "", fmt.Errorf("%w: no instances", signer.ErrPermanent)
+	}
+	if !strings.HasPrefix(pickupID, tagPrefix) {
+		return o.Instances[0], pickupID, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(pickupID, tagPrefix), tagSeparator, 2)
+	if len(parts) == 1 {
+		return Instance{}, "", fmt.Errorf("%w: pickup ID %q has no instance name", signer.ErrPermanent, pickupID)
+	}
+	for _, instance := range o.Instances {
+		if instance.Name == parts[0] {
+			return instance, parts[1], nil
+		}
+	}
+	return Instance{}, "", fmt.Errorf("%w: pickup ID %q is tagged with unknown instance %q", signer.ErrPermanent, pickupID, parts[0])
+}
+
+// candidates returns the instances in the order in which they should be
+// tried: the healthy instances first, starting with the instance chosen by
+// the mode, followed by the unhealthy instances.
+func (o *Signer) candidates() []Instance {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+
+	var healthy, unhealthy []Instance
+	for _, instance := range o.Instances {
+		if now.Before(o.unhealthyUntil[instance.Name]) {
+			unhealthy = append(unhealthy, instance)
+		} else {
+			healthy = append(healthy, instance)
+		}
+	}
+	if o.Mode == ModeRoundRobin && len(healthy) > 1 {
+		i := o.nextRoundRobin(healthy)
+		healthy = append([]Instance{healthy[i]}, append(healthy[:i:i], healthy[i+1:]...)...)
+	}
+	return append(healthy, unhealthy...)
+}
+
+// nextRoundRobin returns the index of the next instance using the smooth
+// weighted round-robin algorithm, which interleaves the instances rather than
+// sending runs of CSRs to the heaviest instance.
+func (o *Signer) nextRoundRobin(instances []Instance) int {
+	if o.current == nil {
+		o.current = map[string]int{}
+	}
+	best, total := 0, 0
+	for i, instance := range instances {
+		weight := instance.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		total += weight
+		o.current[instance.Name] += weight
+		if o.current[instance.Name] > o.current[instances[best].Name] {
+			best = i
+		}
+	}
+	o.current[instances[best].Name] -= total
+	return best
+}
+
+func (o *Signer) setHealthy(name string, healthy bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.unhealthyUntil == nil {
+		o.unhealthyUntil = map[string]time.Time{}
+	}
+	gauge := metrics.InstanceHealthy.WithLabelValues(o.SignerName, name)
+	if healthy {
+		delete(o.unhealthyUntil, name)
+		gauge.Set(1)
+		return
+	}
+	cooldown := o.Cooldown
+	if cooldown == 0 {
+		cooldown = defaultCooldown
+	}
+	o.unhealthyUntil[name] = time.Now().Add(cooldown)
+	gauge.Set(0)
+}
@@ -0,0 +1,285 @@
+package composite_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/composite"
+	"github.com/cert-manager/signer-venafi/internal/signer/fake"
+)
+
+// stubSigner returns err from Sign, if set, and records the calls.
+type stubSigner struct {
+	name     string
+	err      error
+	signed   int
+	pickedUp []string
+}
+
+func (o *stubSigner) Sign(csr capi.CertificateSigningRequest) (string, error) {
+	o.signed++
+	if o.err != nil {
+		return "", o.err
+	}
+	return fmt.Sprintf("%s-%d", o.name, o.signed), nil
+}
+
+func (o *stubSigner) Pickup(pickupID string) ([]byte, error) {
+	o.pickedUp = append(o.pickedUp, pickupID)
+	return []byte(o.name), nil
+}
+
+func newSigner(t *testing.T, mode composite.Mode, instances ...composite.Instance) *composite.Signer {
+	s := &composite.Signer{
+		Instances:  instances,
+		Mode:       mode,
+		Cooldown:   time.Hour,
+		SignerName: "example.com/foo",
+		Log:        zapr.NewLogger(zaptest.NewLogger(t)),
+	}
+	require.Empty(t, s.Validate())
+	return s
+}
+
+func TestSigner_Failover(t *testing.T) {
+	dc1 := &stubSigner{name: "dc1", err: errors.New("connection refused")}
+	dc2 := &stubSigner{name: "dc2"}
+	s := newSigner(t, composite.ModeFailover,
+		composite.Instance{Name: "dc1", Signer: dc1},
+		composite.Instance{Name: "dc2", Signer: dc2},
+	)
+
+	pickupID, err := s.Sign(capi.CertificateSigningRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "composite:dc2:dc2-1", pickupID)
+
+	certificate, err := s.Pickup(pickupID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("dc2"), certificate)
+	assert.Equal(t, []string{"dc2-1"}, dc2.pickedUp)
+
+	// dc1 is skipped while it is unhealthy.
+	_, err = s.Sign(capi.CertificateSigningRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, dc1.signed)
+	assert.Equal(t, 2, dc2.signed)
+	assert.NoError(t, s.Health(context.Background()))
+}
+
+func TestSigner_FailoverRecovery(t *testing.T) {
+	dc1 := &stubSigner{name: "dc1", err: errors.New("connection refused")}
+	dc2 := &stubSigner{name: "dc2"}
+	s := newSigner(t, composite.ModeFailover,
+		composite.Instance{Name: "dc1", Signer: dc1},
+		composite.Instance{Name: "dc2", Signer: dc2},
+	)
+	s.Cooldown = time.Millisecond
+
+	_, err := s.Sign(capi.CertificateSigningRequest{})
+	require.NoError(t, err)
+
+	dc1.err = nil
+	time.Sleep(time.Millisecond * 10)
+	pickupID, err := s.Sign(capi.CertificateSigningRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "composite:dc1:dc1-2", pickupID, "dc1 should be used again after the cooldown")
+}
+
+func TestSigner_PermanentError(t *testing.T) {
+	dc1 := &stubSigner{name: "dc1", err: fmt.Errorf("%w: policy violation", signer.ErrPermanent)}
+	dc2 := &stubSigner{name: "dc2"}
+	s := newSigner(t, composite.ModeFailover,
+		composite.Instance{Name: "dc1", Signer: dc1},
+		composite.Instance{Name: "dc2", Signer: dc2},
+	)
+
+	_, err := s.Sign(capi.CertificateSigningRequest{})
+	assert.True(t, errors.Is(err, signer.ErrPermanent), "expected ErrPermanent, got %v", err)
+	assert.Equal(t, 0, dc2.signed, "permanent errors should not fail over")
+}
+
+func TestSigner_EveryInstanceFails(t *testing.T) {
+	dc1 := &stubSigner{name: "dc1", err: errors.New("connection refused")}
+	dc2 := &stubSigner{name: "dc2", err: fmt.Errorf("%w: unavailable", signer.ErrTemporary)}
+	s := newSigner(t, composite.ModeFailover,
+		composite.Instance{Name: "dc1", Signer: dc1},
+		composite.Instance{Name: "dc2", Signer: dc2},
+	)
+
+	_, err := s.Sign(capi.CertificateSigningRequest{})
+	assert.True(t, errors.Is(err, signer.ErrTemporary), "expected ErrTemporary, got %v", err)
+	assert.Contains(t, err.Error(), "dc1: connection refused")
+	assert.Error(t, s.Health(context.Background()))
+
+	// Unhealthy instances are still tried when there is no healthy instance.
+	dc2.err = nil
+	pickupID, err := s.Sign(capi.CertificateSigningRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "composite:dc2:dc2-2", pickupID)
+	assert.NoError(t, s.Health(context.Background()))
+}
+
+func TestSigner_RoundRobin(t *testing.T) {
+	dc1 := &stubSigner{name: "dc1"}
+	dc2 := &stubSigner{name: "dc2"}
+	s := newSigner(t, composite.ModeRoundRobin,
+		composite.Instance{Name: "dc1", Signer: dc1, Weight: 2},
+		composite.Instance{Name: "dc2", Signer: dc2},
+	)
+
+	var instances []string
+	for i := 0; i < 6; i++ {
+		pickupID, err := s.Sign(capi.CertificateSigningRequest{})
+		require.NoError(t, err)
+		instances = append(instances, strings.Split(pickupID, ":")[1])
+	}
+	assert.Equal(t, []string{"dc1", "dc2", "dc1", "dc1", "dc2", "dc1"}, instances)
+}
+
+func TestSigner_UntaggedPickupID(t *testing.T) {
+	dc1 := &stubSigner{name: "dc1"}
+	dc2 := &stubSigner{name: "dc2"}
+	s := newSigner(t, composite.ModeFailover,
+		composite.Instance{Name: "dc1", Signer: dc1},
+		composite.Instance{Name: "dc2", Signer: dc2},
+	)
+
+	_, err := s.Pickup(`\VED\Policy\Kubernetes\foo`)
+	require.NoError(t, err)
+	// Vault serial numbers contain the separator.
+	_, err = s.Pickup("dc2:0a:1b:2c")
+	require.NoError(t, err)
+	assert.Equal(t, []string{`\VED\Policy\Kubernetes\foo`, "dc2:0a:1b:2c"}, dc1.pickedUp)
+	assert.Empty(t, dc2.pickedUp)
+}
+
+func TestSigner_UnknownInstance(t *testing.T) {
+	dc1 := &stubSigner{name: "dc1"}
+	s := newSigner(t, composite.ModeFailover, composite.Instance{Name: "dc1", Signer: dc1})
+
+	_, err := s.Pickup("composite:dc2:foo")
+	assert.True(t, errors.Is(err, signer.ErrPermanent), "expected ErrPermanent, got %v", err)
+	assert.Empty(t, dc1.pickedUp, "the pickup ID of another instance should not be sent to the first instance")
+}
+
+func TestSigner_Revoke(t *testing.T) {
+	dc1 := &fake.Signer{}
+	dc2 := &fake.Signer{}
+	s := newSigner(t, composite.ModeFailover,
+		composite.Instance{Name: "dc1", Signer: dc1},
+		composite.Instance{Name: "dc2", Signer: dc2},
+	)
+	rs, err := s.Revoking()
+	require.NoError(t, err)
+
+	require.NoError(t, rs.Revoke("composite:dc1:foo", "superseded"))
+	require.NoError(t, rs.Cancel("composite:dc2:bar"))
+	assert.Equal(t, []string{"foo"}, dc1.Revoked)
+	assert.Equal(t, []string{"bar"}, dc2.Revoked)
+	err = rs.Revoke("composite:dc3:foo", "superseded")
+	assert.True(t, errors.Is(err, signer.ErrPermanent), "expected ErrPermanent, got %v", err)
+}
+
+func TestSigner_RevokingWithoutRevoker(t *testing.T) {
+	s := newSigner(t, composite.ModeFailover,
+		composite.Instance{Name: "dc1", Signer: &fake.Signer{}},
+		composite.Instance{Name: "dc2", Signer: &stubSigner{name: "dc2"}},
+	)
+
+	_, err := s.Revoking()
+	assert.EqualError(t, err, `instance "dc2" does not support revocation`)
+}
+
+// durationIgnorer is a stubSigner which ignores the requested duration.
+type durationIgnorer struct {
+	stubSigner
+}
+
+func (o *durationIgnorer) IgnoresDuration() bool {
+	return true
+}
+
+func TestSigner_IgnoresDuration(t *testing.T) {
+	s := newSigner(t, composite.ModeFailover,
+		composite.Instance{Name: "dc1", Signer: &durationIgnorer{stubSigner{name: "dc1"}}},
+		composite.Instance{Name: "dc2", Signer: &durationIgnorer{stubSigner{name: "dc2"}}},
+	)
+	assert.True(t, s.IgnoresDuration())
+
+	s.Instances[1].Signer = &stubSigner{name: "dc2"}
+	assert.False(t, s.IgnoresDuration(), "the duration should be sent to the instances which honour it")
+}
+
+func TestSigner_Validate(t *testing.T) {
+	type testCase struct {
+		name      string
+		mode      composite.Mode
+		instances []composite.Instance
+		wantErrs  int
+	}
+	tests := []testCase{
+		{
+			name:      "Valid",
+			mode:      composite.ModeRoundRobin,
+			instances: []composite.Instance{{Name: "dc1"}, {Name: "dc2", Weight: 3}},
+		},
+		{
+			name:     "NoInstances",
+			mode:     composite.ModeFailover,
+			wantErrs: 1,
+		},
+		{
+			name:      "MissingName",
+			mode:      composite.ModeFailover,
+			instances: []composite.Instance{{}},
+			wantErrs:  1,
+		},
+		{
+			name:      "NameWithSeparator",
+			mode:      composite.ModeFailover,
+			instances: []composite.Instance{{Name: "dc:1"}},
+			wantErrs:  1,
+		},
+		{
+			name:      "DuplicateName",
+			mode:      composite.ModeFailover,
+			instances: []composite.Instance{{Name: "dc1"}, {Name: "dc1"}},
+			wantErrs:  1,
+		},
+		{
+			name:      "NegativeWeight",
+			mode:      composite.ModeRoundRobin,
+			instances: []composite.Instance{{Name: "dc1", Weight: -1}},
+			wantErrs:  1,
+		},
+		{
+			name:      "UnknownMode",
+			mode:      "random",
+			instances: []composite.Instance{{Name: "dc1"}},
+			wantErrs:  1,
+		},
+		{
+			name:      "EveryError",
+			mode:      "random",
+			instances: []composite.Instance{{Weight: -1}, {Name: "dc:1"}},
+			wantErrs:  4,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &composite.Signer{Instances: tc.instances, Mode: tc.mode}
+			assert.Len(t, s.Validate(), tc.wantErrs)
+		})
+	}
+}
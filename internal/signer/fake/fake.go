@@ -11,9 +11,14 @@ const pickupID = "foo-bar"
 // Signer is an in-memory implementation of signer.Signer for use in tests.
 type Signer struct {
 	Certificate []byte
+	// Revoked records the pickup IDs passed to Revoke and Cancel.
+	Revoked []string
 }
 
-var _ signer.Signer = &Signer{}
+var (
+	_ signer.Signer  = &Signer{}
+	_ signer.Revoker = &Signer{}
+)
 
 func (o *Signer) Sign(csr capi.CertificateSigningRequest) (string, error) {
 	return pickupID, nil
@@ -22,3 +27,13 @@ func (o *Signer) Sign(csr capi.CertificateSigningRequest) (string, error) {
 func (o *Signer) Pickup(pickupID string) ([]byte, error) {
 	return o.Certificate, nil
 }
+
+func (o *Signer) Revoke(pickupID string, reason string) error {
+	o.Revoked = append(o.Revoked, pickupID)
+	return nil
+}
+
+func (o *Signer) Cancel(pickupID string) error {
+	o.Revoked = append(o.Revoked, pickupID)
+	return nil
+}
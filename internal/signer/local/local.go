@@ -0,0 +1,147 @@
+// Package local implements signer.Signer using a CA key pair held by the
+// controller, for development clusters and for clusters which can not reach
+// Venafi.
+package local
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/chain"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/trust"
+)
+
+// The certificate duration used if the CSR does not request a duration
+const defaultDuration = time.Hour * 24
+
+// Signer implements signer.Signer by signing CSRs with a local CA.
+// Certificates are signed immediately by Sign, and because the Signer keeps
+// no state, the pickup ID is the base64 encoded DER certificate.
+type Signer struct {
+	// CACertificates are the CA certificate whose key is CAKey, followed by
+	// its own CA chain, if any.
+	CACertificates []*x509.Certificate
+	CAKey          crypto.Signer
+	Log            logr.Logger
+	// Chain, if set, is updated with the CA certificates.
+	Chain *trust.Chain
+	// Assembler, if set, assembles and validates the certificate chain which
+	// is returned by Pickup. Otherwise only the leaf certificate is returned.
+	Assembler *chain.Assembler
+}
+
+var (
+	_ signer.Signer      = &Signer{}
+	_ signer.ChainReader = &Signer{}
+)
+
+// Sign signs the CSR, honouring the duration and isCA requested by the CSR
+// annotations and the CSR usages.
+// The certificate expires no later than the CA certificate.
+func (o *Signer) Sign(csr capi.CertificateSigningRequest) (string, error) {
+	log := o.Log.WithName("Sign")
+
+	duration, err := capihelper.GetRequestDuration(&csr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", signer.ErrPermanent, err)
+	}
+	if duration == 0 {
+		duration = defaultDuration
+	}
+	isCA, err := capihelper.GetRequestIsCA(&csr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", signer.ErrPermanent, err)
+	}
+	var usages []cmapi.KeyUsage
+	for _, u := range csr.Spec.Usages {
+		usages = append(usages, cmapi.KeyUsage(u))
+	}
+	keyUsage, extKeyUsage, err := pki.BuildKeyUsages(usages, isCA)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", signer.ErrPermanent, err)
+	}
+
+	log.V(1).Info("Generating template from CSR", "duration", duration, "is-ca", isCA)
+	tmpl, err := pki.GenerateTemplateFromCSRPEMWithUsages(csr.Spec.Request, duration, isCA, keyUsage, extKeyUsage)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to generate template from CSR PEM: %v", signer.ErrPermanent, err)
+	}
+	ca := o.CACertificates[0]
+	if tmpl.NotAfter.After(ca.NotAfter) {
+		tmpl.NotAfter = ca.NotAfter
+	}
+
+	log.V(1).Info("Signing certificate")
+	_, cert, err := pki.SignCertificate(tmpl, ca, tmpl.PublicKey, o.CAKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign certificate: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(cert.Raw), nil
+}
+
+// Pickup returns the PEM encoded certificate in the pickup ID.
+func (o *Signer) Pickup(pickupID string) ([]byte, error) {
+	der, err := base64.StdEncoding.DecodeString(pickupID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid pickup ID: %v", signer.ErrPermanent, err)
+	}
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	caPEM := o.caPEM()
+	if o.Chain != nil {
+		o.Chain.Set(caPEM)
+	}
+	if o.Assembler == nil {
+		return leafPEM, nil
+	}
+	certificate, err := o.Assembler.Assemble(leafPEM, caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", signer.ErrPermanent, err)
+	}
+	return certificate, nil
+}
+
+// CAChain returns the CA certificates.
+func (o *Signer) CAChain(ctx context.Context) ([]byte, error) {
+	return o.caPEM(), nil
+}
+
+func (o *Signer) caPEM() []byte {
+	var caPEM []byte
+	for _, ca := range o.CACertificates {
+		caPEM = append(caPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})...)
+	}
+	return caPEM
+}
+
+// ParseCA parses a PEM encoded CA certificate, optionally followed by its CA
+// chain, and the PEM encoded private key of the CA certificate.
+func ParseCA(certPEM, keyPEM []byte) ([]*x509.Certificate, crypto.Signer, error) {
+	certs, err := pki.DecodeX509CertificateChainBytes(certPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode CA certificate: %v", err)
+	}
+	key, err := pki.DecodePrivateKeyBytes(keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode CA private key: %v", err)
+	}
+	if !certs[0].IsCA {
+		return nil, nil, fmt.Errorf("certificate %q is not a CA certificate", certs[0].Subject)
+	}
+	if ok, err := pki.PublicKeyMatchesCertificate(key.Public(), certs[0]); err != nil || !ok {
+		return nil, nil, fmt.Errorf("CA private key does not match the CA certificate")
+	}
+	return certs, key, nil
+}
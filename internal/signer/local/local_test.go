@@ -0,0 +1,149 @@
+package local_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/local"
+)
+
+// generateCA returns a PEM encoded self-signed CA certificate and private
+// key, which expire at the supplied time.
+func generateCA(t *testing.T, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "local-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	keyPEM, err := pki.EncodeECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM
+}
+
+func generateCSR(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "app"},
+		DNSNames: []string{"app.example.com"},
+	}, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func newSigner(t *testing.T, caNotAfter time.Time) *local.Signer {
+	certPEM, keyPEM := generateCA(t, caNotAfter)
+	certs, key, err := local.ParseCA(certPEM, keyPEM)
+	require.NoError(t, err)
+	return &local.Signer{
+		CACertificates: certs,
+		CAKey:          key,
+		Log:            zapr.NewLogger(zaptest.NewLogger(t)).WithName("Signer"),
+	}
+}
+
+func TestSigner(t *testing.T) {
+	tests := []struct {
+		name         string
+		caNotAfter   time.Time
+		annotations  map[string]string
+		usages       []capi.KeyUsage
+		wantDuration time.Duration
+		wantErr      bool
+	}{
+		{
+			name:         "SuccessDefaults",
+			caNotAfter:   time.Now().Add(365 * 24 * time.Hour),
+			usages:       []capi.KeyUsage{capi.UsageDigitalSignature, capi.UsageKeyEncipherment, capi.UsageServerAuth},
+			wantDuration: 24 * time.Hour,
+		},
+		{
+			name:         "SuccessRequestedDuration",
+			caNotAfter:   time.Now().Add(365 * 24 * time.Hour),
+			annotations:  map[string]string{capihelper.AnnotationKeyRequestDuration: "2h"},
+			usages:       []capi.KeyUsage{capi.UsageDigitalSignature, capi.UsageKeyEncipherment, capi.UsageServerAuth},
+			wantDuration: 2 * time.Hour,
+		},
+		{
+			name:         "SuccessClampedToCA",
+			caNotAfter:   time.Now().Add(time.Hour).Truncate(time.Second),
+			usages:       []capi.KeyUsage{capi.UsageDigitalSignature, capi.UsageKeyEncipherment, capi.UsageServerAuth},
+			wantDuration: time.Hour,
+		},
+		{
+			name:       "ErrorUnknownUsage",
+			caNotAfter: time.Now().Add(365 * 24 * time.Hour),
+			usages:     []capi.KeyUsage{"foo"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSigner(t, tt.caNotAfter)
+			csr := capi.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+				Spec: capi.CertificateSigningRequestSpec{
+					Request: generateCSR(t),
+					Usages:  tt.usages,
+				},
+			}
+			pickupID, err := s.Sign(csr)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, signer.ErrPermanent), "expected ErrPermanent, got %v", err)
+				return
+			}
+			require.NoError(t, err)
+
+			certPEM, err := s.Pickup(pickupID)
+			require.NoError(t, err)
+			cert, err := pki.DecodeX509CertificateBytes(certPEM)
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"app.example.com"}, cert.DNSNames)
+			assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, cert.ExtKeyUsage)
+			assert.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment, cert.KeyUsage)
+			assert.False(t, cert.IsCA)
+			assert.WithinDuration(t, time.Now().Add(tt.wantDuration), cert.NotAfter, time.Minute)
+			assert.NoError(t, cert.CheckSignatureFrom(s.CACertificates[0]))
+		})
+	}
+}
+
+func TestSigner_Pickup(t *testing.T) {
+	s := newSigner(t, time.Now().Add(time.Hour))
+	_, err := s.Pickup("not base64!")
+	assert.True(t, errors.Is(err, signer.ErrPermanent), "expected ErrPermanent, got %v", err)
+}
+
+func TestParseCA(t *testing.T) {
+	certPEM, _ := generateCA(t, time.Now().Add(time.Hour))
+	_, otherKeyPEM := generateCA(t, time.Now().Add(time.Hour))
+	_, _, err := local.ParseCA(certPEM, otherKeyPEM)
+	assert.Error(t, err)
+}
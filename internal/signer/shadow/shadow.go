@@ -0,0 +1,389 @@
+// Package shadow implements a signer.Signer which also sends each CSR to a
+// shadow signer, and compares the certificates, for migrating a signer name
+// from one CA to another.
+package shadow
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cert-manager/signer-venafi/internal/metrics"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+)
+
+// The fields of the certificates which are compared
+const (
+	FieldSubject  = "subject"
+	FieldSANs     = "sans"
+	FieldUsages   = "usages"
+	FieldValidity = "validity"
+	FieldChain    = "chain"
+)
+
+// The values of the result label of metrics.ShadowComparisonsTotal
+const (
+	resultMatch    = "match"
+	resultMismatch = "mismatch"
+	resultError    = "error"
+)
+
+// The reasons used in events about the shadow certificates
+const (
+	reasonShadowMismatch = "ShadowMismatch"
+	reasonShadowFailed   = "ShadowFailed"
+)
+
+// The reason given when revoking a shadow certificate, which is never used
+const revocationReason = "cessation-of-operation"
+
+// The defaults of the Signer fields
+const (
+	defaultTimeout           = time.Minute * 10
+	defaultPollInterval      = time.Second * 5
+	defaultValidityTolerance = time.Minute * 5
+)
+
+// Signer implements signer.Signer using Primary, whose results are returned,
+// and also sends each CSR to Shadow. When the certificate of the Primary is
+// picked up, the certificate of the Shadow is picked up in the background,
+// compared with it and discarded. The differences are reported using metrics
+// and events. If the Shadow is a signer.Revoker, the shadow certificate is
+// then revoked, or the shadow request cancelled if it was not picked up.
+//
+// CSRs are not sent to the Shadow while it has not answered an earlier CSR
+// within the Timeout, so that a Shadow which hangs can not accumulate
+// goroutines.
+//
+// The shadow requests are only held in memory, so those which are pending
+// when the signer is restarted are not compared.
+type Signer struct {
+	Primary    signer.Signer
+	Shadow     signer.Signer
+	SignerName string
+	Log        logr.Logger
+	// Recorder, if set, records events on the CSRs whose certificates
+	// differ.
+	Recorder record.EventRecorder
+	// Timeout is how long to wait for the certificate of the Shadow.
+	Timeout time.Duration
+	// PollInterval is the time between pickups of the certificate of the
+	// Shadow.
+	PollInterval time.Duration
+	// ValidityTolerance is the difference in validity periods which is not
+	// reported.
+	ValidityTolerance time.Duration
+
+	mu sync.Mutex
+	// pending are the shadow requests by the pickup ID of the Primary.
+	pending map[string]*request
+	// signing are the shadow requests which the Shadow has not yet
+	// answered.
+	signing map[*request]struct{}
+}
+
+// request is a CSR which has been sent to the Shadow.
+type request struct {
+	csr     capi.CertificateSigningRequest
+	created time.Time
+	// signed is closed when the Shadow has returned pickupID or err.
+	signed   chan struct{}
+	pickupID string
+	err      error
+}
+
+var _ signer.Signer = &Signer{}
+
+func (o *Signer) Sign(csr capi.CertificateSigningRequest) (string, error) {
+	pickupID, err := o.Primary.Sign(csr)
+	if err != nil {
+		return "", err
+	}
+
+	req := &request{csr: csr, created: time.Now(), signed: make(chan struct{})}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.pending == nil {
+		o.pending = map[string]*request{}
+		o.signing = map[*request]struct{}{}
+	}
+	for id, r := range o.pending {
+		if time.Since(r.created) > o.timeout() {
+			delete(o.pending, id)
+			go o.discard(r, false)
+		}
+	}
+	for r := range o.signing {
+		if time.Since(r.created) > o.timeout() {
+			o.Log.WithName("Sign").Info("Not sending the CSR to the shadow signer, which has not answered an earlier CSR within the timeout",
+				"name", csr.Name)
+			return pickupID, nil
+		}
+	}
+	o.pending[pickupID] = req
+	o.signing[req] = struct{}{}
+
+	go o.signShadow(req)
+	return pickupID, nil
+}
+
+// signShadow sends the CSR of the request to the Shadow.
+func (o *Signer) signShadow(req *request) {
+	defer close(req.signed)
+	req.pickupID, req.err = o.Shadow.Sign(req.csr)
+	o.mu.Lock()
+	delete(o.signing, req)
+	o.mu.Unlock()
+}
+
+func (o *Signer) Pickup(pickupID string) ([]byte, error) {
+	certificate, err := o.Primary.Pickup(pickupID)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	req, ok := o.pending[pickupID]
+	delete(o.pending, pickupID)
+	o.mu.Unlock()
+	if ok {
+		go o.compare(req, certificate)
+	}
+	return certificate, nil
+}
+
+// compare waits for the certificate of the Shadow and reports its
+// differences from the certificate of the Primary.
+func (o *Signer) compare(req *request, certificate []byte) {
+	log := o.Log.WithName("compare").WithValues("name", req.csr.Name)
+
+	shadowCertificate, err := o.pickupShadow(req)
+	defer o.discard(req, shadowCertificate != nil)
+	if err != nil {
+		log.Error(err, "Failed to get the shadow certificate")
+		metrics.ShadowComparisonsTotal.WithLabelValues(o.SignerName, resultError).Inc()
+		o.event(req, reasonShadowFailed, "Failed to get the shadow certificate: %v", err)
+		return
+	}
+	differences, err := Compare(certificate, shadowCertificate, o.validityTolerance())
+	if err != nil {
+		log.Error(err, "Failed to compare the shadow certificate")
+		metrics.ShadowComparisonsTotal.WithLabelValues(o.SignerName, resultError).Inc()
+		o.event(req, reasonShadowFailed, "Failed to compare the shadow certificate: %v", err)
+		return
+	}
+	if len(differences) == 0 {
+		log.V(1).Info("Shadow certificate matches")
+		metrics.ShadowComparisonsTotal.WithLabelValues(o.SignerName, resultMatch).Inc()
+		return
+	}
+	var descriptions []string
+	for _, d := range differences {
+		metrics.ShadowDifferencesTotal.WithLabelValues(o.SignerName, d.Field).Inc()
+		descriptions = append(descriptions, d.String())
+	}
+	log.Info("Shadow certificate differs", "differences", descriptions)
+	metrics.ShadowComparisonsTotal.WithLabelValues(o.SignerName, resultMismatch).Inc()
+	o.event(req, reasonShadowMismatch, "Shadow certificate differs: %s", strings.Join(descriptions, "; "))
+}
+
+// pickupShadow returns the certificate of the Shadow, retrying temporary
+// errors until the Timeout.
+func (o *Signer) pickupShadow(req *request) ([]byte, error) {
+	deadline := req.created.Add(o.timeout())
+	select {
+	case <-req.signed:
+	case <-time.After(time.Until(deadline)):
+		return nil, fmt.Errorf("timed out waiting for the shadow signer to accept the CSR")
+	}
+	if req.err != nil {
+		return nil, fmt.Errorf("shadow signer failed to sign: %v", req.err)
+	}
+	for {
+		certificate, err := o.Shadow.Pickup(req.pickupID)
+		if err == nil {
+			return certificate, nil
+		}
+		if !errors.Is(err, signer.ErrTemporary) {
+			return nil, fmt.Errorf("shadow signer failed to pick up: %v", err)
+		}
+		if time.Now().Add(o.pollInterval()).After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the shadow certificate: %v", err)
+		}
+		time.Sleep(o.pollInterval())
+	}
+}
+
+// discard revokes the shadow certificate, or cancels the shadow request if
+// the certificate was not picked up, if the Shadow is a signer.Revoker. It
+// waits for the Shadow to answer the request.
+func (o *Signer) discard(req *request, pickedUp bool) {
+	revoker, ok := o.Shadow.(signer.Revoker)
+	if !ok {
+		return
+	}
+	<-req.signed
+	if req.err != nil {
+		return
+	}
+	log := o.Log.WithName("discard").WithValues("name", req.csr.Name, "pickup-id", req.pickupID)
+	if pickedUp {
+		if err := revoker.Revoke(req.pickupID, revocationReason); err != nil {
+			log.Error(err, "Failed to revoke the shadow certificate")
+		}
+		return
+	}
+	if err := revoker.Cancel(req.pickupID); err != nil {
+		log.Error(err, "Failed to cancel the shadow request")
+	}
+}
+
+func (o *Signer) event(req *request, reason, messageFmt string, args ...interface{}) {
+	if o.Recorder != nil {
+		o.Recorder.Eventf(&req.csr, corev1.EventTypeWarning, reason, messageFmt, args...)
+	}
+}
+
+func (o *Signer) timeout() time.Duration {
+	if o.Timeout == 0 {
+		return defaultTimeout
+	}
+	return o.Timeout
+}
+
+func (o *Signer) pollInterval() time.Duration {
+	if o.PollInterval == 0 {
+		return defaultPollInterval
+	}
+	return o.PollInterval
+}
+
+func (o *Signer) validityTolerance() time.Duration {
+	if o.ValidityTolerance == 0 {
+		return defaultValidityTolerance
+	}
+	return o.ValidityTolerance
+}
+
+// Difference is a field which differs between two certificates.
+type Difference struct {
+	Field   string
+	Primary string
+	Shadow  string
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: %q != %q", d.Field, d.Primary, d.Shadow)
+}
+
+// Compare returns the differences between the subject, SANs, usages,
+// validity period and chain of two PEM encoded certificates, each optionally
+// followed by its CA chain. Validity periods which differ by no more than the
+// tolerance are considered equal.
+func Compare(primary, shadow []byte, tolerance time.Duration) ([]Difference, error) {
+	p, err := pki.DecodeX509CertificateChainBytes(primary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the primary certificate: %v", err)
+	}
+	s, err := pki.DecodeX509CertificateChainBytes(shadow)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the shadow certificate: %v", err)
+	}
+
+	var differences []Difference
+	add := func(field, primary, shadow string) {
+		if primary != shadow {
+			differences = append(differences, Difference{Field: field, Primary: primary, Shadow: shadow})
+		}
+	}
+	add(FieldSubject, p[0].Subject.String(), s[0].Subject.String())
+	add(FieldSANs, sans(p[0]), sans(s[0]))
+	add(FieldUsages, usages(p[0]), usages(s[0]))
+	pValidity, sValidity := p[0].NotAfter.Sub(p[0].NotBefore), s[0].NotAfter.Sub(s[0].NotBefore)
+	if diff := pValidity - sValidity; diff > tolerance || -diff > tolerance {
+		add(FieldValidity, pValidity.String(), sValidity.String())
+	}
+	add(FieldChain, chain(p), chain(s))
+	return differences, nil
+}
+
+// sans returns the sorted SANs of the certificate.
+func sans(cert *x509.Certificate) string {
+	var names []string
+	names = append(names, prefixed("DNS:", cert.DNSNames)...)
+	names = append(names, prefixed("email:", cert.EmailAddresses)...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, "IP:"+net.IP(ip).String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, "URI:"+uri.String())
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func prefixed(prefix string, items []string) []string {
+	var result []string
+	for _, item := range items {
+		result = append(result, prefix+item)
+	}
+	return result
+}
+
+// The names of the key usages, in the order of their bits
+var keyUsageNames = []string{
+	"digitalSignature", "contentCommitment", "keyEncipherment", "dataEncipherment",
+	"keyAgreement", "certSign", "crlSign", "encipherOnly", "decipherOnly",
+}
+
+// The names of the common extended key usages
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "ocspSigning",
+}
+
+// usages returns the sorted key usages and extended key usages of the
+// certificate.
+func usages(cert *x509.Certificate) string {
+	var names []string
+	for i, name := range keyUsageNames {
+		if cert.KeyUsage&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	for _, u := range cert.ExtKeyUsage {
+		name, ok := extKeyUsageNames[u]
+		if !ok {
+			name = fmt.Sprintf("extKeyUsage(%d)", u)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// chain returns the issuer of the leaf certificate and the subjects of the CA
+// certificates which follow it.
+func chain(certs []*x509.Certificate) string {
+	names := []string{certs[0].Issuer.String()}
+	for _, cert := range certs[1:] {
+		names = append(names, cert.Subject.String())
+	}
+	return strings.Join(names, " <- ")
+}
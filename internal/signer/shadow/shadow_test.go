@@ -0,0 +1,365 @@
+package shadow_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	capi "k8s.io/api/certificates/v1beta1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cert-manager/signer-venafi/internal/metrics"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/shadow"
+)
+
+type ca struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T, name string) *ca {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24 * 365),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &ca{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// leafTemplate returns the template of the certificates which match.
+func leafTemplate() *x509.Certificate {
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "app"},
+		DNSNames:     []string{"app.example.com", "app"},
+		NotBefore:    now,
+		NotAfter:     now.Add(time.Hour * 24),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+}
+
+// issue returns the PEM leaf certificate followed by the CA certificate.
+func (o *ca) issue(t *testing.T, tmpl *x509.Certificate) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, o.cert, key.Public(), o.key)
+	require.NoError(t, err)
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), o.pem...)
+}
+
+func TestCompare(t *testing.T) {
+	primaryCA := newCA(t, "primary-ca")
+	otherCA := newCA(t, "other-ca")
+	primary := primaryCA.issue(t, leafTemplate())
+
+	type testCase struct {
+		name       string
+		ca         *ca
+		modify     func(*x509.Certificate)
+		wantFields []string
+	}
+	tests := []testCase{
+		{
+			name: "Match",
+			ca:   primaryCA,
+			modify: func(c *x509.Certificate) {
+				// The order of SANs does not matter
+				c.DNSNames = []string{"app", "app.example.com"}
+			},
+		},
+		{
+			name: "ValidityWithinTolerance",
+			ca:   primaryCA,
+			modify: func(c *x509.Certificate) {
+				c.NotAfter = c.NotAfter.Add(time.Minute)
+			},
+		},
+		{
+			name: "Subject",
+			ca:   primaryCA,
+			modify: func(c *x509.Certificate) {
+				c.Subject.Organization = []string{"example"}
+			},
+			wantFields: []string{shadow.FieldSubject},
+		},
+		{
+			name: "SANs",
+			ca:   primaryCA,
+			modify: func(c *x509.Certificate) {
+				c.DNSNames = []string{"app.example.com"}
+			},
+			wantFields: []string{shadow.FieldSANs},
+		},
+		{
+			name: "Usages",
+			ca:   primaryCA,
+			modify: func(c *x509.Certificate) {
+				c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+			},
+			wantFields: []string{shadow.FieldUsages},
+		},
+		{
+			name: "Validity",
+			ca:   primaryCA,
+			modify: func(c *x509.Certificate) {
+				c.NotAfter = c.NotAfter.Add(time.Hour)
+			},
+			wantFields: []string{shadow.FieldValidity},
+		},
+		{
+			name:       "Chain",
+			ca:         otherCA,
+			wantFields: []string{shadow.FieldChain},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl := leafTemplate()
+			if tc.modify != nil {
+				tc.modify(tmpl)
+			}
+			differences, err := shadow.Compare(primary, tc.ca.issue(t, tmpl), time.Minute*5)
+			require.NoError(t, err)
+			var fields []string
+			for _, d := range differences {
+				fields = append(fields, d.Field)
+			}
+			assert.Equal(t, tc.wantFields, fields)
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := shadow.Compare(primary, []byte("not a certificate"), time.Minute)
+		assert.Error(t, err)
+	})
+}
+
+// stubSigner returns the certificate after returning ErrTemporary from the
+// first pending pickups. If block is set, Sign waits until it is closed.
+type stubSigner struct {
+	certificate []byte
+	signErr     error
+	pending     int
+	block       chan struct{}
+
+	mu     sync.Mutex
+	signed int
+}
+
+func (o *stubSigner) Sign(csr capi.CertificateSigningRequest) (string, error) {
+	o.mu.Lock()
+	o.signed++
+	o.mu.Unlock()
+	if o.block != nil {
+		<-o.block
+	}
+	return "id", o.signErr
+}
+
+func (o *stubSigner) signCount() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.signed
+}
+
+func (o *stubSigner) Pickup(pickupID string) ([]byte, error) {
+	if o.pending > 0 {
+		o.pending--
+		return nil, fmt.Errorf("%w: pending", signer.ErrTemporary)
+	}
+	return o.certificate, nil
+}
+
+// revokingSigner is a stubSigner which records the pickup IDs passed to
+// Revoke and Cancel.
+type revokingSigner struct {
+	*stubSigner
+	revoked   chan string
+	cancelled chan string
+}
+
+func newRevokingSigner(s *stubSigner) *revokingSigner {
+	return &revokingSigner{stubSigner: s, revoked: make(chan string, 10), cancelled: make(chan string, 10)}
+}
+
+func (o *revokingSigner) Revoke(pickupID string, reason string) error {
+	o.revoked <- pickupID
+	return nil
+}
+
+func (o *revokingSigner) Cancel(pickupID string) error {
+	o.cancelled <- pickupID
+	return nil
+}
+
+// waitFor returns the next pickup ID sent on ch, or fails the test.
+func waitFor(t *testing.T, ch chan string) string {
+	select {
+	case pickupID := <-ch:
+		return pickupID
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the shadow request to be discarded")
+		return ""
+	}
+}
+
+// waitForEvent returns the next event recorded, or fails the test.
+func waitForEvent(t *testing.T, recorder *record.FakeRecorder) string {
+	select {
+	case event := <-recorder.Events:
+		return event
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for an event")
+		return ""
+	}
+}
+
+func TestSigner(t *testing.T) {
+	primaryCA := newCA(t, "primary-ca")
+	shadowCA := newCA(t, "shadow-ca")
+
+	newSigner := func(t *testing.T, signerName string, primary *stubSigner, shadowSigner signer.Signer) (*shadow.Signer, *record.FakeRecorder) {
+		recorder := record.NewFakeRecorder(10)
+		return &shadow.Signer{
+			Primary:      primary,
+			Shadow:       shadowSigner,
+			SignerName:   signerName,
+			Log:          zapr.NewLogger(zaptest.NewLogger(t)),
+			Recorder:     recorder,
+			PollInterval: time.Millisecond,
+		}, recorder
+	}
+	signAndPickup := func(t *testing.T, s *shadow.Signer) []byte {
+		pickupID, err := s.Sign(capi.CertificateSigningRequest{})
+		require.NoError(t, err)
+		certificate, err := s.Pickup(pickupID)
+		require.NoError(t, err)
+		return certificate
+	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		primary := &stubSigner{certificate: primaryCA.issue(t, leafTemplate())}
+		s, recorder := newSigner(t, "example.com/mismatch", primary, &stubSigner{
+			certificate: shadowCA.issue(t, leafTemplate()),
+			pending:     2,
+		})
+
+		assert.Equal(t, primary.certificate, signAndPickup(t, s))
+		event := waitForEvent(t, recorder)
+		assert.True(t, strings.HasPrefix(event, "Warning ShadowMismatch Shadow certificate differs: chain:"), event)
+		assert.Equal(t, float64(1), testutil.ToFloat64(
+			metrics.ShadowComparisonsTotal.WithLabelValues("example.com/mismatch", "mismatch")))
+		assert.Equal(t, float64(1), testutil.ToFloat64(
+			metrics.ShadowDifferencesTotal.WithLabelValues("example.com/mismatch", shadow.FieldChain)))
+	})
+
+	t.Run("ShadowSignFailed", func(t *testing.T) {
+		primary := &stubSigner{certificate: primaryCA.issue(t, leafTemplate())}
+		s, recorder := newSigner(t, "example.com/failed", primary, &stubSigner{
+			signErr: errors.New("connection refused"),
+		})
+
+		assert.Equal(t, primary.certificate, signAndPickup(t, s))
+		event := waitForEvent(t, recorder)
+		assert.Contains(t, event, "Warning ShadowFailed")
+		assert.Contains(t, event, "connection refused")
+	})
+
+	t.Run("Match", func(t *testing.T) {
+		primary := &stubSigner{certificate: primaryCA.issue(t, leafTemplate())}
+		s, recorder := newSigner(t, "example.com/match", primary, &stubSigner{
+			certificate: primaryCA.issue(t, leafTemplate()),
+		})
+
+		assert.Equal(t, primary.certificate, signAndPickup(t, s))
+		require.Eventually(t, func() bool {
+			return testutil.ToFloat64(metrics.ShadowComparisonsTotal.WithLabelValues("example.com/match", "match")) == 1
+		}, time.Second*5, time.Millisecond*10)
+		assert.Empty(t, recorder.Events)
+	})
+
+	t.Run("PrimarySignFailed", func(t *testing.T) {
+		shadowSigner := &stubSigner{}
+		s, _ := newSigner(t, "example.com/primary-failed", &stubSigner{signErr: errors.New("denied")}, shadowSigner)
+
+		_, err := s.Sign(capi.CertificateSigningRequest{})
+		assert.EqualError(t, err, "denied")
+	})
+
+	t.Run("RevokesShadowCertificate", func(t *testing.T) {
+		primary := &stubSigner{certificate: primaryCA.issue(t, leafTemplate())}
+		shadowSigner := newRevokingSigner(&stubSigner{certificate: primaryCA.issue(t, leafTemplate())})
+		s, _ := newSigner(t, "example.com/revoke", primary, shadowSigner)
+
+		signAndPickup(t, s)
+		assert.Equal(t, "id", waitFor(t, shadowSigner.revoked))
+		assert.Empty(t, shadowSigner.cancelled)
+	})
+
+	t.Run("CancelsShadowRequest", func(t *testing.T) {
+		primary := &stubSigner{certificate: primaryCA.issue(t, leafTemplate())}
+		shadowSigner := newRevokingSigner(&stubSigner{pending: 1000})
+		s, recorder := newSigner(t, "example.com/cancel", primary, shadowSigner)
+		s.Timeout = time.Millisecond * 50
+
+		signAndPickup(t, s)
+		assert.Contains(t, waitForEvent(t, recorder), "timed out waiting for the shadow certificate")
+		assert.Equal(t, "id", waitFor(t, shadowSigner.cancelled))
+		assert.Empty(t, shadowSigner.revoked)
+	})
+
+	t.Run("ShadowSignHangs", func(t *testing.T) {
+		primary := &stubSigner{certificate: primaryCA.issue(t, leafTemplate())}
+		shadowSigner := newRevokingSigner(&stubSigner{
+			certificate: primaryCA.issue(t, leafTemplate()),
+			block:       make(chan struct{}),
+		})
+		s, recorder := newSigner(t, "example.com/hang", primary, shadowSigner)
+		s.Timeout = time.Millisecond * 50
+
+		signAndPickup(t, s)
+		assert.Contains(t, waitForEvent(t, recorder), "timed out waiting for the shadow signer to accept the CSR")
+
+		// The hung Shadow is not sent any more CSRs.
+		assert.Equal(t, primary.certificate, signAndPickup(t, s))
+		assert.Equal(t, 1, shadowSigner.signCount())
+
+		// The request is cancelled when the Shadow finally answers.
+		close(shadowSigner.block)
+		assert.Equal(t, "id", waitFor(t, shadowSigner.cancelled))
+		require.Eventually(t, func() bool {
+			_, err := s.Sign(capi.CertificateSigningRequest{})
+			require.NoError(t, err)
+			return shadowSigner.signCount() > 1
+		}, time.Second*5, time.Millisecond*10, "CSRs should be sent to the Shadow again once it answers")
+	})
+}
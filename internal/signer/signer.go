@@ -1,7 +1,9 @@
 package signer
 
 import (
+	"context"
 	"errors"
+	"fmt"
 
 	capi "k8s.io/api/certificates/v1beta1"
 )
@@ -10,6 +12,11 @@ import (
 // implementations of Signer so that the caller can know whether to retry.
 var ErrTemporary = errors.New("Temporary Error")
 
+// ErrPermanent should be wrapped by any errors returned by implementations of
+// Signer which will not be resolved by retrying, such as a CSR which violates
+// policy. The caller should mark the CSR as failed rather than retrying.
+var ErrPermanent = errors.New("Permanent Error")
+
 type Signer interface {
 	// Sign makes a request to process a certificate signing request and returns
 	// a pickup ID which can be used later in Pickup
@@ -20,3 +27,72 @@ type Signer interface {
 	// should retry the Pickup with the same pickupID.
 	Pickup(pickupID string) (certificate []byte, err error)
 }
+
+// Revoker is implemented by Signers which are able to revoke the certificates
+// that they have issued.
+type Revoker interface {
+	// Revoke revokes the certificate corresponding to the supplied pickup ID,
+	// giving the supplied reason, which is one of the RevocationReasons.
+	// May return an error wrapping ErrPermanent, in which case retrying will
+	// not revoke the certificate.
+	Revoke(pickupID string, reason string) error
+	// Cancel cancels a pending request for a certificate which has not yet
+	// been picked up, so that it will not be issued.
+	// May return an error wrapping ErrPermanent, in which case retrying will
+	// not cancel the request.
+	Cancel(pickupID string) error
+}
+
+// RevocationReasons are the revocation reasons which may be passed to
+// Revoker.Revoke. The empty reason means that no reason is given.
+var RevocationReasons = []string{
+	"",
+	"none",
+	"key-compromise",
+	"ca-compromise",
+	"affiliation-changed",
+	"superseded",
+	"cessation-of-operation",
+}
+
+// ValidateRevocationReason returns an error wrapping ErrPermanent if the
+// reason is not one of the RevocationReasons.
+func ValidateRevocationReason(reason string) error {
+	for _, r := range RevocationReasons {
+		if r == reason {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown revocation reason %q, must be one of none, key-compromise, ca-compromise, affiliation-changed, superseded or cessation-of-operation", ErrPermanent, reason)
+}
+
+// Verifier is implemented by Signers which check each certificate after it
+// has been picked up, before it is delivered to the requester.
+type Verifier interface {
+	// Verify returns an error wrapping ErrPermanent if the certificate must
+	// not be delivered for the CSR.
+	Verify(csr capi.CertificateSigningRequest, certificate []byte) error
+}
+
+// ChainReader is implemented by Signers which are able to read their CA chain
+// from their configuration or backend, before any certificate is picked up.
+type ChainReader interface {
+	// CAChain returns the PEM encoded CA chain, or nil if it is not known.
+	CAChain(ctx context.Context) ([]byte, error)
+}
+
+// HealthChecker is implemented by Signers which are able to report whether
+// their backend is able to sign certificates.
+type HealthChecker interface {
+	// Health returns an error if the backend is not able to sign
+	// certificates.
+	Health(ctx context.Context) error
+}
+
+// DurationIgnorer is implemented by Signers whose backend may not be able to
+// issue certificates with the requested duration.
+type DurationIgnorer interface {
+	// IgnoresDuration returns true if the requested duration is ignored, so
+	// that it should be dropped from the CSRs before they are signed.
+	IgnoresDuration() bool
+}
@@ -0,0 +1,328 @@
+// Package vault implements signer.Signer using the PKI secrets engine of
+// HashiCorp Vault.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	capi "k8s.io/api/certificates/v1beta1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/chain"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/trust"
+)
+
+// Auth is a Vault authentication method.
+type Auth interface {
+	// LoginRequest returns the path, relative to /v1/, and body of the login
+	// request.
+	LoginRequest() (path string, body map[string]interface{}, err error)
+}
+
+// KubernetesAuth authenticates using the token of the ServiceAccount of the
+// controller, which is read from TokenFile at every login so that rotated
+// tokens are used.
+type KubernetesAuth struct {
+	// Path is the mount path of the auth method. E.g. kubernetes
+	Path      string
+	Role      string
+	TokenFile string
+}
+
+func (o *KubernetesAuth) LoginRequest() (string, map[string]interface{}, error) {
+	jwt, err := ioutil.ReadFile(o.TokenFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading ServiceAccount token: %v", err)
+	}
+	return "auth/" + o.Path + "/login", map[string]interface{}{
+		"role": o.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	}, nil
+}
+
+// AppRoleAuth authenticates using an AppRole role ID and secret ID.
+type AppRoleAuth struct {
+	// Path is the mount path of the auth method. E.g. approle
+	Path     string
+	RoleID   string
+	SecretID string
+}
+
+func (o *AppRoleAuth) LoginRequest() (string, map[string]interface{}, error) {
+	return "auth/" + o.Path + "/login", map[string]interface{}{
+		"role_id":   o.RoleID,
+		"secret_id": o.SecretID,
+	}, nil
+}
+
+// Signer implements signer.Signer by submitting CSRs to the sign endpoint of a
+// Vault PKI role.
+// Vault signs synchronously, so the pickup ID is the serial number of the
+// certificate, which is read back from Vault by Pickup, and used to revoke it.
+type Signer struct {
+	// Address is the URL of the Vault server. E.g. https://vault:8200
+	Address string
+	// Path is the mount path of the PKI secrets engine. E.g. pki
+	Path string
+	// Role is the PKI role used to sign certificates.
+	Role string
+	Auth Auth
+	// HTTPClient, if set, is used instead of a client with the
+	// DefaultTimeout. It should have a Timeout.
+	HTTPClient *http.Client
+	Log        logr.Logger
+	// Chain, if set, is updated with the CA chain of the PKI secrets engine.
+	Chain *trust.Chain
+	// Assembler, if set, assembles and validates the certificate chain which
+	// is returned by Pickup. Otherwise only the leaf certificate is returned.
+	Assembler *chain.Assembler
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+var (
+	_ signer.Signer      = &Signer{}
+	_ signer.Revoker     = &Signer{}
+	_ signer.ChainReader = &Signer{}
+)
+
+// The renewal margin of Vault tokens
+const tokenExpiryMargin = 30 * time.Second
+
+// DefaultTimeout is the timeout of the requests to Vault, unless the
+// HTTPClient has its own timeout.
+const DefaultTimeout = 30 * time.Second
+
+// defaultHTTPClient is used if the Signer has no HTTPClient, so that requests
+// to an unresponsive Vault server do not block the reconcilers.
+var defaultHTTPClient = &http.Client{Timeout: DefaultTimeout}
+
+// response is the body of a Vault API response.
+type response struct {
+	Data   map[string]interface{} `json:"data"`
+	Auth   *authResponse          `json:"auth"`
+	Errors []string               `json:"errors"`
+}
+
+type authResponse struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+}
+
+// Sign submits the CSR to the sign endpoint of the role, with the duration
+// requested by the CSR annotation as the TTL.
+// CA certificates can not be requested, because the sign endpoint only issues
+// leaf certificates.
+func (o *Signer) Sign(csr capi.CertificateSigningRequest) (string, error) {
+	log := o.Log.WithName("Sign")
+
+	duration, err := capihelper.GetRequestDuration(&csr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", signer.ErrPermanent, err)
+	}
+	isCA, err := capihelper.GetRequestIsCA(&csr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", signer.ErrPermanent, err)
+	}
+	if isCA {
+		return "", fmt.Errorf("%w: CA certificates are not supported by the vault backend", signer.ErrPermanent)
+	}
+
+	body := map[string]interface{}{
+		"csr":    string(csr.Spec.Request),
+		"format": "pem",
+	}
+	if duration > 0 {
+		body["ttl"] = fmt.Sprintf("%ds", int64(duration.Seconds()))
+	}
+
+	log.V(1).Info("Requesting certificate", "role", o.Role, "duration", duration)
+	resp, err := o.do(http.MethodPost, o.Path+"/sign/"+o.Role, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	serial, _ := resp.Data["serial_number"].(string)
+	if serial == "" {
+		return "", fmt.Errorf("vault response has no serial number")
+	}
+	return serial, nil
+}
+
+// Pickup reads the certificate with the serial number from Vault.
+func (o *Signer) Pickup(pickupID string) ([]byte, error) {
+	log := o.Log.WithName("Pickup")
+
+	log.V(1).Info("Retrieving certificate", "pickup-id", pickupID)
+	resp, err := o.do(http.MethodGet, o.Path+"/cert/"+pickupID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve certificate: %w", err)
+	}
+	leafPEM, _ := resp.Data["certificate"].(string)
+	if leafPEM == "" {
+		return nil, fmt.Errorf("%w: vault response has no certificate", signer.ErrPermanent)
+	}
+	if o.Chain == nil && o.Assembler == nil {
+		return []byte(leafPEM), nil
+	}
+
+	caPEM, err := o.caChain()
+	if err != nil {
+		return nil, err
+	}
+	if o.Chain != nil {
+		o.Chain.Set([]byte(caPEM))
+	}
+	if o.Assembler == nil {
+		return []byte(leafPEM), nil
+	}
+	certificate, err := o.Assembler.Assemble([]byte(leafPEM), []byte(caPEM))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", signer.ErrPermanent, err)
+	}
+	return certificate, nil
+}
+
+// Revoke revokes the certificate with the serial number.
+// Vault does not record a revocation reason, so the reason is only logged.
+func (o *Signer) Revoke(pickupID string, reason string) error {
+	log := o.Log.WithName("Revoke")
+
+	log.V(1).Info("Revoking certificate", "pickup-id", pickupID, "reason", reason)
+	if _, err := o.do(http.MethodPost, o.Path+"/revoke", map[string]interface{}{"serial_number": pickupID}); err != nil {
+		return fmt.Errorf("failed to revoke certificate: %w", err)
+	}
+	return nil
+}
+
+// Cancel revokes the certificate with the serial number, because Vault has no
+// pending requests.
+func (o *Signer) Cancel(pickupID string) error {
+	return o.Revoke(pickupID, "cessation-of-operation")
+}
+
+// do sends a request to the Vault API, logging in first if necessary.
+// If the token is rejected, it logs in again and retries once.
+// CAChain reads the CA chain of the PKI secrets engine from Vault.
+func (o *Signer) CAChain(ctx context.Context) ([]byte, error) {
+	caPEM, err := o.caChain()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(caPEM), nil
+}
+
+func (o *Signer) caChain() (string, error) {
+	resp, err := o.do(http.MethodGet, o.Path+"/cert/ca_chain", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve CA chain: %w", err)
+	}
+	caPEM, _ := resp.Data["certificate"].(string)
+	return caPEM, nil
+}
+
+func (o *Signer) do(method, path string, body map[string]interface{}) (*response, error) {
+	token, err := o.login(false)
+	if err != nil {
+		return nil, err
+	}
+	resp, status, err := o.send(method, path, token, body)
+	if status == http.StatusForbidden {
+		if token, err = o.login(true); err != nil {
+			return nil, err
+		}
+		resp, _, err = o.send(method, path, token, body)
+	}
+	return resp, err
+}
+
+// login returns the current token, logging in if there is no valid token or
+// if force is true.
+func (o *Signer) login(force bool) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !force && o.token != "" && time.Now().Before(o.tokenExpiry) {
+		return o.token, nil
+	}
+
+	path, body, err := o.Auth.LoginRequest()
+	if err != nil {
+		return "", err
+	}
+	o.Log.WithName("login").V(1).Info("Logging in", "path", path)
+	resp, _, err := o.send(http.MethodPost, path, "", body)
+	if err != nil {
+		return "", fmt.Errorf("failed to log in to vault: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault login response has no token")
+	}
+	o.token = resp.Auth.ClientToken
+	o.tokenExpiry = time.Now().Add(time.Duration(resp.Auth.LeaseDuration)*time.Second - tokenExpiryMargin)
+	return o.token, nil
+}
+
+// send sends a request to the Vault API and returns the decoded response and
+// the HTTP status code.
+// Errors for server errors, rate limiting and connection failures wrap
+// signer.ErrTemporary, and errors for invalid requests wrap
+// signer.ErrPermanent.
+func (o *Signer) send(method, path, token string, body map[string]interface{}) (*response, int, error) {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return nil, 0, err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(o.Address, "/")+"/v1/"+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, 0, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := o.HTTPClient
+	if httpClient == nil {
+		httpClient = defaultHTTPClient
+	}
+	httpResp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", signer.ErrTemporary, err)
+	}
+	defer httpResp.Body.Close()
+
+	var resp response
+	if data, err := ioutil.ReadAll(httpResp.Body); err == nil && len(data) > 0 {
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, httpResp.StatusCode, fmt.Errorf("error decoding vault response: %v", err)
+		}
+	}
+
+	status := httpResp.StatusCode
+	switch {
+	case status >= 200 && status < 300:
+		return &resp, status, nil
+	case status == http.StatusTooManyRequests || status == http.StatusPreconditionFailed || status >= 500:
+		return nil, status, fmt.Errorf("%w: vault returned %d: %s", signer.ErrTemporary, status, strings.Join(resp.Errors, ", "))
+	case status == http.StatusBadRequest || status == http.StatusNotFound && strings.Contains(path, "/cert/"):
+		return nil, status, fmt.Errorf("%w: vault returned %d: %s", signer.ErrPermanent, status, strings.Join(resp.Errors, ", "))
+	default:
+		return nil, status, fmt.Errorf("vault returned %d: %s", status, strings.Join(resp.Errors, ", "))
+	}
+}
@@ -0,0 +1,292 @@
+package vault_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/zapr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/vault"
+)
+
+const (
+	sampleCertificate = "-----BEGIN CERTIFICATE-----\nbGVhZg==\n-----END CERTIFICATE-----\n"
+	sampleSerial      = "11:22:33"
+)
+
+// fakeVault is an httptest stand-in for the Vault API, with a PKI secrets
+// engine mounted at pki, a role named web, and Kubernetes and AppRole auth
+// methods.
+type fakeVault struct {
+	*httptest.Server
+	t *testing.T
+
+	mu sync.Mutex
+	// signStatus, if set, is returned by the sign endpoint.
+	signStatus int
+	// tokens are the valid tokens.
+	tokens  map[string]bool
+	logins  int
+	signed  map[string]interface{}
+	revoked []string
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	v := &fakeVault{t: t, tokens: map[string]bool{}}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.handle))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func (v *fakeVault) handle(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var body map[string]interface{}
+	if r.Method == http.MethodPost {
+		require.NoError(v.t, json.NewDecoder(r.Body).Decode(&body))
+	}
+	reply := func(status int, resp interface{}) {
+		w.WriteHeader(status)
+		require.NoError(v.t, json.NewEncoder(w).Encode(resp))
+	}
+
+	switch r.URL.Path {
+	case "/v1/auth/kubernetes/login":
+		if body["role"] != "signer" || body["jwt"] != "sa-token" {
+			reply(http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid role or JWT"}})
+			return
+		}
+		v.login(reply)
+		return
+	case "/v1/auth/approle/login":
+		if body["role_id"] != "role-id" || body["secret_id"] != "secret-id" {
+			reply(http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+			return
+		}
+		v.login(reply)
+		return
+	}
+
+	if !v.tokens[r.Header.Get("X-Vault-Token")] {
+		reply(http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	switch {
+	case r.URL.Path == "/v1/pki/sign/web":
+		if v.signStatus != 0 {
+			reply(v.signStatus, map[string]interface{}{"errors": []string{"sign failed"}})
+			return
+		}
+		v.signed = body
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"certificate":   sampleCertificate,
+			"serial_number": sampleSerial,
+		}})
+	case r.URL.Path == "/v1/pki/cert/"+sampleSerial:
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"certificate": sampleCertificate,
+		}})
+	case r.URL.Path == "/v1/pki/cert/ca_chain":
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"certificate": sampleCertificate,
+		}})
+	case r.URL.Path == "/v1/pki/revoke":
+		v.revoked = append(v.revoked, body["serial_number"].(string))
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{}})
+	case strings.HasPrefix(r.URL.Path, "/v1/pki/cert/"):
+		reply(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	default:
+		reply(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func (v *fakeVault) login(reply func(int, interface{})) {
+	v.logins++
+	token := "token-" + string(rune('a'+v.logins))
+	v.tokens[token] = true
+	reply(http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{
+		"client_token":   token,
+		"lease_duration": 3600,
+	}})
+}
+
+// revokeTokens invalidates all tokens, as if they had expired.
+func (v *fakeVault) revokeTokens() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.tokens = map[string]bool{}
+}
+
+func generateCSR(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "app.example.com"},
+		DNSNames: []string{"app.example.com"},
+	}, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func newSigner(t *testing.T, v *fakeVault, auth vault.Auth) *vault.Signer {
+	return &vault.Signer{
+		Address: v.URL,
+		Path:    "pki",
+		Role:    "web",
+		Auth:    auth,
+		Log:     zapr.NewLogger(zaptest.NewLogger(t)).WithName("Signer"),
+	}
+}
+
+func TestSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("sa-token\n"), 0600))
+
+	tests := []struct {
+		name string
+		auth vault.Auth
+	}{
+		{
+			name: "KubernetesAuth",
+			auth: &vault.KubernetesAuth{Path: "kubernetes", Role: "signer", TokenFile: tokenFile},
+		},
+		{
+			name: "AppRoleAuth",
+			auth: &vault.AppRoleAuth{Path: "approle", RoleID: "role-id", SecretID: "secret-id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newFakeVault(t)
+			s := newSigner(t, v, tt.auth)
+			csr := capi.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{capihelper.AnnotationKeyRequestDuration: "2h"},
+				},
+				Spec: capi.CertificateSigningRequestSpec{Request: generateCSR(t)},
+			}
+
+			pickupID, err := s.Sign(csr)
+			require.NoError(t, err)
+			assert.Equal(t, sampleSerial, pickupID)
+			assert.Equal(t, string(csr.Spec.Request), v.signed["csr"])
+			assert.Equal(t, "7200s", v.signed["ttl"])
+
+			// A new token is obtained if the token has expired.
+			v.revokeTokens()
+			certificate, err := s.Pickup(pickupID)
+			require.NoError(t, err)
+			assert.Equal(t, sampleCertificate, string(certificate))
+			assert.Equal(t, 2, v.logins)
+
+			require.NoError(t, s.Revoke(pickupID, "superseded"))
+			assert.Equal(t, []string{sampleSerial}, v.revoked)
+		})
+	}
+}
+
+// TestSigner_CAChain verifies that the CA chain is read from Vault.
+func TestSigner_CAChain(t *testing.T) {
+	v := newFakeVault(t)
+	s := newSigner(t, v, &vault.AppRoleAuth{Path: "approle", RoleID: "role-id", SecretID: "secret-id"})
+	caChain, err := s.CAChain(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, sampleCertificate, string(caChain))
+}
+
+func TestSigner_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		signStatus int
+		auth       vault.Auth
+		isCA       bool
+		wantErr    error
+	}{
+		{
+			name:       "ErrorTemporaryUnavailable",
+			signStatus: http.StatusServiceUnavailable,
+			wantErr:    signer.ErrTemporary,
+		},
+		{
+			name:       "ErrorTemporaryRateLimited",
+			signStatus: http.StatusTooManyRequests,
+			wantErr:    signer.ErrTemporary,
+		},
+		{
+			name:       "ErrorPermanentBadRequest",
+			signStatus: http.StatusBadRequest,
+			wantErr:    signer.ErrPermanent,
+		},
+		{
+			name:    "ErrorPermanentCA",
+			isCA:    true,
+			wantErr: signer.ErrPermanent,
+		},
+		{
+			name: "ErrorLogin",
+			auth: &vault.AppRoleAuth{Path: "approle", RoleID: "role-id", SecretID: "wrong"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newFakeVault(t)
+			v.signStatus = tt.signStatus
+			auth := tt.auth
+			if auth == nil {
+				auth = &vault.AppRoleAuth{Path: "approle", RoleID: "role-id", SecretID: "secret-id"}
+			}
+			s := newSigner(t, v, auth)
+			csr := capi.CertificateSigningRequest{
+				Spec: capi.CertificateSigningRequestSpec{Request: generateCSR(t)},
+			}
+			if tt.isCA {
+				csr.Annotations = map[string]string{capihelper.AnnotationKeyRequestIsCA: "true"}
+			}
+			_, err := s.Sign(csr)
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("ErrorPermanentUnknownSerial", func(t *testing.T) {
+		v := newFakeVault(t)
+		s := newSigner(t, v, &vault.AppRoleAuth{Path: "approle", RoleID: "role-id", SecretID: "secret-id"})
+		_, err := s.Pickup("44:55:66")
+		assert.True(t, errors.Is(err, signer.ErrPermanent), "expected ErrPermanent, got %v", err)
+	})
+	t.Run("ErrorTemporaryConnection", func(t *testing.T) {
+		v := newFakeVault(t)
+		v.Close()
+		s := newSigner(t, v, &vault.AppRoleAuth{Path: "approle", RoleID: "role-id", SecretID: "secret-id"})
+		_, err := s.Pickup(sampleSerial)
+		assert.True(t, errors.Is(err, signer.ErrTemporary), "expected ErrTemporary, got %v", err)
+	})
+}
@@ -1,8 +1,12 @@
 package venafi
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/Venafi/vcert/pkg/certificate"
@@ -11,25 +15,81 @@ import (
 	"github.com/jetstack/cert-manager/pkg/util/pki"
 	capi "k8s.io/api/certificates/v1beta1"
 
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/breaker"
+	"github.com/cert-manager/signer-venafi/internal/chain"
 	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/trust"
 )
 
+// The duration of the template from which the vcert request is generated.
+// vcert does not send it to Venafi, which decides the validity of the
+// certificate from the zone policy.
+const templateDuration = time.Hour * 24
+
 // Signer implements signer.Signer by sending CSRs to a Venafi TPP or Venafi
 // Cloud service, using the supplied vcert client.
 type Signer struct {
 	ClientFactory func() (endpoint.Connector, error)
 	Log           logr.Logger
+	// Zone, if set, is the Venafi zone to which certificates are requested,
+	// instead of the zone in the vcert configuration.
+	Zone string
+	// IssuesCA is set if the zone uses a CA template, in which case CSRs
+	// requesting isCA are accepted. Otherwise they are rejected, because
+	// whether a certificate is a CA is decided by the zone, not by the CSR.
+	IssuesCA bool
+	// ZoneChain, if set, is the PEM encoded CA chain of the zone, which
+	// vcert is not able to read from Venafi before a certificate is issued.
+	ZoneChain []byte
+	// Chain, if set, is updated with the CA chain returned with each
+	// certificate.
+	Chain *trust.Chain
+	// Assembler, if set, assembles and validates the certificate chain which
+	// is returned by Pickup. Otherwise only the leaf certificate is returned.
+	Assembler *chain.Assembler
+	// Breaker, if set, stops the calls to Venafi after repeated failures,
+	// and fails them with an error wrapping signer.ErrTemporary until
+	// Venafi recovers.
+	Breaker *breaker.Breaker
 }
 
-var _ signer.Signer = &Signer{}
+var (
+	_ signer.Signer          = &Signer{}
+	_ signer.Revoker         = &Signer{}
+	_ signer.HealthChecker   = &Signer{}
+	_ signer.ChainReader     = &Signer{}
+	_ signer.DurationIgnorer = &Signer{}
+)
+
+// IgnoresDuration returns true, because the validity of the certificates is
+// decided by the Venafi zone policy.
+func (o *Signer) IgnoresDuration() bool {
+	return true
+}
 
 func (o *Signer) Sign(csr capi.CertificateSigningRequest) (string, error) {
 	log := o.Log.WithName("Sign")
 
-	log.V(1).Info("Generating template from CSR")
-	tmpl, err := pki.GenerateTemplateFromCSRPEM(csr.Spec.Request, time.Hour*24, false)
+	// vcert can not send the requested duration or isCA to Venafi.
+	// The duration is ignored, as reported by IgnoresDuration, but CSRs
+	// requesting a CA certificate are rejected unless the zone issues them.
+	if _, ok := csr.Annotations[capihelper.AnnotationKeyRequestDuration]; ok {
+		log.V(1).Info("Ignoring the requested duration, which is decided by the Venafi zone policy")
+	}
+	isCA, err := capihelper.GetRequestIsCA(&csr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", signer.ErrPermanent, err)
+	}
+	if isCA && !o.IssuesCA {
+		return "", fmt.Errorf("%w: CA certificates can not be requested from a Venafi zone which does not use a CA template",
+			signer.ErrPermanent)
+	}
+
+	log.V(1).Info("Generating template from CSR", "is-ca", isCA)
+	tmpl, err := pki.GenerateTemplateFromCSRPEM(csr.Spec.Request, templateDuration, isCA)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate template from CSR PEM: %v", err)
+		return "", fmt.Errorf("%w: failed to generate template from CSR PEM: %v", signer.ErrPermanent, err)
 	}
 
 	log.V(1).Info("Generating vreq")
@@ -38,32 +98,159 @@ func (o *Signer) Sign(csr capi.CertificateSigningRequest) (string, error) {
 	vreq.CSR = csr.Spec.Request
 
 	log.V(1).Info("Requesting certificate")
-	client, err := o.ClientFactory()
-	if err != nil {
-		return "", fmt.Errorf("failed to initialise vcert client: %s", err)
-	}
-
-	pickupID, err := client.RequestCertificate(vreq, "")
-	if err != nil {
-		return "", fmt.Errorf("failed to request certificate: %v", err)
-	}
-	return pickupID, nil
+	var pickupID string
+	err = o.connect(func(client endpoint.Connector) error {
+		var err error
+		pickupID, err = client.RequestCertificate(vreq, o.Zone)
+		if err != nil {
+			return fmt.Errorf("failed to request certificate: %w", err)
+		}
+		return nil
+	})
+	return pickupID, err
 }
 
 func (o *Signer) Pickup(pickupID string) ([]byte, error) {
 	log := o.Log.WithName("Pickup")
 
 	log.V(1).Info("Retrieving certificate", "pickup-id", pickupID)
-	client, err := o.ClientFactory()
+	var (
+		certs   *certificate.PEMCollection
+		pending error
+	)
+	err := o.connect(func(client endpoint.Connector) error {
+		var err error
+		certs, err = client.RetrieveCertificate(&certificate.Request{PickupID: pickupID})
+		if err != nil {
+			if errors.Is(err, endpoint.ErrCertificatePending{}) {
+				// Venafi answered, so this is not a failure of the
+				// Breaker.
+				pending = fmt.Errorf("%w: certificate not ready: %s", signer.ErrTemporary, err)
+				return nil
+			}
+			return fmt.Errorf("failed to retrieve certificate: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialise vcert client: %s", err)
+		return nil, err
+	}
+	if pending != nil {
+		return nil, pending
+	}
+	caPEM := []byte(strings.Join(certs.Chain, "\n"))
+	if o.Chain != nil && len(caPEM) > 0 {
+		o.Chain.Set(caPEM)
 	}
-	certs, err := client.RetrieveCertificate(&certificate.Request{PickupID: pickupID})
+	if o.Assembler == nil {
+		return []byte(certs.Certificate), nil
+	}
+	certificate, err := o.Assembler.Assemble([]byte(certs.Certificate), caPEM)
 	if err != nil {
-		if errors.Is(err, endpoint.ErrCertificatePending{}) {
-			return nil, fmt.Errorf("%w: certificate not ready: %s", signer.ErrTemporary, err)
+		return nil, fmt.Errorf("%w: %v", signer.ErrPermanent, err)
+	}
+	return certificate, nil
+}
+
+// Revoke revokes the certificate with the supplied pickup ID, which for Venafi
+// TPP is the DN of the certificate object.
+// The reason must be one of signer.RevocationReasons, which are the
+// revocation reasons supported by vcert.
+func (o *Signer) Revoke(pickupID string, reason string) error {
+	log := o.Log.WithName("Revoke")
+
+	if err := signer.ValidateRevocationReason(reason); err != nil {
+		return err
+	}
+	log.V(1).Info("Revoking certificate", "pickup-id", pickupID, "reason", reason)
+	return o.connect(func(client endpoint.Connector) error {
+		err := client.RevokeCertificate(&certificate.RevocationRequest{
+			CertificateDN: pickupID,
+			Reason:        reason,
+			Comments:      "Revoked by signer-venafi",
+		})
+		if err != nil {
+			return fmt.Errorf("failed to revoke certificate: %w", revocationError(err))
+		}
+		return nil
+	})
+}
+
+// Cancel disables the certificate object with the supplied pickup ID so that
+// a pending request will not be issued.
+func (o *Signer) Cancel(pickupID string) error {
+	log := o.Log.WithName("Cancel")
+
+	log.V(1).Info("Cancelling certificate request", "pickup-id", pickupID)
+	return o.connect(func(client endpoint.Connector) error {
+		err := client.RevokeCertificate(&certificate.RevocationRequest{
+			CertificateDN: pickupID,
+			Reason:        "cessation-of-operation",
+			Comments:      "Request cancelled by signer-venafi",
+			Disable:       true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to cancel certificate request: %w", revocationError(err))
+		}
+		return nil
+	})
+}
+
+// CAChain returns the ZoneChain.
+func (o *Signer) CAChain(ctx context.Context) ([]byte, error) {
+	return o.ZoneChain, nil
+}
+
+// revocationError wraps the errors of vcert RevokeCertificate which are
+// returned when TPP refuses the revocation, such as for an unknown
+// certificate object or a request which can not be disabled, with
+// signer.ErrPermanent. Other errors, such as connection errors, are retried.
+func revocationError(err error) error {
+	if strings.HasPrefix(err.Error(), "Revocation error:") {
+		return fmt.Errorf("%w: %v", signer.ErrPermanent, err)
+	}
+	return err
+}
+
+// serverErrorStatus matches the HTTP status of a server error in the errors of
+// vcert, such as "Status: 503 Service Unavailable".
+var serverErrorStatus = regexp.MustCompile(`Status: 5\d\d\b`)
+
+// temporaryError wraps the errors of vcert which mean that Venafi did not
+// answer, which are connection errors, timeouts and server errors, with
+// signer.ErrTemporary.
+func temporaryError(err error) error {
+	if err == nil || errors.Is(err, signer.ErrTemporary) || errors.Is(err, signer.ErrPermanent) {
+		return err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || serverErrorStatus.MatchString(err.Error()) {
+		return fmt.Errorf("%w: %v", signer.ErrTemporary, err)
+	}
+	return err
+}
+
+// Health returns an error while the Breaker is open or half-open.
+func (o *Signer) Health(ctx context.Context) error {
+	if o.Breaker == nil {
+		return nil
+	}
+	return o.Breaker.Health()
+}
+
+// connect calls f with a new vcert client, through the Breaker if it is set.
+// The errors which mean that Venafi did not answer are wrapped with
+// signer.ErrTemporary, so that only they are counted by the Breaker.
+func (o *Signer) connect(f func(client endpoint.Connector) error) error {
+	call := func() error {
+		client, err := o.ClientFactory()
+		if err != nil {
+			return temporaryError(fmt.Errorf("failed to initialise vcert client: %w", err))
 		}
-		return nil, fmt.Errorf("failed to retrieve certificate: %v", err)
+		return temporaryError(f(client))
+	}
+	if o.Breaker == nil {
+		return call()
 	}
-	return []byte(certs.Certificate), nil
+	return o.Breaker.Do(call)
 }
@@ -8,14 +8,20 @@ package venafi_test
 // credentials for your Venafi server.
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"net"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/Venafi/vcert"
 	"github.com/Venafi/vcert/pkg/endpoint"
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/breaker"
+	"github.com/cert-manager/signer-venafi/internal/chain"
 	"github.com/cert-manager/signer-venafi/internal/signer"
 	"github.com/cert-manager/signer-venafi/internal/signer/venafi"
 	"github.com/go-logr/zapr"
@@ -23,6 +29,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
 	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // sampleCSR is generated according to instructions in
@@ -47,10 +54,8 @@ OKbMbQNLoXS2f6qrS1Iqv4xxvHdDncH4zdhJiLdRqUJrSjPgMQ==
 -----END CERTIFICATE REQUEST-----
 `
 
-// TestSigner verifies the happy path of a successful signer.Sign immediately
-// followed by a signer.Pickup.
-// TODO: Test error cases (connection errors, authentication errors etc)
-func TestSigner(t *testing.T) {
+// newSigner returns a Signer using the vcert configuration of the tests.
+func newSigner(t *testing.T) *venafi.Signer {
 	vcertConfigFile := os.Getenv("VCERT_CONFIG_FILE")
 	if vcertConfigFile == "" {
 		vcertConfigFile = "testdata/vcert.ini"
@@ -65,13 +70,16 @@ func TestSigner(t *testing.T) {
 	vcertClient, err := vcert.NewClient(vconf)
 	require.NoError(t, err)
 
-	s := &venafi.Signer{
+	return &venafi.Signer{
 		ClientFactory: func() (endpoint.Connector, error) {
 			return vcertClient, nil
 		},
 		Log: zapr.NewLogger(zaptest.NewLogger(t)).WithName("Signer"),
 	}
+}
 
+// signAndPickup signs the sample CSR and waits for the certificate.
+func signAndPickup(t *testing.T, s *venafi.Signer) []byte {
 	csr := capi.CertificateSigningRequest{
 		Spec: capi.CertificateSigningRequestSpec{
 			Request: []byte(sampleCSR),
@@ -92,8 +100,183 @@ func TestSigner(t *testing.T) {
 		require.NoError(t, err)
 		return false
 	}, 30*time.Second, 5*time.Second)
+	return cert
+}
+
+// TestSigner verifies the happy path of a successful signer.Sign immediately
+// followed by a signer.Pickup.
+// TODO: Test error cases (connection errors, authentication errors etc)
+func TestSigner(t *testing.T) {
+	cert := signAndPickup(t, newSigner(t))
 
 	block, rest := pem.Decode(cert)
 	assert.Empty(t, rest)
 	assert.Equal(t, "CERTIFICATE", block.Type)
+
+	// The validity is decided by the Venafi zone policy.
+	issued, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	now := time.Now()
+	assert.True(t, issued.NotBefore.Before(now), "NotBefore %s should be in the past", issued.NotBefore)
+	assert.True(t, issued.NotAfter.After(now), "NotAfter %s should be in the future", issued.NotAfter)
+	assert.False(t, issued.IsCA)
+}
+
+// TestSigner_RequestedDuration verifies that the requested duration, which
+// can not be sent to Venafi, is ignored rather than failing the CSR.
+func TestSigner_RequestedDuration(t *testing.T) {
+	s := newSigner(t)
+	assert.True(t, s.IgnoresDuration())
+	for _, duration := range []string{"1h", "forever"} {
+		_, err := s.Sign(capi.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{capihelper.AnnotationKeyRequestDuration: duration},
+			},
+			Spec: capi.CertificateSigningRequestSpec{
+				Request: []byte(sampleCSR),
+			},
+		})
+		assert.NoError(t, err, duration)
+	}
+}
+
+// TestSigner_RequestedProperties verifies that CSRs requesting isCA, which can
+// not be sent to Venafi, are rejected without calling Venafi.
+func TestSigner_RequestedProperties(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		issuesCA    bool
+	}{
+		{
+			name:        "isCA",
+			annotations: map[string]string{capihelper.AnnotationKeyRequestIsCA: "true"},
+		},
+		{
+			name:        "invalid isCA",
+			annotations: map[string]string{capihelper.AnnotationKeyRequestIsCA: "yes please"},
+			issuesCA:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSigner(t)
+			s.IssuesCA = tt.issuesCA
+			s.ClientFactory = func() (endpoint.Connector, error) {
+				t.Fatal("Venafi should not be called")
+				return nil, nil
+			}
+			_, err := s.Sign(capi.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+				Spec: capi.CertificateSigningRequestSpec{
+					Request: []byte(sampleCSR),
+				},
+			})
+			assert.True(t, errors.Is(err, signer.ErrPermanent), err)
+		})
+	}
+}
+
+// TestSigner_Assembler verifies that the CA chain returned by Venafi follows
+// the leaf certificate when the full chain is requested.
+func TestSigner_Assembler(t *testing.T) {
+	s := newSigner(t)
+	s.Assembler = &chain.Assembler{Mode: chain.ModeFull}
+	cert := signAndPickup(t, s)
+
+	var blocks int
+	for block, rest := pem.Decode(cert); block != nil; block, rest = pem.Decode(rest) {
+		blocks++
+	}
+	assert.GreaterOrEqual(t, blocks, 2)
+}
+
+// TestSigner_Breaker verifies that the Breaker stops the calls to Venafi
+// after repeated failures, and that the signer reports it is unhealthy.
+func TestSigner_Breaker(t *testing.T) {
+	s := newSigner(t)
+	calls := 0
+	s.ClientFactory = func() (endpoint.Connector, error) {
+		calls++
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	s.Breaker = &breaker.Breaker{
+		SignerName:       "example.com/venafi-breaker",
+		Log:              s.Log,
+		FailureThreshold: 2,
+		OpenDuration:     time.Hour,
+	}
+	require.NoError(t, s.Health(context.Background()))
+
+	for i := 0; i < 2; i++ {
+		_, err := s.Pickup("pickup-id")
+		assert.True(t, errors.Is(err, signer.ErrTemporary), err)
+		assert.Contains(t, err.Error(), "failed to initialise vcert client: dial tcp: connection refused")
+	}
+	_, err := s.Pickup("pickup-id")
+	assert.True(t, errors.Is(err, breaker.ErrOpen), err)
+	assert.Equal(t, 2, calls, "no call should be made while the breaker is open")
+	assert.Error(t, s.Health(context.Background()))
+}
+
+// TestSigner_BreakerErrors verifies that only the errors which mean that
+// Venafi did not answer are temporary, and counted by the Breaker.
+func TestSigner_BreakerErrors(t *testing.T) {
+	type testCase struct {
+		name          string
+		err           error
+		wantTemporary bool
+	}
+	tests := []testCase{
+		{
+			name:          "ConnectionError",
+			err:           &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			wantTemporary: true,
+		},
+		{
+			name:          "ServerError",
+			err:           errors.New("Unexpected status code on TPP Authorize. Status: 503 Service Unavailable"),
+			wantTemporary: true,
+		},
+		{
+			name: "ClientError",
+			err:  errors.New("Unexpected status code on TPP Authorize. Status: 401 Unauthorized"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newSigner(t)
+			s.ClientFactory = func() (endpoint.Connector, error) {
+				return nil, tc.err
+			}
+			s.Breaker = &breaker.Breaker{
+				SignerName:       "example.com/venafi-breaker-errors",
+				Instance:         tc.name,
+				Log:              s.Log,
+				FailureThreshold: 1,
+				OpenDuration:     time.Hour,
+			}
+
+			_, err := s.Pickup("pickup-id")
+			require.Error(t, err)
+			assert.Equal(t, tc.wantTemporary, errors.Is(err, signer.ErrTemporary), err)
+			if tc.wantTemporary {
+				assert.Equal(t, breaker.StateOpen, s.Breaker.State())
+			} else {
+				assert.Equal(t, breaker.StateClosed, s.Breaker.State())
+			}
+		})
+	}
+}
+
+// TestSigner_RevokeInvalidReason verifies that an unknown revocation reason is
+// refused with a permanent error, without calling Venafi.
+func TestSigner_RevokeInvalidReason(t *testing.T) {
+	s := newSigner(t)
+	s.ClientFactory = func() (endpoint.Connector, error) {
+		t.Fatal("Venafi should not be called")
+		return nil, nil
+	}
+	err := s.Revoke("pickup-id", "key-compromised")
+	assert.True(t, errors.Is(err, signer.ErrPermanent), err)
 }
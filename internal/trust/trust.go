@@ -0,0 +1,172 @@
+// Package trust holds the CA chain of the signer, as learnt from the
+// certificates it picks up, so that it can be published to the clients which
+// need to verify those certificates.
+package trust
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"sync"
+	"time"
+)
+
+const (
+	// LabelKeyCABundle and LabelValueCABundle label the objects in which the
+	// chain is published, so that they can be watched without watching every
+	// object of their kind.
+	LabelKeyCABundle   = "trust.signer-venafi.cert-manager.io/ca-bundle"
+	LabelValueCABundle = "true"
+)
+
+// Chain is the PEM encoded CA chain of the signer.
+// When the chain rotates, the certificates of the previous chains remain in
+// the bundle returned by PEM until they expire, so that clients trust both the
+// old and new CAs during the rotation.
+// It is safe for concurrent use.
+type Chain struct {
+	mu          sync.Mutex
+	latest      []*pem.Block
+	previous    []*x509.Certificate
+	subscribers []chan struct{}
+}
+
+// Set replaces the CA chain, and notifies the subscribers if it has changed.
+// Any PEM blocks other than certificates are ignored.
+func (o *Chain) Set(chain []byte) {
+	var blocks []*pem.Block
+	for {
+		var block *pem.Block
+		block, chain = pem.Decode(chain)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			blocks = append(blocks, &pem.Block{Type: block.Type, Bytes: block.Bytes})
+		}
+	}
+	if len(blocks) == 0 {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if equalBlocks(o.latest, blocks) {
+		return
+	}
+	for _, block := range o.latest {
+		o.addPrevious(block)
+	}
+	o.latest = blocks
+	o.notify()
+}
+
+// Merge adds the certificates of a previously published bundle which are not
+// in the current chain to the previous chains, and notifies the subscribers
+// if any were added. This preserves the previous chains across restarts of
+// the signer, during which only the latest chain is learnt.
+func (o *Chain) Merge(bundle []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	added := false
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" && !containsBlock(o.latest, block.Bytes) && o.addPrevious(block) {
+			added = true
+		}
+	}
+	if added {
+		o.notify()
+	}
+}
+
+// PEM returns the PEM encoded CA chain, followed by any unexpired
+// certificates of previous chains, or nil if the chain is not yet known.
+func (o *Chain) PEM() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.latest) == 0 {
+		return nil
+	}
+	var bundle []byte
+	for _, block := range o.latest {
+		bundle = append(bundle, pem.EncodeToMemory(block)...)
+	}
+	now := time.Now()
+	var previous []*x509.Certificate
+	for _, cert := range o.previous {
+		if now.After(cert.NotAfter) {
+			continue
+		}
+		previous = append(previous, cert)
+		if !containsBlock(o.latest, cert.Raw) {
+			bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+		}
+	}
+	o.previous = previous
+	return bundle
+}
+
+// Subscribe returns a channel which receives a value after the chain changes.
+// Multiple changes may be coalesced into one value.
+func (o *Chain) Subscribe() <-chan struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	c := make(chan struct{}, 1)
+	o.subscribers = append(o.subscribers, c)
+	return c
+}
+
+// addPrevious adds the certificate in the PEM block to the previous chains,
+// and returns true if it was not already present and has not expired.
+func (o *Chain) addPrevious(block *pem.Block) bool {
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || time.Now().After(cert.NotAfter) || o.isPrevious(cert) {
+		return false
+	}
+	o.previous = append(o.previous, cert)
+	return true
+}
+
+func (o *Chain) notify() {
+	for _, c := range o.subscribers {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (o *Chain) isPrevious(cert *x509.Certificate) bool {
+	for _, p := range o.previous {
+		if bytes.Equal(p.Raw, cert.Raw) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsBlock(blocks []*pem.Block, der []byte) bool {
+	for _, block := range blocks {
+		if bytes.Equal(block.Bytes, der) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalBlocks(a, b []*pem.Block) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].Bytes, b[i].Bytes) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package trust_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cert-manager/signer-venafi/internal/trust"
+)
+
+const (
+	sampleCA1 = "-----BEGIN CERTIFICATE-----\nY2Ex\n-----END CERTIFICATE-----\n"
+	sampleCA2 = "-----BEGIN CERTIFICATE-----\nY2Ey\n-----END CERTIFICATE-----\n"
+)
+
+func TestChain_Set(t *testing.T) {
+	var c trust.Chain
+	updated := c.Subscribe()
+	assert.Nil(t, c.PEM())
+
+	c.Set([]byte("not PEM"))
+	assert.Nil(t, c.PEM())
+	assert.Len(t, updated, 0)
+
+	c.Set([]byte("\n" + sampleCA1))
+	assert.Equal(t, sampleCA1, string(c.PEM()))
+	assert.Len(t, updated, 1)
+	<-updated
+
+	// Setting the same chain does not notify the subscribers.
+	c.Set([]byte(sampleCA1))
+	assert.Len(t, updated, 0)
+
+	c.Set([]byte(sampleCA2 + sampleCA1))
+	c.Set([]byte(sampleCA1 + sampleCA2))
+	assert.Equal(t, sampleCA1+sampleCA2, string(c.PEM()))
+	// Multiple changes are coalesced.
+	assert.Len(t, updated, 1)
+}
+
+// generateCA returns a PEM encoded self-signed CA certificate which expires
+// at the supplied time.
+func generateCA(t *testing.T, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             notAfter.Add(-48 * time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestChain_PEM(t *testing.T) {
+	oldCA := generateCA(t, time.Now().Add(time.Hour))
+	expiredCA := generateCA(t, time.Now().Add(-time.Hour))
+	newCA := generateCA(t, time.Now().Add(24*time.Hour))
+
+	var c trust.Chain
+	c.Set([]byte(oldCA + expiredCA))
+	assert.Equal(t, oldCA+expiredCA, string(c.PEM()))
+
+	// The unexpired certificates of the previous chain are merged.
+	c.Set([]byte(newCA))
+	assert.Equal(t, newCA+oldCA, string(c.PEM()))
+
+	// Certificates in the latest chain are not duplicated.
+	c.Set([]byte(newCA + oldCA))
+	assert.Equal(t, newCA+oldCA, string(c.PEM()))
+}
+
+func TestChain_Merge(t *testing.T) {
+	oldCA := generateCA(t, time.Now().Add(time.Hour))
+	expiredCA := generateCA(t, time.Now().Add(-time.Hour))
+	newCA := generateCA(t, time.Now().Add(24*time.Hour))
+
+	var c trust.Chain
+	updated := c.Subscribe()
+
+	// The previous chains are only published with a known chain.
+	c.Merge([]byte(oldCA))
+	assert.Nil(t, c.PEM())
+	<-updated
+
+	c.Set([]byte(newCA))
+	<-updated
+	assert.Equal(t, newCA+oldCA, string(c.PEM()))
+
+	// Certificates which are already known, expired or not certificates do
+	// not change the bundle.
+	c.Merge([]byte(newCA + oldCA + expiredCA + "not PEM"))
+	assert.Equal(t, newCA+oldCA, string(c.PEM()))
+	c.Merge([]byte(newCA + oldCA))
+	assert.Len(t, updated, 0)
+}
@@ -17,24 +17,51 @@ limitations under the License.
 package main
 
 import (
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
 	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	certificatesclient "k8s.io/client-go/kubernetes/typed/certificates/v1beta1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
-	"github.com/Venafi/vcert"
-	"github.com/Venafi/vcert/pkg/endpoint"
 	"github.com/cert-manager/signer-venafi/controllers"
+	capihelper "github.com/cert-manager/signer-venafi/internal/api"
+	"github.com/cert-manager/signer-venafi/internal/approval"
+	"github.com/cert-manager/signer-venafi/internal/backend"
+	"github.com/cert-manager/signer-venafi/internal/breaker"
+	"github.com/cert-manager/signer-venafi/internal/chain"
+	"github.com/cert-manager/signer-venafi/internal/filter"
+	"github.com/cert-manager/signer-venafi/internal/policy"
+	"github.com/cert-manager/signer-venafi/internal/records"
+	"github.com/cert-manager/signer-venafi/internal/secrets"
+	"github.com/cert-manager/signer-venafi/internal/signer"
+	"github.com/cert-manager/signer-venafi/internal/signer/shadow"
 	"github.com/cert-manager/signer-venafi/internal/signer/venafi"
+	"github.com/cert-manager/signer-venafi/internal/trust"
 	// +kubebuilder:scaffold:imports
 )
 
+// signerHealthPath is the path, on the metrics server, at which the health of
+// the backends of the signers is served.
+const signerHealthPath = "/signerz"
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -43,20 +70,89 @@ var (
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 	_ = capi.AddToScheme(scheme)
+	_ = cmapi.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var (
 		metricsAddr          string
+		healthAddr           string
 		enableLeaderElection bool
 		leaderElectionID     string
 		debugLogging         bool
 		signerName           string
 		vcertConfigPath      string
+		vcertCAChainPath     string
+		deniedOrganizations  string
+		deniedCommonNames    string
+		allowedIdentities    string
+		spiffeTrustDomain    string
+		dnsOwnership         bool
+		clusterDomain        string
+		allowedKeyAlgorithms string
+		minRSAKeySize        int
+		allowedCurves        string
+		allowedSigAlgorithms string
+		approvalReasons      string
+		approvalUsers        string
+		approvalGroups       string
+		requiredApprovers    int
+		eligibleApprovers    string
+		maxCSRAge            time.Duration
+		maxApprovalAge       time.Duration
+		revokeOnDelete       bool
+		revocationReason     string
+		revokeSuperseded     bool
+		supersededOverlap    time.Duration
+		recordsNamespace     string
+		cleanerInterval      time.Duration
+		issuedRetention      time.Duration
+		failedRetention      time.Duration
+		deniedRetention      time.Duration
+		writeSecrets         bool
+		manageSecrets        bool
+		secretsAutoApprove   bool
+		issuerGroup          string
+		crAutoApprove        bool
+		minDuration          time.Duration
+		maxDuration          time.Duration
+		caSignerNames        string
+		intermediateCA       bool
+		intermediateZone     string
+		maxPathLen           int
+		permittedDNSDomains  string
+		publishCTB           bool
+		ctbAPIVersion        string
+		trustInterval        time.Duration
+		distributeCABundle   bool
+		caBundleSelector     string
+		chainMode            string
+		chainRootFirst       bool
+		trustAnchorsFile     string
+		backendName          string
+		backendConfigPath    string
+		localCASecret        string
+		localCACertFile      string
+		localCAKeyFile       string
+		vaultAddress         string
+		vaultPKIPath         string
+		vaultRole            string
+		vaultAuth            string
+		vaultAuthPath        string
+		vaultK8sRole         string
+		vaultTokenFile       string
+		vaultRoleID          string
+		vaultSecretIDFile    string
+		vaultCAFile          string
+		pluginSocket         string
+		pluginTimeout        time.Duration
+		breakerThreshold     int
+		breakerOpenDuration  time.Duration
 	)
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&healthAddr, "health-addr", ":8081", "The address the readiness and liveness probe endpoints bind to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -64,53 +160,624 @@ func main() {
 		"The name of the configmap used to coordinate leader election between controller-managers.")
 	flag.BoolVar(&debugLogging, "debug-logging", true, "Enable debug logging.")
 	flag.StringVar(&signerName, "signer-name", "example.com/foo", "Only sign CSR with this .spec.signerName.")
+	flag.StringVar(&backendName, "backend", backend.Venafi,
+		fmt.Sprintf("The backend which signs certificates for --signer-name, configured by flags. One of %s, %s, %s or %s.",
+			backend.Venafi, backend.LocalCA, backend.Vault, backend.Plugin))
+	flag.StringVar(&backendConfigPath, "backend-config", "",
+		"A YAML file which selects the backend of each signer name, with its config block and its settings, "+
+			"such as its policy, which default to the flags. "+
+			"If it configures --signer-name, --backend and the flags of the backends are ignored.")
+	flag.StringVar(&pluginSocket, "plugin-socket", "",
+		"The Unix socket of the signer plugin used by the "+backend.Plugin+" backend.")
+	flag.DurationVar(&pluginTimeout, "plugin-timeout", time.Second*30, "The deadline of each call to the signer plugin.")
+	flag.StringVar(&vaultAddress, "vault-address", "", "The URL of the Vault server of the "+backend.Vault+" backend.")
+	flag.StringVar(&vaultPKIPath, "vault-pki-path", "pki", "The mount path of the Vault PKI secrets engine.")
+	flag.StringVar(&vaultRole, "vault-role", "", "The Vault PKI role used to sign certificates.")
+	flag.StringVar(&vaultAuth, "vault-auth", backend.VaultAuthKubernetes,
+		fmt.Sprintf("The Vault authentication method. One of %s or %s.", backend.VaultAuthKubernetes, backend.VaultAuthAppRole))
+	flag.StringVar(&vaultAuthPath, "vault-auth-path", "",
+		"The mount path of the Vault authentication method. Defaults to the name of the method.")
+	flag.StringVar(&vaultK8sRole, "vault-kubernetes-role", "", "The Vault role of the "+backend.VaultAuthKubernetes+" authentication method.")
+	flag.StringVar(&vaultTokenFile, "vault-kubernetes-token-file", "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		"The ServiceAccount token used by the "+backend.VaultAuthKubernetes+" authentication method.")
+	flag.StringVar(&vaultRoleID, "vault-approle-role-id", "", "The role ID of the "+backend.VaultAuthAppRole+" authentication method.")
+	flag.StringVar(&vaultSecretIDFile, "vault-approle-secret-id-file", "",
+		"The file containing the secret ID of the "+backend.VaultAuthAppRole+" authentication method.")
+	flag.StringVar(&vaultCAFile, "vault-ca-file", "",
+		"A PEM file containing the CA certificates used to verify the Vault server. Defaults to the system roots.")
 	flag.StringVar(&vcertConfigPath, "vcert-config", "/etc/signer-venafi/vcert.ini", "Vcert INI file path.")
+	flag.StringVar(&vcertCAChainPath, "vcert-ca-chain-file", "",
+		"The PEM file of the CA chain of the Venafi zone, which is published with --publish-cluster-trust-bundle "+
+			"before any certificate is picked up.")
+	flag.IntVar(&breakerThreshold, "circuit-breaker-failure-threshold", breaker.DefaultFailureThreshold,
+		"The number of consecutive failed calls to Venafi which open the circuit breaker of the "+backend.Venafi+
+			" backend, failing the calls without making them. 0 disables the circuit breaker.")
+	flag.DurationVar(&breakerOpenDuration, "circuit-breaker-open-duration", breaker.DefaultOpenDuration,
+		"How long the circuit breaker stays open before probing whether Venafi has recovered.")
+	flag.StringVar(&localCASecret, "local-ca-secret", "",
+		"The Secret, in the form <namespace>/<name>, containing the tls.crt and tls.key of the "+backend.LocalCA+" backend.")
+	flag.StringVar(&localCACertFile, "local-ca-cert-file", "",
+		"The PEM file containing the CA certificate of the "+backend.LocalCA+" backend, if --local-ca-secret is not set.")
+	flag.StringVar(&localCAKeyFile, "local-ca-key-file", "",
+		"The PEM file containing the CA private key of the "+backend.LocalCA+" backend, if --local-ca-secret is not set.")
+	flag.StringVar(&deniedOrganizations, "denied-organizations", strings.Join(policy.DefaultDeniedOrganizations, ","),
+		"Comma separated list of subject organizations which will never be signed. Must not be empty.")
+	flag.StringVar(&deniedCommonNames, "denied-common-names", strings.Join(policy.DefaultDeniedCommonNames, ","),
+		"Comma separated list of subject common names which will never be signed. Must not be empty.")
+	flag.StringVar(&allowedIdentities, "allowed-privileged-identities", "",
+		"Comma separated list of subject organizations or common names which are exempt from "+
+			"--denied-organizations and --denied-common-names.")
+	flag.StringVar(&spiffeTrustDomain, "spiffe-trust-domain", "",
+		"If set, only sign SPIFFE SVIDs in this trust domain, "+
+			"whose SPIFFE ID matches the ServiceAccount which created the CSR.")
+	flag.BoolVar(&dnsOwnership, "dns-ownership", false,
+		"Only sign DNS names which belong to Services in the namespace of the requesting ServiceAccount, "+
+			"or which are listed in the "+policy.AnnotationKeyAllowedDNSNames+" annotation of that namespace.")
+	flag.StringVar(&clusterDomain, "cluster-domain", "cluster.local", "The DNS domain of the cluster.")
+	flag.StringVar(&allowedKeyAlgorithms, "allowed-key-algorithms", strings.Join(policy.DefaultAllowedKeyAlgorithms, ","),
+		"Comma separated list of allowed CSR public key algorithms.")
+	flag.IntVar(&minRSAKeySize, "min-rsa-key-size", policy.DefaultMinRSAKeySize, "The minimum allowed RSA key size.")
+	flag.StringVar(&allowedCurves, "allowed-ecdsa-curves", strings.Join(policy.DefaultAllowedCurves, ","),
+		"Comma separated list of allowed CSR ECDSA curves.")
+	flag.StringVar(&allowedSigAlgorithms, "allowed-signature-algorithms", strings.Join(policy.DefaultAllowedSignatureAlgorithms, ","),
+		"Comma separated list of allowed CSR signature algorithms.")
+	flag.DurationVar(&minDuration, "min-duration", 0,
+		"The minimum certificate duration which may be requested with the "+capihelper.AnnotationKeyRequestDuration+
+			" annotation. If 0, there is no limit.")
+	flag.DurationVar(&maxDuration, "max-duration", 0,
+		"The maximum certificate duration which may be requested with the "+capihelper.AnnotationKeyRequestDuration+
+			" annotation. If 0, there is no limit.")
+	flag.StringVar(&caSignerNames, "ca-signer-names", "",
+		"Comma separated list of signer names which are configured for intermediate issuance. "+
+			"CA certificates requested with the "+capihelper.AnnotationKeyRequestIsCA+
+			" annotation are only signed for these signer names.")
+	flag.BoolVar(&intermediateCA, "intermediate-ca", false,
+		"Only sign subordinate CA certificates, constrained by --intermediate-max-path-len and "+
+			"--intermediate-permitted-dns-domains. --signer-name must also be listed in --ca-signer-names.")
+	flag.StringVar(&intermediateZone, "intermediate-zone", "",
+		"The Venafi zone, configured for CA templates, to which intermediate CA certificates are requested. "+
+			"Required with --intermediate-ca.")
+	flag.IntVar(&maxPathLen, "intermediate-max-path-len", 0,
+		"The maximum path length constraint of intermediate CA certificates.")
+	flag.StringVar(&permittedDNSDomains, "intermediate-permitted-dns-domains", "",
+		"Comma separated list of DNS suffixes within which each intermediate CA certificate must be "+
+			"constrained, using DNS name constraints. Required with --intermediate-ca.")
+	flag.StringVar(&approvalReasons, "approval-reasons", "",
+		"Comma separated list of Approved condition reasons which are accepted. E.g. AutoApproved. "+
+			"If empty, any reason is accepted.")
+	flag.StringVar(&approvalUsers, "approval-users", "",
+		"Comma separated list of users who may approve CSRs. E.g. system:kube-controller-manager. "+
+			"Enforced by the approval admission webhook. If empty, and --approval-groups is empty, any user may approve.")
+	flag.StringVar(&approvalGroups, "approval-groups", "",
+		"Comma separated list of groups whose members may approve CSRs. Enforced by the approval admission webhook.")
+	flag.IntVar(&requiredApprovers, "required-approvers", 0,
+		"The number of distinct approvers from --approvers who must approve each CSR, "+
+			"in addition to the Approved condition. If 0, no additional approval is required.")
+	flag.StringVar(&eligibleApprovers, "approvers", "",
+		"Comma separated list of approver names whose approval is counted towards --required-approvers.")
+	flag.DurationVar(&maxCSRAge, "max-csr-age", 0,
+		"Fail CSRs which are older than this when they would be signed. If 0, there is no limit.")
+	flag.DurationVar(&maxApprovalAge, "max-approval-age", 0,
+		"Fail CSRs which were approved longer ago than this when they would be signed. If 0, there is no limit.")
+	flag.BoolVar(&revokeOnDelete, "revoke-on-delete", false,
+		"Add a finalizer to each CSR so that its certificate is revoked, "+
+			"or its pending request is cancelled, when the CSR is deleted.")
+	flag.StringVar(&revocationReason, "revocation-reason", "cessation-of-operation",
+		"The reason used when revoking certificates of deleted CSRs, "+
+			"or when the revoke annotation has no value. "+
+			"One of none, key-compromise, ca-compromise, affiliation-changed, superseded or cessation-of-operation.")
+	flag.BoolVar(&revokeSuperseded, "revoke-superseded", false,
+		"Record each issued certificate against its subject, and revoke certificates "+
+			"which have been superseded by a newer certificate for the same subject.")
+	flag.DurationVar(&supersededOverlap, "superseded-overlap", time.Hour,
+		"The time for which a superseded certificate remains valid after its successor is issued.")
+	flag.StringVar(&recordsNamespace, "records-namespace", "signer-venafi-system",
+		"The namespace in which issuance records are stored.")
+	flag.DurationVar(&cleanerInterval, "csr-cleaner-interval", 10*time.Minute,
+		"The time between deletions of completed CSRs.")
+	flag.DurationVar(&issuedRetention, "issued-csr-retention", 0,
+		"Delete issued CSRs this long after they were created. If 0, they are not deleted.")
+	flag.DurationVar(&failedRetention, "failed-csr-retention", 0,
+		"Delete failed CSRs this long after they failed. If 0, they are not deleted.")
+	flag.DurationVar(&deniedRetention, "denied-csr-retention", 0,
+		"Delete denied CSRs this long after they were denied. If 0, they are not deleted.")
+	flag.BoolVar(&writeSecrets, "write-secrets", false,
+		"Also write each certificate into the Secret named by the "+secrets.AnnotationKeySecret+" CSR annotation, "+
+			"if the CSR requester is allowed to write that Secret.")
+	flag.BoolVar(&manageSecrets, "manage-secrets", false,
+		"Generate a private key and request a certificate, using a CSR with --signer-name, "+
+			"for each Secret labelled signer-venafi.cert-manager.io/managed=true and annotated with that signer name, "+
+			"and renew the certificate before it expires.")
+	flag.BoolVar(&secretsAutoApprove, "manage-secrets-auto-approve", false,
+		"Approve the CSRs created by --manage-secrets, once their DNS names have been checked against the namespace of the Secret.")
+	flag.StringVar(&issuerGroup, "issuer-group", "",
+		"If set, also sign cert-manager CertificateRequests whose issuer reference has this group, "+
+			"as if they were CSRs with --signer-name.")
+	flag.BoolVar(&crAutoApprove, "certificate-request-auto-approve", false,
+		"Treat CertificateRequests which have neither an Approved nor a Denied condition as approved, "+
+			"for versions of cert-manager older than v1.3, which do not approve CertificateRequests.")
+	flag.BoolVar(&publishCTB, "publish-cluster-trust-bundle", false,
+		"Publish the CA chain returned with issued certificates as a ClusterTrustBundle linked to --signer-name.")
+	flag.StringVar(&ctbAPIVersion, "cluster-trust-bundle-api-version", "certificates.k8s.io/v1alpha1",
+		"The API version of the ClusterTrustBundle resource served by the cluster.")
+	flag.DurationVar(&trustInterval, "trust-publish-interval", 10*time.Minute,
+		"The time between repairs of the published CA chain.")
+	flag.BoolVar(&distributeCABundle, "distribute-ca-bundle", false,
+		"Maintain a ConfigMap containing the CA chain returned with issued certificates in every namespace "+
+			"matching --ca-bundle-namespace-selector.")
+	flag.StringVar(&caBundleSelector, "ca-bundle-namespace-selector", "",
+		"The label selector of the namespaces which receive the CA bundle ConfigMap. If empty, all namespaces are selected.")
+	flag.StringVar(&chainMode, "chain", string(chain.ModeLeaf),
+		fmt.Sprintf("The CA certificates which follow the leaf certificate in the CSR status. One of %v.", chain.Modes))
+	flag.BoolVar(&chainRootFirst, "chain-root-first", false,
+		"Order the CA certificates following the leaf certificate from the root to the issuer of the leaf, "+
+			"instead of from the issuer of the leaf to the root.")
+	flag.StringVar(&trustAnchorsFile, "trust-anchors-file", "",
+		"A PEM file containing the root certificates to which each issued certificate must be validated. "+
+			"Required unless --chain is "+string(chain.ModeLeaf)+".")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(debugLogging)))
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   leaderElectionID,
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		HealthProbeBindAddress: healthAddr,
+		Port:                   9443,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       leaderElectionID,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	vcertConfig := &vcert.Config{
-		ConfigFile: vcertConfigPath,
+	if err := signer.ValidateRevocationReason(revocationReason); err != nil {
+		setupLog.Error(err, "invalid --revocation-reason")
+		os.Exit(1)
 	}
-	err = vcertConfig.LoadFromFile()
-	if err != nil {
-		setupLog.Error(err, "unable load vcert config file", "vcert-config-path", vcertConfigPath)
+
+	// The policies which are configured by flags for every signer name. Those
+	// configured by the settings of each signer name are added by
+	// signerPolicies.
+	policies := []policy.Policy{
+		&policy.Request{
+			MinDuration:   minDuration,
+			MaxDuration:   maxDuration,
+			CASignerNames: capihelper.SplitList(caSignerNames),
+		},
+	}
+	if spiffeTrustDomain != "" {
+		policies = append(policies, &policy.SPIFFE{TrustDomain: spiffeTrustDomain})
+	}
+	if dnsOwnership {
+		dnsOwnershipPolicy := &policy.DNSOwnership{
+			Client:        mgr.GetClient(),
+			ClusterDomain: clusterDomain,
+		}
+		if manageSecrets {
+			dnsOwnershipPolicy.SecretReader = mgr.GetAPIReader()
+		}
+		policies = append(policies, dnsOwnershipPolicy)
+	}
+
+	// The policies of the signer names other than --signer-name, which only
+	// sign CSRs.
+	basePolicies := policies
+
+	if intermediateCA {
+		switch {
+		case !capihelper.Contains(capihelper.SplitList(caSignerNames), signerName):
+			err = fmt.Errorf("--signer-name %q is not listed in --ca-signer-names", signerName)
+		case intermediateZone == "":
+			err = fmt.Errorf("--intermediate-zone is required")
+		case len(capihelper.SplitList(permittedDNSDomains)) == 0:
+			err = fmt.Errorf("--intermediate-permitted-dns-domains is required")
+		}
+		if err != nil {
+			setupLog.Error(err, "invalid intermediate CA configuration")
+			os.Exit(1)
+		}
+		policies = append(policies, &policy.Intermediate{
+			MaxPathLen:          maxPathLen,
+			PermittedDNSDomains: capihelper.SplitList(permittedDNSDomains),
+		})
+	}
+
+	var caChain *trust.Chain
+	if publishCTB || distributeCABundle {
+		caChain = &trust.Chain{}
+	}
+
+	// The flags set the defaults of the settings of each signer name.
+	defaultSettings := backend.Settings{
+		Policy: backend.PolicyConfig{
+			DeniedOrganizations:         capihelper.SplitList(deniedOrganizations),
+			DeniedCommonNames:           capihelper.SplitList(deniedCommonNames),
+			AllowedPrivilegedIdentities: capihelper.SplitList(allowedIdentities),
+			AllowedKeyAlgorithms:        capihelper.SplitList(allowedKeyAlgorithms),
+			MinRSAKeySize:               minRSAKeySize,
+			AllowedECDSACurves:          capihelper.SplitList(allowedCurves),
+			AllowedSignatureAlgorithms:  capihelper.SplitList(allowedSigAlgorithms),
+		},
+		Approval: backend.ApprovalConfig{
+			Reasons:           capihelper.SplitList(approvalReasons),
+			Users:             capihelper.SplitList(approvalUsers),
+			Groups:            capihelper.SplitList(approvalGroups),
+			RequiredApprovers: requiredApprovers,
+			Approvers:         capihelper.SplitList(eligibleApprovers),
+		},
+		MaxCSRAge:      metav1.Duration{Duration: maxCSRAge},
+		MaxApprovalAge: metav1.Duration{Duration: maxApprovalAge},
+		Chain: backend.ChainConfig{
+			Mode:             chain.Mode(chainMode),
+			RootFirst:        chainRootFirst,
+			TrustAnchorsFile: trustAnchorsFile,
+		},
+	}
+	registry := backend.NewRegistry()
+	var (
+		signerConfigs []backend.SignerConfig
+		configErrs    []error
+	)
+	if backendConfigPath != "" {
+		signerConfigs, err = registry.LoadFile(backendConfigPath, defaultSettings)
+		if err != nil {
+			configErrs = append(configErrs, err)
+		}
+	}
+	if !configuresSigner(signerConfigs, signerName) {
+		// The backends without flags can only be configured by
+		// --backend-config, and are reported as missing their config.
+		sc := backend.SignerConfig{SignerName: signerName, Backend: backendName, Settings: defaultSettings}
+		switch backendName {
+		case backend.Venafi:
+			sc.Config = &backend.VcertConfig{
+				ConfigFile:  vcertConfigPath,
+				CAChainFile: vcertCAChainPath,
+				CircuitBreaker: backend.CircuitBreakerConfig{
+					FailureThreshold: breakerThreshold,
+					OpenDuration:     metav1.Duration{Duration: breakerOpenDuration},
+				},
+			}
+		case backend.LocalCA:
+			sc.Config = &backend.LocalCAConfig{Secret: localCASecret, CertFile: localCACertFile, KeyFile: localCAKeyFile}
+		case backend.Vault:
+			sc.Config = &backend.VaultConfig{
+				Address:             vaultAddress,
+				PKIPath:             vaultPKIPath,
+				Role:                vaultRole,
+				Auth:                vaultAuth,
+				AuthPath:            vaultAuthPath,
+				KubernetesRole:      vaultK8sRole,
+				KubernetesTokenFile: vaultTokenFile,
+				AppRoleRoleID:       vaultRoleID,
+				AppRoleSecretIDFile: vaultSecretIDFile,
+				CAFile:              vaultCAFile,
+			}
+		case backend.Plugin:
+			sc.Config = &backend.PluginConfig{Socket: pluginSocket, Timeout: metav1.Duration{Duration: pluginTimeout}}
+		}
+		signerConfigs = append([]backend.SignerConfig{sc}, signerConfigs...)
+	}
+	if err := registry.Validate(signerConfigs); err != nil {
+		configErrs = append(configErrs, err)
+	}
+	if err := utilerrors.NewAggregate(configErrs); err != nil {
+		setupLog.Error(err, "invalid backend configuration")
 		os.Exit(1)
 	}
 
-	signer := &venafi.Signer{
-		ClientFactory: func() (endpoint.Connector, error) {
-			vcertClient, err := vcert.NewClient(vcertConfig)
+	// signers holds the signer of each signer name, and backendSigners the
+	// signer of its backend, without the shadow signer.
+	signers := map[string]signer.Signer{}
+	backendSigners := map[string]signer.Signer{}
+	// The health of the backends is served separately from the readiness
+	// probe, so that an unavailable backend does not remove the pod from the
+	// endpoints of the approval webhook, which would block every change to
+	// CSRs, because its failurePolicy is Fail.
+	signerChecks := map[string]healthz.Checker{}
+	for _, sc := range signerConfigs {
+		assembler, err := newChainAssembler(sc.Settings.Chain)
+		if err != nil {
+			configErrs = append(configErrs, fmt.Errorf("signer %q: chain: %v", sc.SignerName, err))
+			continue
+		}
+		opts := backend.Options{
+			SignerName: sc.SignerName,
+			Log:        ctrl.Log.WithName("signer").WithName(sc.Backend).WithName("Signer").WithValues("signer-name", sc.SignerName),
+			Reader:     mgr.GetAPIReader(),
+			Assembler:  assembler,
+		}
+		if sc.SignerName == signerName {
+			opts.Chain = caChain
+		}
+		s, err := registry.New(sc, opts)
+		if err != nil {
+			configErrs = append(configErrs, err)
+			continue
+		}
+		if h, ok := s.(signer.HealthChecker); ok {
+			signerChecks["signer-"+strings.ReplaceAll(sc.SignerName, "/", ":")] = func(req *http.Request) error {
+				return h.Health(req.Context())
+			}
+		}
+		backendSigners[sc.SignerName] = s
+		if sc.Shadow != nil {
+			shadowLog := ctrl.Log.WithName("signer").WithName("shadow").WithValues("signer-name", sc.SignerName)
+			opts.Instance = "shadow"
+			opts.Log = shadowLog.WithName(sc.Shadow.Backend).WithName("Signer")
+			// Only the certificates of the primary are published, so the
+			// shadow signer is not given the CA chain. Its chain is assembled
+			// in the same way, so that the chains can be compared, but it is
+			// not validated to the trust anchors of the primary, which need
+			// not have issued it.
+			opts.Chain = nil
+			if assembler != nil {
+				opts.Assembler = &chain.Assembler{Mode: assembler.Mode, RootFirst: assembler.RootFirst}
+			}
+			shadowSigner, err := registry.NewShadow(sc, opts)
 			if err != nil {
-				return nil, fmt.Errorf("error initialising vcert client: %v", err)
+				configErrs = append(configErrs, fmt.Errorf("shadow: %v", err))
+				continue
 			}
-			return vcertClient, nil
-		},
-		Log: ctrl.Log.WithName("signer").WithName("venafi").WithName("Signer"),
+			s = &shadow.Signer{
+				Primary:           s,
+				Shadow:            shadowSigner,
+				SignerName:        sc.SignerName,
+				Log:               shadowLog.WithName("Signer"),
+				Recorder:          mgr.GetEventRecorderFor("signer-venafi"),
+				Timeout:           sc.Shadow.Timeout,
+				ValidityTolerance: sc.Shadow.ValidityTolerance,
+			}
+		}
+		signers[sc.SignerName] = s
+	}
+	if err := utilerrors.NewAggregate(configErrs); err != nil {
+		setupLog.Error(err, "unable to create signers")
+		os.Exit(1)
+	}
+	if err := mgr.AddMetricsExtraHandler(signerHealthPath,
+		http.StripPrefix(signerHealthPath, &healthz.Handler{Checks: signerChecks})); err != nil {
+		setupLog.Error(err, "unable to add signer health endpoint")
+		os.Exit(1)
+	}
+
+	primary := signerConfigs[0]
+	for _, sc := range signerConfigs {
+		if sc.SignerName == signerName {
+			primary = sc
+		}
+	}
+	backendSigner := backendSigners[signerName]
+
+	csrFilter := newCSRFilter(signerName, primary.Settings)
+	// The approver annotations of CertificateRequests are set by their
+	// requester, so CertificateRequests can not satisfy a quorum, and the
+	// auto-approved ones are approved by nobody.
+	if issuerGroup != "" && csrFilter.Quorum != nil {
+		setupLog.Error(fmt.Errorf("--issuer-group can not be used with required approvers"), "invalid approval configuration")
+		os.Exit(1)
+	}
+	if crAutoApprove && (len(csrFilter.ApprovalRules.Users) > 0 || len(csrFilter.ApprovalRules.Groups) > 0) {
+		setupLog.Error(fmt.Errorf("--certificate-request-auto-approve can not be used with approval users or groups"),
+			"invalid approval configuration")
+		os.Exit(1)
+	}
+	revoker, _ := backendSigner.(signer.Revoker)
+	if revoker == nil && (revokeOnDelete || revokeSuperseded) {
+		setupLog.Error(fmt.Errorf("the %s backend does not support revocation", primary.Backend),
+			"invalid revocation configuration")
+		os.Exit(1)
+	}
+	if intermediateCA {
+		venafiSigner, ok := backendSigner.(*venafi.Signer)
+		if !ok {
+			setupLog.Error(fmt.Errorf("--intermediate-ca is only supported by the %s, %s and %s backends",
+				backend.Venafi, backend.VenafiTPP, backend.VenafiCloud), "invalid intermediate CA configuration")
+			os.Exit(1)
+		}
+		venafiSigner.Zone = intermediateZone
+		venafiSigner.IssuesCA = true
+	}
+
+	policySigner := &policy.Signer{
+		Signer:   signers[signerName],
+		Policies: append(signerPolicies(primary.Settings), policies...),
+	}
+
+	var issuanceRecords *records.Store
+	if revokeSuperseded {
+		recordsCache, recordsClient, err := records.NewCache(mgr, recordsNamespace)
+		if err != nil {
+			setupLog.Error(err, "unable to create issuance record cache")
+			os.Exit(1)
+		}
+		issuanceRecords = &records.Store{
+			Client:    recordsClient,
+			Namespace: recordsNamespace,
+		}
+		if err = (&controllers.IssuanceRecordReconciler{
+			Client:     recordsClient,
+			Cache:      recordsCache,
+			Log:        ctrl.Log.WithName("controllers").WithName("IssuanceRecordReconciler"),
+			Revoker:    revoker,
+			SignerName: signerName,
+			Namespace:  recordsNamespace,
+			Overlap:    supersededOverlap,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "IssuanceRecordReconciler")
+			os.Exit(1)
+		}
+	}
+
+	var secretWriter *secrets.Writer
+	if writeSecrets {
+		secretWriter = &secrets.Writer{
+			Client: mgr.GetClient(),
+			Reader: mgr.GetAPIReader(),
+		}
 	}
 
 	if err = (&controllers.CertificateSigningRequestReconciler{
-		Client:     mgr.GetClient(),
-		Log:        ctrl.Log.WithName("controllers").WithName("CertificateSigningRequestReconciler"),
-		Scheme:     mgr.GetScheme(),
-		Signer:     signer,
-		SignerName: signerName,
+		Client:           mgr.GetClient(),
+		Log:              ctrl.Log.WithName("controllers").WithName("CertificateSigningRequestReconciler"),
+		Scheme:           mgr.GetScheme(),
+		Signer:           policySigner,
+		SignerName:       signerName,
+		Filter:           csrFilter,
+		MaxAge:           primary.Settings.MaxCSRAge.Duration,
+		MaxApprovalAge:   primary.Settings.MaxApprovalAge.Duration,
+		Revoker:          revoker,
+		RevocationReason: revocationReason,
+		RevokeOnDelete:   revokeOnDelete,
+		Records:          issuanceRecords,
+		Secrets:          secretWriter,
+		IgnoreDuration:   ignoresDuration(backendSigner),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CertificateSigningRequestReconciler")
 		os.Exit(1)
 	}
+	filters := map[string]*filter.CSRFilter{signerName: csrFilter}
+	for _, sc := range signerConfigs {
+		if sc.SignerName == signerName {
+			continue
+		}
+		f := newCSRFilter(sc.SignerName, sc.Settings)
+		filters[sc.SignerName] = f
+		if err = (&controllers.CertificateSigningRequestReconciler{
+			Client: mgr.GetClient(),
+			Log: ctrl.Log.WithName("controllers").WithName("CertificateSigningRequestReconciler").
+				WithValues("signer-name", sc.SignerName),
+			Scheme: mgr.GetScheme(),
+			Signer: &policy.Signer{
+				Signer:   signers[sc.SignerName],
+				Policies: append(signerPolicies(sc.Settings), basePolicies...),
+			},
+			SignerName:     sc.SignerName,
+			Filter:         f,
+			ControllerName: "certificatesigningrequest-" + strings.ReplaceAll(sc.SignerName, "/", "-"),
+			MaxAge:         sc.Settings.MaxCSRAge.Duration,
+			MaxApprovalAge: sc.Settings.MaxApprovalAge.Duration,
+			Secrets:        secretWriter,
+			IgnoreDuration: ignoresDuration(backendSigners[sc.SignerName]),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CertificateSigningRequestReconciler",
+				"signer-name", sc.SignerName)
+			os.Exit(1)
+		}
+	}
+	// The approvers of CSRs are not recorded in the CSRs, so they are checked
+	// by the approval webhook, which is required by these rules. The manager
+	// fails to start if the webhook serving certificate is missing.
+	for _, f := range filters {
+		if len(f.ApprovalRules.Users) > 0 || len(f.ApprovalRules.Groups) > 0 || f.Quorum != nil {
+			mgr.GetWebhookServer().Register(approval.Path, &webhook.Admission{Handler: &approval.Validator{Filters: filters}})
+			break
+		}
+	}
+	if issuedRetention > 0 || failedRetention > 0 || deniedRetention > 0 {
+		signerNames := []string{signerName}
+		for _, sc := range signerConfigs {
+			if sc.SignerName != signerName {
+				signerNames = append(signerNames, sc.SignerName)
+			}
+		}
+		if err := mgr.Add(&controllers.CSRCleaner{
+			Client:          mgr.GetClient(),
+			Log:             ctrl.Log.WithName("controllers").WithName("CSRCleaner"),
+			SignerNames:     signerNames,
+			Interval:        cleanerInterval,
+			IssuedRetention: issuedRetention,
+			FailedRetention: failedRetention,
+			DeniedRetention: deniedRetention,
+		}); err != nil {
+			setupLog.Error(err, "unable to add CSR cleaner")
+			os.Exit(1)
+		}
+	}
+	chainReader, _ := backendSigner.(signer.ChainReader)
+	if publishCTB {
+		if err := mgr.Add(&controllers.ClusterTrustBundlePublisher{
+			Client:     mgr.GetClient(),
+			Log:        ctrl.Log.WithName("controllers").WithName("ClusterTrustBundlePublisher"),
+			SignerName: signerName,
+			APIVersion: ctbAPIVersion,
+			Chain:      caChain,
+			Source:     chainReader,
+			Interval:   trustInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to add ClusterTrustBundle publisher")
+			os.Exit(1)
+		}
+	}
+	if distributeCABundle {
+		selector, err := labels.Parse(caBundleSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid --ca-bundle-namespace-selector")
+			os.Exit(1)
+		}
+		if err = (&controllers.CABundleReconciler{
+			Client:     mgr.GetClient(),
+			Log:        ctrl.Log.WithName("controllers").WithName("CABundleReconciler"),
+			SignerName: signerName,
+			Chain:      caChain,
+			Source:     chainReader,
+			Selector:   selector,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CABundleReconciler")
+			os.Exit(1)
+		}
+	}
+	if issuerGroup != "" {
+		// The approvals of CertificateRequests are checked by their own
+		// webhook, because the CSR webhook never sees them.
+		if len(csrFilter.ApprovalRules.Users) > 0 || len(csrFilter.ApprovalRules.Groups) > 0 {
+			mgr.GetWebhookServer().Register(approval.CertificateRequestPath, &webhook.Admission{Handler: &approval.CertificateRequestValidator{
+				IssuerGroup:   issuerGroup,
+				ApprovalRules: csrFilter.ApprovalRules,
+			}})
+		}
+		if err = (&controllers.CertificateRequestReconciler{
+			Client:           mgr.GetClient(),
+			Log:              ctrl.Log.WithName("controllers").WithName("CertificateRequestReconciler"),
+			Signer:           policySigner,
+			SignerName:       signerName,
+			IssuerGroup:      issuerGroup,
+			Filter:           csrFilter,
+			AutoApprove:      crAutoApprove,
+			Revoker:          revoker,
+			RevocationReason: revocationReason,
+			IgnoreDuration:   ignoresDuration(backendSigner),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CertificateRequestReconciler")
+			os.Exit(1)
+		}
+	}
+	if manageSecrets {
+		var approver certificatesclient.CertificateSigningRequestInterface
+		if secretsAutoApprove {
+			clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+			if err != nil {
+				setupLog.Error(err, "unable to create clientset")
+				os.Exit(1)
+			}
+			approver = clientset.CertificatesV1beta1().CertificateSigningRequests()
+		}
+		if err = (&controllers.SecretReconciler{
+			Client:     mgr.GetClient(),
+			Log:        ctrl.Log.WithName("controllers").WithName("SecretReconciler"),
+			SignerName: signerName,
+			Approver:   approver,
+			Ownership: &policy.DNSOwnership{
+				Client:        mgr.GetClient(),
+				ClusterDomain: clusterDomain,
+			},
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "SecretReconciler")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -119,3 +786,81 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// newChainAssembler returns a chain.Assembler which validates certificates to
+// the trust anchors in the PEM file of a valid ChainConfig, or nil if only the
+// leaf certificate is returned, without validating it.
+func newChainAssembler(config backend.ChainConfig) (*chain.Assembler, error) {
+	if config.Mode == chain.ModeLeaf && config.TrustAnchorsFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(config.TrustAnchorsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trust anchors: %v", err)
+	}
+	anchors := x509.NewCertPool()
+	if !anchors.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("trust anchors file %s contains no certificates", config.TrustAnchorsFile)
+	}
+	return &chain.Assembler{
+		Mode:         config.Mode,
+		RootFirst:    config.RootFirst,
+		TrustAnchors: anchors,
+	}, nil
+}
+
+// signerPolicies returns the policies configured by the settings of a signer
+// name, which are checked before those configured by flags.
+func signerPolicies(settings backend.Settings) []policy.Policy {
+	return []policy.Policy{
+		&policy.PrivilegedIdentities{
+			DeniedOrganizations: settings.Policy.DeniedOrganizations,
+			DeniedCommonNames:   settings.Policy.DeniedCommonNames,
+			Allowed:             settings.Policy.AllowedPrivilegedIdentities,
+		},
+		&policy.PublicKey{
+			AllowedAlgorithms:          settings.Policy.AllowedKeyAlgorithms,
+			MinRSAKeySize:              settings.Policy.MinRSAKeySize,
+			AllowedCurves:              settings.Policy.AllowedECDSACurves,
+			AllowedSignatureAlgorithms: settings.Policy.AllowedSignatureAlgorithms,
+		},
+	}
+}
+
+// newCSRFilter returns the filter of the CSRs of a signer name, which accepts
+// the approvals allowed by its settings.
+func newCSRFilter(signerName string, settings backend.Settings) *filter.CSRFilter {
+	f := &filter.CSRFilter{
+		SignerName: signerName,
+		ApprovalRules: &filter.ApprovalRules{
+			Reasons: settings.Approval.Reasons,
+			Users:   settings.Approval.Users,
+			Groups:  settings.Approval.Groups,
+		},
+	}
+	if settings.Approval.RequiredApprovers > 0 {
+		f.Quorum = &filter.Quorum{
+			Required:  settings.Approval.RequiredApprovers,
+			Approvers: settings.Approval.Approvers,
+		}
+	}
+	return f
+}
+
+// configuresSigner returns true if signerName is configured by one of the
+// signers.
+func configuresSigner(signers []backend.SignerConfig, signerName string) bool {
+	for _, sc := range signers {
+		if sc.SignerName == signerName {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoresDuration returns true if the signer of a backend ignores the
+// requested duration.
+func ignoresDuration(s signer.Signer) bool {
+	d, ok := s.(signer.DurationIgnorer)
+	return ok && d.IgnoresDuration()
+}